@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+
+	"l0-demo/internal/configs"
+	"l0-demo/internal/export"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/postgres"
+	"l0-demo/internal/repository/sqlite"
+	"l0-demo/internal/repository/storage"
+)
+
+// export dumps the stored orders for analysis as NDJSON, or as CSV or
+// Parquet with one row per item, to a file or to stdout. Orders are streamed
+// in batches, so a dump of any size runs in constant memory.
+func main() {
+	format := flag.String("format", string(export.NDJSON), "output format: ndjson, csv or parquet")
+	out := flag.String("out", "-", "output file, - for stdout")
+	compress := flag.Bool("gzip", false, "gzip the output; parquet compresses its columns instead")
+	from := flag.String("from", "", "only orders created at or after this date (2006-01-02 or RFC 3339)")
+	to := flag.String("to", "", "only orders created before this date (2006-01-02 or RFC 3339)")
+	customer := flag.String("customer", "", "only orders of this customer_id")
+	deliveryService := flag.String("service", "", "only orders of this delivery_service")
+	includeDeleted := flag.Bool("include-deleted", false, "export soft-deleted orders as well")
+	anonymize := flag.Bool("anonymize", false, "replace the name, phone, address and email of deliveries with placeholders")
+	batch := flag.Int("batch", storage.DefaultBatchSize, "orders loaded per batch")
+	flag.Parse()
+
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	opts := export.Options{
+		Filter: storage.OrderFilter{
+			CustomerId:      *customer,
+			DeliveryService: *deliveryService,
+		},
+		IncludeDeleted: *includeDeleted,
+		Anonymize:      *anonymize,
+		BatchSize:      *batch,
+	}
+	if opts.Filter.From, err = parseDate(*from); err != nil {
+		logrus.Fatalf("invalid -from: %s", err)
+	}
+	if opts.Filter.To, err = parseDate(*to); err != nil {
+		logrus.Fatalf("invalid -to: %s", err)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("failed to load .env: %s", err)
+	}
+	cfg, err := configs.LoadConfig(".")
+	if err != nil {
+		logrus.Fatalf("config load: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	n, err := run(ctx, cfg, f, *out, *compress, opts)
+	if err != nil {
+		logrus.Fatalf("export: %s", err)
+	}
+	logrus.Printf("exported %d orders as %s to %s", n, f, *out)
+}
+
+func run(ctx context.Context, cfg configs.Config, f export.Format, out string, compress bool, opts export.Options) (int, error) {
+	src, closeSrc, err := openSource(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer closeSrc()
+
+	dst, commit, discard, err := create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer discard()
+
+	w, err := export.NewWriter(f, dst, compress)
+	if err != nil {
+		return 0, err
+	}
+	n, err := export.Orders(ctx, src, w, opts)
+	if err != nil {
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+	return n, commit()
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// openSource connects to the order store selected by DB_DRIVER. The gorm
+// store serves the pgx driver as well, since an export only streams.
+func openSource(ctx context.Context, cfg configs.Config) (export.Source, func(), error) {
+	if cfg.DbDriver == "sqlite" {
+		sdb, err := sqlite.Open(ctx, cfg.SqlitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sqlite.NewOrderSqlite(sdb), func() { sdb.Close() }, nil
+	}
+
+	opts := []postgres.Option{postgres.WithTimeouts(postgres.Timeouts{
+		Read: time.Duration(cfg.PostgresReadTimeoutMillis) * time.Millisecond,
+	})}
+	if cfg.PiiKeyringPath != "" {
+		ring, err := pii.LoadKeyring(cfg.PiiKeyringPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, postgres.WithKeyring(ring))
+	}
+	db, err := postgres.ConnectDB(ctx, postgres.Config{
+		URL:              cfg.PgDSN(),
+		MaxOpenConns:     1,
+		MaxIdleConns:     1,
+		StatementTimeout: time.Duration(cfg.PostgresStmtTimeoutMillis) * time.Millisecond,
+		ConnectRetry:     time.Duration(cfg.PostgresConnectRetrySec) * time.Second,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return postgres.NewOrderPostgres(db, opts...), func() { db.Close() }, nil
+}
+
+// create opens the output. A file is written under a temporary name and only
+// renamed into place by commit, so a failed export leaves nothing behind:
+// discard removes the temporary file unless commit moved it.
+func create(path string) (w io.Writer, commit func() error, discard func(), err error) {
+	if path == "-" {
+		return os.Stdout, func() error { return nil }, func() {}, nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	commit = func() error {
+		if err := tmp.Sync(); err != nil {
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	}
+	discard = func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	return tmp, commit, discard, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+
+	"l0-demo/internal/configs"
+	"l0-demo/internal/importer"
+	"l0-demo/internal/repository"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/postgres"
+	"l0-demo/internal/repository/sqlite"
+	"l0-demo/internal/service"
+)
+
+// import loads orders from NDJSON or JSON array files, or from stdin, straight
+// into the order store, checking each one like the subscriber does. Rejected
+// records are listed in an NDJSON report with their file and line.
+func main() {
+	batch := flag.Int("batch", importer.DefaultBatchSize, "orders written per transaction")
+	dryRun := flag.Bool("dry-run", false, "only decode and validate, do not write")
+	resumeFrom := flag.Int("resume-from", 0, "skip the records of the first input that start before this line")
+	reportPath := flag.String("report", "-", "file to write the rejected records to as NDJSON, - for stdout")
+	profile := flag.String("profile", "", "validation profile to check the records with, from VALIDATION_PROFILES_PATH")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [file ...]\n\nReads stdin when no file or - is given.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("failed to load .env: %s", err)
+	}
+	cfg, err := configs.LoadConfig(".")
+	if err != nil {
+		logrus.Fatalf("config load: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := importer.Options{BatchSize: *batch, DryRun: *dryRun, ResumeFrom: *resumeFrom}
+	failed, err := run(ctx, cfg, inputs, *reportPath, *profile, opts)
+	if err != nil {
+		logrus.Fatalf("import: %s", err)
+	}
+	if failed > 0 {
+		logrus.Fatalf("%d records were rejected, see the report", failed)
+	}
+}
+
+func run(ctx context.Context, cfg configs.Config, inputs []string, reportPath, profile string, opts importer.Options) (int, error) {
+	ruleActions, err := service.ParseRuleActions(cfg.OrderRules)
+	if err != nil {
+		return 0, fmt.Errorf("ORDER_RULES: %w", err)
+	}
+	svcOpts := []service.Option{service.WithRuleActions(ruleActions)}
+	var unchecked map[string][]string
+	if cfg.ValidationProfilesPath != "" {
+		profiles, err := service.LoadProfiles(cfg.ValidationProfilesPath)
+		if err != nil {
+			return 0, fmt.Errorf("VALIDATION_PROFILES_PATH: %w", err)
+		}
+		if profile != "" {
+			if !profiles.Has(profile) {
+				return 0, fmt.Errorf("no validation profile %q in %s", profile, cfg.ValidationProfilesPath)
+			}
+			ctx = service.WithProfile(ctx, profile)
+		}
+		svcOpts = append(svcOpts, service.WithProfiles(profiles))
+		unchecked = profiles.Overrides()
+	} else if profile != "" {
+		return 0, fmt.Errorf("-profile needs VALIDATION_PROFILES_PATH")
+	}
+	repo := &repository.Repository{}
+	if !opts.DryRun {
+		r, closeRepo, err := openRepository(ctx, cfg, unchecked)
+		if err != nil {
+			return 0, err
+		}
+		defer closeRepo()
+		repo = r
+	}
+	svc := service.NewService(repo, svcOpts...)
+
+	report, closeReport, err := createReport(reportPath)
+	if err != nil {
+		return 0, err
+	}
+	defer closeReport()
+
+	failed := 0
+	for i, name := range inputs {
+		if i > 0 {
+			opts.ResumeFrom = 0
+		}
+		sum, err := importFile(ctx, svc, name, report, opts)
+		failed += sum.Failed
+		logrus.WithField("file", name).WithField("read", sum.Read).WithField("imported", sum.Imported).
+			WithField("failed", sum.Failed).WithField("skipped", sum.Skipped).WithField("dry_run", opts.DryRun).
+			Info("import finished")
+		if err != nil {
+			return failed, fmt.Errorf("%s: %w; continue with -resume-from %d %s", name, err, sum.Resume, name)
+		}
+	}
+	return failed, nil
+}
+
+// failure is a line of the report.
+type failure struct {
+	File string `json:"file"`
+	importer.Failure
+}
+
+func importFile(ctx context.Context, svc *service.Service, name string, report *json.Encoder, opts importer.Options) (importer.Summary, error) {
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return importer.Summary{}, err
+		}
+		defer f.Close()
+		in = f
+	}
+	return importer.Import(ctx, in, svc, func(f importer.Failure) error {
+		return report.Encode(failure{File: name, Failure: f})
+	}, opts)
+}
+
+func createReport(path string) (*json.Encoder, func(), error) {
+	if path == "-" {
+		return json.NewEncoder(os.Stdout), func() {}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return json.NewEncoder(f), func() { f.Close() }, nil
+}
+
+// openRepository connects to the order store selected by DB_DRIVER. Orders
+// are written through gorm for the pgx driver as well, since both store the
+// same rows. The checks of the unchecked columns are left out of the schema.
+func openRepository(ctx context.Context, cfg configs.Config, unchecked map[string][]string) (*repository.Repository, func(), error) {
+	if cfg.DbDriver == "sqlite" {
+		sdb, err := sqlite.Open(ctx, cfg.SqlitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repository.NewSqliteRepository(sdb), func() { sdb.Close() }, nil
+	}
+
+	opts := []postgres.Option{postgres.WithTimeouts(postgres.Timeouts{
+		Read:  time.Duration(cfg.PostgresReadTimeoutMillis) * time.Millisecond,
+		Write: time.Duration(cfg.PostgresWriteTimeoutMillis) * time.Millisecond,
+	})}
+	if cfg.PiiKeyringPath != "" {
+		ring, err := pii.LoadKeyring(cfg.PiiKeyringPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, postgres.WithKeyring(ring))
+	}
+	db, err := postgres.ConnectDB(ctx, postgres.Config{
+		URL:              cfg.PgDSN(),
+		MaxOpenConns:     1,
+		MaxIdleConns:     1,
+		StatementTimeout: time.Duration(cfg.PostgresStmtTimeoutMillis) * time.Millisecond,
+		ConnectRetry:     time.Duration(cfg.PostgresConnectRetrySec) * time.Second,
+		UncheckedColumns: unchecked,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return repository.NewRepository(db, opts...), func() { db.Close() }, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+
+	"l0-demo/internal/configs"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/postgres"
+	"l0-demo/internal/repository/storage"
+)
+
+// reencrypt seals the personal data stored in clear, or with a key other
+// than the primary one of PII_KEYRING_PATH, with the primary key. Run it
+// after turning encryption on and after every key rotation; once it is done,
+// the keys it rotated away from can be removed from the keyring.
+func main() {
+	batch := flag.Int("batch", storage.DefaultBatchSize, "rows rewritten per transaction")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		logrus.Fatalf("failed to load .env: %s", err)
+	}
+	cfg, err := configs.LoadConfig(".")
+	if err != nil {
+		logrus.Fatalf("config load: %s", err)
+	}
+	if cfg.PiiKeyringPath == "" {
+		logrus.Fatal("PII_KEYRING_PATH is not set")
+	}
+	ring, err := pii.LoadKeyring(cfg.PiiKeyringPath)
+	if err != nil {
+		logrus.Fatalf("pii keyring: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := postgres.ConnectDB(ctx, postgres.Config{
+		URL:              cfg.PgDSN(),
+		MaxOpenConns:     1,
+		MaxIdleConns:     1,
+		StatementTimeout: time.Duration(cfg.PostgresStmtTimeoutMillis) * time.Millisecond,
+		ConnectRetry:     time.Duration(cfg.PostgresConnectRetrySec) * time.Second,
+	})
+	if err != nil {
+		logrus.Fatalf("postgres connect: %s", err)
+	}
+	defer db.Close()
+
+	repo := postgres.NewOrderPostgres(db,
+		postgres.WithKeyring(ring),
+		postgres.WithTimeouts(postgres.Timeouts{
+			Write: time.Duration(cfg.PostgresWriteTimeoutMillis) * time.Millisecond,
+		}),
+	)
+	rep, err := repo.Reencrypt(ctx, *batch)
+	logrus.Printf("re-encrypted with key %s: %d deliveries, %d revisions, %d messages",
+		ring.Primary(), rep.Deliveries, rep.Revisions, rep.Messages)
+	if err != nil {
+		logrus.Fatalf("re-encrypt: %s", err)
+	}
+}
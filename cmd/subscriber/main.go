@@ -7,7 +7,9 @@ import (
 	httpdelivery "l0-demo/internal/delivery/http"
 	"l0-demo/internal/delivery/kafka"
 	"l0-demo/internal/repository"
+	"l0-demo/internal/repository/pii"
 	"l0-demo/internal/repository/postgres"
+	"l0-demo/internal/repository/sqlite"
 	"l0-demo/internal/service"
 	"os"
 	"os/signal"
@@ -42,32 +44,75 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	db, err := postgres.ConnectDB(postgres.Config{
-		Host:     cfg.PostgresHost,
-		Port:     cfg.PostgresPort,
-		Username: cfg.PostgresUser,
-		Password: cfg.PostgresPass,
-		DbName:   cfg.PostgresDB,
-		SslMode:  cfg.PostgresSSLMode,
-	})
-	if err != nil {
-		logrus.Fatalf("postgres connect: %s", err)
-	}
-	defer func() {
-		if derr := db.Close(); derr != nil {
-			logrus.Errorf("db close: %v", derr)
+	var profiles *service.Profiles
+	var unchecked map[string][]string
+	if cfg.ValidationProfilesPath != "" {
+		profiles, err = service.LoadProfiles(cfg.ValidationProfilesPath)
+		if err != nil {
+			logrus.Fatalf("validation profiles: %s", err)
 		}
-	}()
-	logrus.Print("connected to postgres")
+		unchecked = profiles.Overrides()
+		logrus.Printf("validation profiles %v loaded from %s", profiles.Names(), cfg.ValidationProfilesPath)
+	}
 
-	repo := repository.NewRepository(db)
-	svc := service.NewService(repo)
+	var repo *repository.Repository
+	if cfg.DbDriver == "sqlite" {
+		if cfg.PiiKeyringPath != "" {
+			logrus.Fatalf("PII_KEYRING_PATH is not supported with DB_DRIVER=sqlite")
+		}
+		sdb, err := sqlite.Open(ctx, cfg.SqlitePath)
+		if err != nil {
+			logrus.Fatalf("sqlite open: %s", err)
+		}
+		defer sdb.Close()
+		repo = repository.NewSqliteRepository(sdb)
+		logrus.Printf("orders are stored in sqlite at %s", cfg.SqlitePath)
+	} else {
+		var closeDB func()
+		repo, closeDB = openPostgres(ctx, cfg, unchecked)
+		defer closeDB()
+	}
+	ruleActions, err := service.ParseRuleActions(cfg.OrderRules)
+	if err != nil {
+		logrus.Fatalf("ORDER_RULES: %s", err)
+	}
+	versionSource, err := service.ParseVersionSource(cfg.OrderVersionSource)
+	if err != nil {
+		logrus.Fatalf("ORDER_VERSION_SOURCE: %s", err)
+	}
+	svcOpts := []service.Option{service.WithRuleActions(ruleActions), service.WithVersionSource(versionSource)}
+	if profiles != nil {
+		svcOpts = append(svcOpts, service.WithProfiles(profiles))
+	}
+	svc := service.NewService(repo, svcOpts...)
 
-	if err := svc.PutOrdersFromDbToCache(); err != nil {
+	if err := svc.PutOrdersFromDbToCache(ctx); err != nil {
 		logrus.Fatalf("warm cache: %s", err)
 	}
 	logrus.Print("cache warmed from db")
 
+	var wg sync.WaitGroup
+	if cfg.OrdersPartitioned || cfg.RetentionDays > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.RunRetention(ctx, service.RetentionConfig{
+				Interval:    time.Duration(cfg.RetentionIntervalMin) * time.Minute,
+				MaxAge:      time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+				MonthsAhead: cfg.PartitionMonthsAhead,
+				ArchiveDir:  cfg.RetentionArchiveDir,
+			})
+		}()
+		logrus.Print("retention job started")
+	}
+	if profiles != nil && cfg.ValidationProfilesReloadSec > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			profiles.Watch(ctx, time.Duration(cfg.ValidationProfilesReloadSec)*time.Second)
+		}()
+	}
+
 	consumer := kafka.NewConsumer(kafka.Config{
 		Brokers:     cfg.KafkaBrokersSlice(),
 		GroupID:     cfg.KafkaGroupID,
@@ -75,9 +120,9 @@ func main() {
 		DLQ:         cfg.KafkaDLQ,
 		MaxRetries:  cfg.KafkaMaxRetries,
 		BaseBackoff: time.Duration(cfg.KafkaBackoffMillis) * time.Millisecond,
+		OffsetsInDB: cfg.KafkaOffsetsInDB,
 	}, svc)
 
-	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -88,6 +133,28 @@ func main() {
 	}()
 	logrus.Print("kafka subscription started")
 
+	relay := kafka.NewRelay(kafka.RelayConfig{
+		Brokers:   cfg.KafkaBrokersSlice(),
+		Topic:     cfg.KafkaEventsTopic,
+		Interval:  time.Duration(cfg.OutboxRelayIntervalMillis) * time.Millisecond,
+		BatchSize: cfg.OutboxBatchSize,
+		Retention: time.Duration(cfg.OutboxRetentionHours) * time.Hour,
+	}, svc)
+	defer func() {
+		if err := relay.Close(); err != nil {
+			logrus.Errorf("relay close: %v", err)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := relay.Run(ctx); err != nil {
+			logrus.Errorf("outbox relay stopped: %v", err)
+		}
+	}()
+	logrus.Printf("outbox relay started, publishing to %s", cfg.KafkaEventsTopic)
+
 	h := httpdelivery.NewHandler(svc)
 	srv := new(httpdelivery.Server)
 
@@ -123,3 +190,83 @@ func main() {
 
 	logrus.Print("service stopped")
 }
+
+// openPostgres connects to the primary, and the replica if one is set, and
+// returns the repository on top of them with a func closing the connections.
+// The checks of the unchecked columns are left out of the schema.
+func openPostgres(ctx context.Context, cfg configs.Config, unchecked map[string][]string) (*repository.Repository, func()) {
+	pgCfg := postgres.Config{
+		URL:              cfg.PgDSN(),
+		MaxOpenConns:     cfg.PostgresMaxOpenConns,
+		MaxIdleConns:     cfg.PostgresMaxIdleConns,
+		ConnMaxLifetime:  time.Duration(cfg.PostgresConnLifetimeSec) * time.Second,
+		StatementTimeout: time.Duration(cfg.PostgresStmtTimeoutMillis) * time.Millisecond,
+		ConnectRetry:     time.Duration(cfg.PostgresConnectRetrySec) * time.Second,
+
+		PartitionOrders:      cfg.OrdersPartitioned,
+		PartitionMonthsAhead: cfg.PartitionMonthsAhead,
+		UncheckedColumns:     unchecked,
+	}
+	db, err := postgres.ConnectDB(ctx, pgCfg)
+	if err != nil {
+		logrus.Fatalf("postgres connect: %s", err)
+	}
+	closers := []func(){func() {
+		if derr := db.Close(); derr != nil {
+			logrus.Errorf("db close: %v", derr)
+		}
+	}}
+	logrus.Print("connected to postgres")
+
+	timeouts := postgres.Timeouts{
+		Read:  time.Duration(cfg.PostgresReadTimeoutMillis) * time.Millisecond,
+		Write: time.Duration(cfg.PostgresWriteTimeoutMillis) * time.Millisecond,
+	}
+	repoOpts := []postgres.Option{postgres.WithTimeouts(timeouts)}
+	var ring *pii.Keyring
+	if cfg.PiiKeyringPath != "" {
+		if ring, err = pii.LoadKeyring(cfg.PiiKeyringPath); err != nil {
+			logrus.Fatalf("pii keyring: %s", err)
+		}
+		repoOpts = append(repoOpts, postgres.WithKeyring(ring))
+		logrus.Printf("personal data is encrypted with key %s", ring.Primary())
+	}
+	if cfg.ReplicaDatabaseURL != "" {
+		replicaCfg := pgCfg
+		replicaCfg.URL = cfg.ReplicaDatabaseURL
+		replica, err := postgres.ConnectReplica(ctx, replicaCfg)
+		if err != nil {
+			logrus.Warnf("replica connect, reading from primary only: %s", err)
+		} else {
+			closers = append(closers, func() { replica.Close() })
+			repoOpts = append(repoOpts, postgres.WithReplica(replica, time.Duration(cfg.ReplicaMaxLagMillis)*time.Millisecond))
+			logrus.Print("connected to postgres replica")
+		}
+	}
+
+	repo := repository.NewRepository(db, repoOpts...)
+	switch cfg.DbDriver {
+	case "gorm":
+	case "pgx":
+		pool, err := postgres.ConnectPgx(ctx, pgCfg)
+		if err != nil {
+			logrus.Fatalf("pgx connect: %s", err)
+		}
+		closers = append(closers, pool.Close)
+		// Imports keep the batched writes of the gorm repository.
+		repo.OrderPostgres = postgres.NewOrderPgx(pool, timeouts, ring)
+		logrus.Print("orders are stored through pgx")
+		if cfg.ReplicaDatabaseURL != "" {
+			logrus.Warn("DB_DRIVER=pgx has no replica routing: orders are read from the primary, " +
+				"the replica serves search, statistics, revisions and messages only")
+		}
+	default:
+		logrus.Fatalf("unknown DB_DRIVER %q", cfg.DbDriver)
+	}
+
+	return repo, func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+}
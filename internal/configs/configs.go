@@ -13,8 +13,17 @@ type Config struct {
 	KafkaDLQ     string `env:"KAFKA_DLQ"     envDefault:"orders.dlq"`
 	KafkaGroupID string `env:"KAFKA_GROUP_ID" envDefault:"order-svc"`
 
-	KafkaMaxRetries    int `env:"KAFKA_MAX_RETRIES"    envDefault:"5"`
-	KafkaBackoffMillis int `env:"KAFKA_BACKOFF_MILLIS" envDefault:"200"`
+	KafkaEventsTopic string `env:"KAFKA_EVENTS_TOPIC" envDefault:"orders.events"`
+
+	OutboxRelayIntervalMillis int `env:"OUTBOX_RELAY_INTERVAL_MILLIS" envDefault:"500"`
+	OutboxBatchSize           int `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	OutboxRetentionHours      int `env:"OUTBOX_RETENTION_HOURS" envDefault:"24"`
+
+	KafkaMaxRetries    int  `env:"KAFKA_MAX_RETRIES"    envDefault:"5"`
+	KafkaBackoffMillis int  `env:"KAFKA_BACKOFF_MILLIS" envDefault:"200"`
+	KafkaOffsetsInDB   bool `env:"KAFKA_OFFSETS_IN_DB" envDefault:"false"`
+
+	OrderVersionSource string `env:"ORDER_VERSION_SOURCE" envDefault:"timestamp"`
 
 	HTTPAddr string `env:"HTTP_ADDR" envDefault:":8081"`
 
@@ -22,6 +31,8 @@ type Config struct {
 
 	JsonStaticModelPath string `env:"JSON_STATIC_MODEL_PATH" envDefault:"web/model.json"`
 
+	DbDriver        string `env:"DB_DRIVER" envDefault:"gorm"`
+	SqlitePath      string `env:"SQLITE_PATH" envDefault:"orders.db"`
 	DatabaseURL     string `env:"DATABASE_URL" envDefault:""`
 	PostgresHost    string `env:"POSTGRES_HOST" envDefault:"localhost"`
 	PostgresPort    string `env:"POSTGRES_PORT" envDefault:"5432"`
@@ -29,6 +40,30 @@ type Config struct {
 	PostgresPass    string `env:"POSTGRES_PASSWORD" envDefault:"postgres"`
 	PostgresDB      string `env:"POSTGRES_DB" envDefault:"orders"`
 	PostgresSSLMode string `env:"POSTGRES_SSLMODE" envDefault:"disable"`
+
+	PostgresMaxOpenConns       int `env:"POSTGRES_MAX_OPEN_CONNS" envDefault:"20"`
+	PostgresMaxIdleConns       int `env:"POSTGRES_MAX_IDLE_CONNS" envDefault:"5"`
+	PostgresConnLifetimeSec    int `env:"POSTGRES_CONN_MAX_LIFETIME_SEC" envDefault:"300"`
+	PostgresStmtTimeoutMillis  int `env:"POSTGRES_STATEMENT_TIMEOUT_MILLIS" envDefault:"5000"`
+	PostgresConnectRetrySec    int `env:"POSTGRES_CONNECT_RETRY_SEC" envDefault:"60"`
+	PostgresReadTimeoutMillis  int `env:"POSTGRES_READ_TIMEOUT_MILLIS" envDefault:"5000"`
+	PostgresWriteTimeoutMillis int `env:"POSTGRES_WRITE_TIMEOUT_MILLIS" envDefault:"10000"`
+
+	OrdersPartitioned    bool   `env:"ORDERS_PARTITIONED" envDefault:"false"`
+	PartitionMonthsAhead int    `env:"PARTITION_MONTHS_AHEAD" envDefault:"3"`
+	RetentionDays        int    `env:"RETENTION_DAYS" envDefault:"0"`
+	RetentionArchiveDir  string `env:"RETENTION_ARCHIVE_DIR" envDefault:""`
+	RetentionIntervalMin int    `env:"RETENTION_INTERVAL_MINUTES" envDefault:"60"`
+
+	ReplicaDatabaseURL  string `env:"REPLICA_DATABASE_URL" envDefault:""`
+	ReplicaMaxLagMillis int    `env:"REPLICA_MAX_LAG_MILLIS" envDefault:"5000"`
+
+	PiiKeyringPath string `env:"PII_KEYRING_PATH" envDefault:""`
+
+	OrderRules string `env:"ORDER_RULES" envDefault:""`
+
+	ValidationProfilesPath      string `env:"VALIDATION_PROFILES_PATH" envDefault:""`
+	ValidationProfilesReloadSec int    `env:"VALIDATION_PROFILES_RELOAD_SEC" envDefault:"10"`
 }
 
 func LoadConfig(_ string) (Config, error) {
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EraseCustomer
+// @Summary EraseCustomer
+// @Description Allows to erase the personal data (delivery name, phone, address and email) of every order of a customer in the postgres database, the revision history, the archived Kafka messages and the app's cache. Payments and items are kept. Repeating the request is safe and returns the last receipt when there is nothing left to erase
+// @ID erase-customer
+// @Accept json
+// @Produce json
+// @Param customer_id path string true "customer's id"
+// @Success 200 {object} models.ErasureReceipt
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/admin/customers/{customer_id}/erase [post]
+func (h *Handler) EraseCustomer(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("customer_id"))
+	if id == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing customer_id")
+		return
+	}
+
+	rec, err := h.svc.EraseCustomer(c.Request.Context(), id)
+	if err != nil {
+		newServiceErrorResponse(c, err, "customer not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, rec)
+}
+
+// GetErasureReceipts
+// @Summary GetErasureReceipts
+// @Description Allows to get the receipts of the erasures of a customer's personal data, oldest first
+// @ID get-erasure-receipts
+// @Accept json
+// @Produce json
+// @Param customer_id path string true "customer's id"
+// @Success 200 {object} getErasureReceiptsResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/admin/customers/{customer_id}/erasures [get]
+func (h *Handler) GetErasureReceipts(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("customer_id"))
+	if id == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing customer_id")
+		return
+	}
+
+	receipts, err := h.svc.GetErasureReceipts(c.Request.Context(), id)
+	if err != nil {
+		newServiceErrorResponse(c, err, "no erasures for customer")
+		return
+	}
+
+	c.JSON(http.StatusOK, getErasureReceiptsResponse{Data: receipts})
+}
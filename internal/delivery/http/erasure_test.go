@@ -0,0 +1,99 @@
+package http_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/service"
+)
+
+func Test_EraseCustomer_OK(t *testing.T) {
+	var got string
+	r := newRouter(&svcStub{
+		erase: func(customerID string) (models.ErasureReceipt, error) {
+			got = customerID
+			return models.ErasureReceipt{
+				ID: 7, CustomerId: customerID, OrderUids: []string{"b563feb7b2b84b6test"},
+				Deliveries: 1, Revisions: 2, Messages: 1, ErasedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/admin/customers/test/erase", nil))
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, "test", got)
+	require.JSONEq(t, `{"id":7,"customer_id":"test","order_uids":["b563feb7b2b84b6test"],
+		"deliveries":1,"revisions":2,"messages":1,"erased_at":"2026-01-02T03:04:05Z"}`, w.Body.String())
+}
+
+func Test_EraseCustomer_Errors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: customer_id is required", service.ErrValidation), http.StatusBadRequest},
+		{&service.ValidationError{}, http.StatusUnprocessableEntity},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			erase: func(string) (models.ErasureReceipt, error) { return models.ErasureReceipt{}, tc.err },
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/admin/customers/%20/erase", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, "blank id is rejected before the service")
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/admin/customers/test/erase", nil))
+		require.Equal(t, tc.code, w.Code, "body=%s", w.Body.String())
+	}
+}
+
+func Test_EraseCustomer_ValidationError(t *testing.T) {
+	r := newRouter(&svcStub{
+		erase: func(string) (models.ErasureReceipt, error) {
+			return models.ErasureReceipt{}, fmt.Errorf("erase: %w", &service.ValidationError{Errors: []service.FieldError{
+				{Field: "customer_id", Rule: "len", Param: "4", Message: "must be 4 characters long"},
+			}})
+		},
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/admin/customers/toolong/erase", nil))
+
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.JSONEq(t, `{"message":"validation failed","errors":[
+		{"field":"customer_id","rule":"len","param":"4","message":"must be 4 characters long"}]}`, w.Body.String())
+}
+
+func Test_GetErasureReceipts(t *testing.T) {
+	r := newRouter(&svcStub{
+		getErasures: func(customerID string) ([]models.ErasureReceipt, error) {
+			if customerID != "test" {
+				return nil, service.ErrNotFound
+			}
+			return []models.ErasureReceipt{{ID: 1, CustomerId: customerID, OrderUids: []string{}}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/customers/test/erasures", nil))
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Contains(t, w.Body.String(), `"data":[{"id":1,"customer_id":"test","order_uids":[]`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/customers/none/erasures", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	newRouter(&svcStub{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/customers/test/erasures", nil))
+	require.Equal(t, http.StatusNotImplemented, w.Code)
+}
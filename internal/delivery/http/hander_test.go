@@ -14,6 +14,7 @@ import (
 	httpdelivery "l0-demo/internal/delivery/http"
 	"l0-demo/internal/models"
 	"l0-demo/internal/repository/cache"
+	"l0-demo/internal/repository/storage"
 	"l0-demo/internal/service"
 )
 
@@ -26,46 +27,61 @@ type svcStub struct {
 	putCached        func(order models.Order)
 	putDb            func(order models.Order) error
 	handle           func(ctx context.Context, payload []byte) error
+	getRevisions     func(uid string) ([]models.OrderRevision, error)
+	getAsOf          func(uid string, at time.Time) (models.Order, error)
+	deleteOrder      func(uid string, hard bool) error
+	search           func(q string, limit, offset int) (models.SearchResult, error)
+	lookup           func(email, phone string, limit int) ([]models.Order, error)
+	revenueStats     func(f models.StatsFilter) ([]models.RevenueRow, error)
+	brandStats       func(f models.StatsFilter) ([]models.BrandRow, error)
+	basketStats      func(f models.StatsFilter) ([]models.BasketRow, error)
+	getMessages      func(uid string) ([]models.RawMessage, error)
+	recordMessage    func(msg models.RawMessage) error
+	erase            func(customerID string) (models.ErasureReceipt, error)
+	getErasures      func(customerID string) ([]models.ErasureReceipt, error)
+
+	lastReadOpts storage.ReadOptions
 }
 
 var _ service.Order = (*svcStub)(nil)
 
-func (s *svcStub) GetCachedOrder(uid string) (models.Order, error) {
+func (s *svcStub) GetCachedOrder(_ context.Context, uid string) (models.Order, error) {
 	if s.getCached != nil {
 		return s.getCached(uid)
 	}
 	return models.Order{}, fmt.Errorf("not implemented")
 }
-func (s *svcStub) GetAllCachedOrders() ([]models.Order, error) {
+func (s *svcStub) GetAllCachedOrders(_ context.Context) ([]models.Order, error) {
 	if s.getAllCached != nil {
 		return s.getAllCached()
 	}
 	return nil, fmt.Errorf("not implemented")
 }
-func (s *svcStub) GetAllDbOrders() ([]models.Order, error) {
+func (s *svcStub) GetAllDbOrders(_ context.Context) ([]models.Order, error) {
 	if s.getAllDb != nil {
 		return s.getAllDb()
 	}
 	return nil, fmt.Errorf("not implemented")
 }
-func (s *svcStub) GetDbOrder(uid string) (models.Order, error) {
+func (s *svcStub) GetDbOrder(_ context.Context, uid string, opts ...storage.ReadOption) (models.Order, error) {
+	s.lastReadOpts = storage.NewReadOptions(opts...)
 	if s.getDb != nil {
 		return s.getDb(uid)
 	}
 	return models.Order{}, service.ErrNotFound
 }
-func (s *svcStub) PutOrdersFromDbToCache() error {
+func (s *svcStub) PutOrdersFromDbToCache(_ context.Context) error {
 	if s.putFromDbToCache != nil {
 		return s.putFromDbToCache()
 	}
 	return fmt.Errorf("not implemented")
 }
-func (s *svcStub) PutCachedOrder(order models.Order) {
+func (s *svcStub) PutCachedOrder(_ context.Context, order models.Order) {
 	if s.putCached != nil {
 		s.putCached(order)
 	}
 }
-func (s *svcStub) PutDbOrder(order models.Order) error {
+func (s *svcStub) PutDbOrder(_ context.Context, order models.Order) error {
 	if s.putDb != nil {
 		return s.putDb(order)
 	}
@@ -78,6 +94,86 @@ func (s *svcStub) HandleMessage(ctx context.Context, payload []byte) error {
 	return nil
 }
 
+func (s *svcStub) DeleteOrder(_ context.Context, uid string, hard bool) error {
+	if s.deleteOrder != nil {
+		return s.deleteOrder(uid, hard)
+	}
+	return fmt.Errorf("not implemented")
+}
+func (s *svcStub) GetOrderRevisions(_ context.Context, uid string) ([]models.OrderRevision, error) {
+	if s.getRevisions != nil {
+		return s.getRevisions(uid)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) GetOrderAsOf(_ context.Context, uid string, at time.Time) (models.Order, error) {
+	if s.getAsOf != nil {
+		return s.getAsOf(uid, at)
+	}
+	return models.Order{}, service.ErrUnsupported
+}
+
+func (s *svcStub) SearchOrders(_ context.Context, q string, limit, offset int) (models.SearchResult, error) {
+	if s.search != nil {
+		return s.search(q, limit, offset)
+	}
+	return models.SearchResult{}, service.ErrUnsupported
+}
+func (s *svcStub) LookupOrders(_ context.Context, email, phone string, limit int) ([]models.Order, error) {
+	if s.lookup != nil {
+		return s.lookup(email, phone, limit)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) RevenueStats(_ context.Context, f models.StatsFilter) ([]models.RevenueRow, error) {
+	if s.revenueStats != nil {
+		return s.revenueStats(f)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) TopBrandStats(_ context.Context, f models.StatsFilter) ([]models.BrandRow, error) {
+	if s.brandStats != nil {
+		return s.brandStats(f)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) BasketStats(_ context.Context, f models.StatsFilter) ([]models.BasketRow, error) {
+	if s.basketStats != nil {
+		return s.basketStats(f)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) GetOrderMessages(_ context.Context, uid string) ([]models.RawMessage, error) {
+	if s.getMessages != nil {
+		return s.getMessages(uid)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) RecordMessage(_ context.Context, msg models.RawMessage) error {
+	if s.recordMessage != nil {
+		return s.recordMessage(msg)
+	}
+	return nil
+}
+func (s *svcStub) EraseCustomer(_ context.Context, customerID string) (models.ErasureReceipt, error) {
+	if s.erase != nil {
+		return s.erase(customerID)
+	}
+	return models.ErasureReceipt{}, service.ErrUnsupported
+}
+func (s *svcStub) GetErasureReceipts(_ context.Context, customerID string) ([]models.ErasureReceipt, error) {
+	if s.getErasures != nil {
+		return s.getErasures(customerID)
+	}
+	return nil, service.ErrUnsupported
+}
+func (s *svcStub) CommitOffset(_ context.Context, off storage.Offset) error {
+	return service.ErrUnsupported
+}
+func (s *svcStub) StoredOffsets(_ context.Context, group, topic string) (map[int]int64, error) {
+	return nil, service.ErrUnsupported
+}
+
 func newRouter(s *svcStub) http.Handler {
 	h := httpdelivery.NewHandler(s)
 	return h.InitRoutes()
@@ -289,3 +385,233 @@ func Test_GetDbOrderById_MissingUID_400(t *testing.T) {
 	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
 	require.Contains(t, w.Body.String(), "missing uid")
 }
+
+func Test_GetOrderRevisions_OK(t *testing.T) {
+	o := mustOrder(t)
+	r := newRouter(&svcStub{
+		getRevisions: func(uid string) ([]models.OrderRevision, error) {
+			require.Equal(t, o.OrderUid, uid)
+			return []models.OrderRevision{{
+				ID:       1,
+				OrderUid: uid,
+				Version:  3,
+				Diff:     json.RawMessage(`{"delivery.city":{"old":"Moscow","new":"Amsterdam"}}`),
+			}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/"+o.OrderUid+"/revisions", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Contains(t, w.Body.String(), `"diff":{"delivery.city":{"old":"Moscow","new":"Amsterdam"}}`)
+}
+
+func Test_GetOrderRevisions_Errors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{service.ErrNotFound, http.StatusNotFound},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			getRevisions: func(string) ([]models.OrderRevision, error) { return nil, tc.err },
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/order/any/revisions", nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, tc.code, w.Code, "body=%s", w.Body.String())
+	}
+}
+
+func Test_GetOrderMessages_OK(t *testing.T) {
+	o := mustOrder(t)
+	r := newRouter(&svcStub{
+		getMessages: func(uid string) ([]models.RawMessage, error) {
+			require.Equal(t, o.OrderUid, uid)
+			return []models.RawMessage{{
+				ID:        7,
+				OrderUid:  uid,
+				Topic:     "orders",
+				Partition: 2,
+				Offset:    41,
+				Headers:   map[string]string{"x-order-version": "3"},
+				Payload:   `{"order_uid":"` + uid + `"}`,
+				Outcome:   models.MessageInvalid,
+				Error:     "validation: bad rid",
+			}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/"+o.OrderUid+"/messages", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	body := w.Body.String()
+	require.Contains(t, body, `"topic":"orders","partition":2,"offset":41`)
+	require.Contains(t, body, `"headers":{"x-order-version":"3"}`)
+	require.Contains(t, body, `"outcome":"validation_error"`)
+}
+
+func Test_GetOrderMessages_Errors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{service.ErrNotFound, http.StatusNotFound},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			getMessages: func(string) ([]models.RawMessage, error) { return nil, tc.err },
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/order/any/messages", nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, tc.code, w.Code, "body=%s", w.Body.String())
+	}
+}
+
+func Test_GetOrderAsOf_OK(t *testing.T) {
+	o := mustOrder(t)
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := newRouter(&svcStub{
+		getAsOf: func(uid string, at time.Time) (models.Order, error) {
+			require.Equal(t, o.OrderUid, uid)
+			require.True(t, want.Equal(at))
+			return o, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/"+o.OrderUid+"/as-of?at=2024-01-02T03:04:05Z", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Contains(t, w.Body.String(), `"order_uid":"`+o.OrderUid+`"`)
+}
+
+func Test_GetOrderAsOf_BadTimestamp_400(t *testing.T) {
+	r := newRouter(&svcStub{})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/any/as-of?at=yesterday", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+	require.Contains(t, w.Body.String(), "invalid at")
+}
+
+func Test_GetOrderAsOf_NotFound_404(t *testing.T) {
+	r := newRouter(&svcStub{
+		getAsOf: func(string, time.Time) (models.Order, error) { return models.Order{}, service.ErrNotFound },
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/any/as-of?at=2024-01-02T03:04:05Z", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNotFound, w.Code, "body=%s", w.Body.String())
+}
+
+func Test_GetDbOrderById_IncludeDeleted(t *testing.T) {
+	o := mustOrder(t)
+	stub := &svcStub{
+		getDb: func(uid string) (models.Order, error) { return o, nil },
+	}
+	r := newRouter(stub)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid, nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.False(t, stub.lastReadOpts.IncludeDeleted)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid+"?include_deleted=true", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.True(t, stub.lastReadOpts.IncludeDeleted)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid+"?include_deleted=maybe", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+}
+
+func Test_GetDbOrderById_Primary(t *testing.T) {
+	o := mustOrder(t)
+	stub := &svcStub{
+		getDb: func(uid string) (models.Order, error) { return o, nil },
+	}
+	r := newRouter(stub)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid, nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.False(t, stub.lastReadOpts.Primary)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid+"?primary=true&include_deleted=true", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.True(t, stub.lastReadOpts.Primary)
+	require.True(t, stub.lastReadOpts.IncludeDeleted)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/order/db/"+o.OrderUid+"?primary=yes-please", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+}
+
+func Test_DeleteOrder_SoftAndHard(t *testing.T) {
+	var gotUID string
+	var gotHard bool
+	r := newRouter(&svcStub{
+		deleteOrder: func(uid string, hard bool) error {
+			gotUID, gotHard = uid, hard
+			return nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/order/b563feb7b2b84b6test", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, "b563feb7b2b84b6test", gotUID)
+	require.False(t, gotHard)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/order/b563feb7b2b84b6test?hard=true", nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code, "body=%s", w.Body.String())
+	require.True(t, gotHard)
+}
+
+func Test_DeleteOrder_Errors(t *testing.T) {
+	cases := []struct {
+		path string
+		err  error
+		code int
+	}{
+		{"/api/order/any?hard=nope", nil, http.StatusBadRequest},
+		{"/api/order/%20%20", nil, http.StatusBadRequest},
+		{"/api/order/any", service.ErrNotFound, http.StatusNotFound},
+		{"/api/order/any", &service.ValidationError{Errors: []service.FieldError{{Field: "order_uid", Rule: "required", Message: "is required"}}}, http.StatusUnprocessableEntity},
+		{"/api/order/any", fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			deleteOrder: func(string, bool) error { return tc.err },
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, tc.path, nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, tc.code, w.Code, "path=%s body=%s", tc.path, w.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"expvar"
 	"net/http"
 	"strings"
 
@@ -27,14 +28,45 @@ type getAllOrdersResponse struct {
 	Data []models.Order `json:"data"`
 }
 
+type getOrderRevisionsResponse struct {
+	Data []models.OrderRevision `json:"data"`
+}
+
+type getOrderMessagesResponse struct {
+	Data []models.RawMessage `json:"data"`
+}
+
+type getErasureReceiptsResponse struct {
+	Data []models.ErasureReceipt `json:"data"`
+}
+
 func (h *Handler) InitRoutes() *gin.Engine {
 	router := gin.Default()
 
 	api := router.Group("/api")
 	{
 		api.GET("/order/:uid", h.GetOrderById)
+		api.DELETE("/order/:uid", h.DeleteOrder)
 		api.GET("/order/db/:uid", h.GetDbOrderById)
+		api.GET("/order/:uid/revisions", h.GetOrderRevisions)
+		api.GET("/order/:uid/as-of", h.GetOrderAsOf)
+		api.GET("/order/:uid/messages", h.GetOrderMessages)
 		api.GET("/orders", h.GetAllOrders)
+		api.GET("/orders/lookup", h.LookupOrders)
+		api.GET("/search", h.SearchOrders)
+
+		stats := api.Group("/stats")
+		{
+			stats.GET("/revenue", h.RevenueStats)
+			stats.GET("/brands", h.TopBrandStats)
+			stats.GET("/basket", h.BasketStats)
+		}
+
+		admin := api.Group("/admin")
+		{
+			admin.POST("/customers/:customer_id/erase", h.EraseCustomer)
+			admin.GET("/customers/:customer_id/erasures", h.GetErasureReceipts)
+		}
 	}
 
 	router.GET("/", func(c *gin.Context) {
@@ -52,6 +84,7 @@ func (h *Handler) InitRoutes() *gin.Engine {
 	})
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
 	return router
 }
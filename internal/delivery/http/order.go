@@ -1,12 +1,12 @@
 package http
 
 import (
-	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
-	"l0-demo/internal/repository/cache"
-	"l0-demo/internal/service"
+	"l0-demo/internal/repository/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -31,17 +31,9 @@ func (h *Handler) GetOrderById(c *gin.Context) {
 		return
 	}
 
-	order, err := h.svc.GetCachedOrder(uid)
+	order, err := h.svc.GetCachedOrder(c.Request.Context(), uid)
 	if err != nil {
-		if errors.Is(err, service.ErrNotFound) {
-			newErrorResponse(c, http.StatusNotFound, "not found")
-			return
-		}
-		if val, ok := err.(cache.ErrorHandler); ok {
-			newErrorResponse(c, val.StatusCode, err.Error())
-			return
-		}
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		newServiceErrorResponse(c, err, "not found")
 		return
 	}
 
@@ -55,6 +47,8 @@ func (h *Handler) GetOrderById(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param uid path string true "order's uid" minlength(19)  maxlength(19)
+// @Param include_deleted query bool false "return the order even if it was soft-deleted"
+// @Param primary query bool false "read from the primary database instead of the replica"
 // @Success 200 {object} models.Order
 // @Failure 400,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
@@ -66,20 +60,68 @@ func (h *Handler) GetDbOrderById(c *gin.Context) {
 		newErrorResponse(c, http.StatusBadRequest, "missing uid")
 		return
 	}
+	includeDeleted, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid include_deleted")
+		return
+	}
+	primary, err := strconv.ParseBool(c.DefaultQuery("primary", "false"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid primary")
+		return
+	}
 
-	order, err := h.svc.GetDbOrder(uid)
+	var opts []storage.ReadOption
+	if includeDeleted {
+		opts = append(opts, storage.IncludeDeleted())
+	}
+	if primary {
+		opts = append(opts, storage.Primary())
+	}
+
+	order, err := h.svc.GetDbOrder(c.Request.Context(), uid, opts...)
 	if err != nil {
-		if errors.Is(err, service.ErrNotFound) {
-			newErrorResponse(c, http.StatusNotFound, "order not found")
-			return
-		}
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		newServiceErrorResponse(c, err, "order not found")
 		return
 	}
 
 	c.JSON(http.StatusOK, order)
 }
 
+// DeleteOrder
+// @Summary DeleteOrder
+// @Description Allows to delete an order from the postgres database and the app's cache. By default the order is soft-deleted and can still be read with include_deleted
+// @ID delete-order
+// @Accept json
+// @Produce json
+// @Param uid path string true "order's uid" minlength(19)  maxlength(19)
+// @Param hard query bool false "remove the order with its children and revisions permanently"
+// @Success 204
+// @Failure 400,404 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/order/{uid} [delete]
+func (h *Handler) DeleteOrder(c *gin.Context) {
+	uid := strings.TrimSpace(c.Param("uid"))
+	if uid == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing uid")
+		return
+	}
+	hard, err := strconv.ParseBool(c.DefaultQuery("hard", "false"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid hard")
+		return
+	}
+
+	if err := h.svc.DeleteOrder(c.Request.Context(), uid, hard); err != nil {
+		newServiceErrorResponse(c, err, "order not found")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // GetAllOrders
 // @Summary GetAllOrders
 // @Description Allows to get all orders from the app's cache
@@ -92,17 +134,102 @@ func (h *Handler) GetDbOrderById(c *gin.Context) {
 // @Failure default {object} errorResponse
 // @Router /api/orders [get]
 func (h *Handler) GetAllOrders(c *gin.Context) {
-	orders, err := h.svc.GetAllCachedOrders()
+	orders, err := h.svc.GetAllCachedOrders(c.Request.Context())
 	if err != nil {
-		if val, ok := err.(cache.ErrorHandler); ok {
-			newErrorResponse(c, val.StatusCode, err.Error())
-			return
-		} else {
-			newErrorResponse(c, http.StatusInternalServerError, err.Error())
-			return
-		}
+		newServiceErrorResponse(c, err, "not found")
+		return
 	}
 	c.JSON(http.StatusOK, getAllOrdersResponse{
 		Data: orders,
 	})
 }
+
+// GetOrderRevisions
+// @Summary GetOrderRevisions
+// @Description Allows to get the list of stored revisions of an order with a diff against the previous one
+// @ID get-order-revisions
+// @Accept json
+// @Produce json
+// @Param uid path string true "order's uid" minlength(19)  maxlength(19)
+// @Success 200 {object} getOrderRevisionsResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/order/{uid}/revisions [get]
+func (h *Handler) GetOrderRevisions(c *gin.Context) {
+	uid := strings.TrimSpace(c.Param("uid"))
+	if uid == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing uid")
+		return
+	}
+
+	revs, err := h.svc.GetOrderRevisions(c.Request.Context(), uid)
+	if err != nil {
+		newServiceErrorResponse(c, err, "order not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getOrderRevisionsResponse{Data: revs})
+}
+
+// GetOrderMessages
+// @Summary GetOrderMessages
+// @Description Allows to get the Kafka messages consumed for an order with their topic, partition, offset, headers, payload and processing outcome
+// @ID get-order-messages
+// @Accept json
+// @Produce json
+// @Param uid path string true "order's uid" minlength(19)  maxlength(19)
+// @Success 200 {object} getOrderMessagesResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/order/{uid}/messages [get]
+func (h *Handler) GetOrderMessages(c *gin.Context) {
+	uid := strings.TrimSpace(c.Param("uid"))
+	if uid == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing uid")
+		return
+	}
+
+	msgs, err := h.svc.GetOrderMessages(c.Request.Context(), uid)
+	if err != nil {
+		newServiceErrorResponse(c, err, "no messages for order")
+		return
+	}
+
+	c.JSON(http.StatusOK, getOrderMessagesResponse{Data: msgs})
+}
+
+// GetOrderAsOf
+// @Summary GetOrderAsOf
+// @Description Allows to get an order as it was stored at the given moment
+// @ID get-order-as-of
+// @Accept json
+// @Produce json
+// @Param uid path string true "order's uid" minlength(19)  maxlength(19)
+// @Param at query string true "moment in RFC3339 format" example(2021-11-26T06:22:19Z)
+// @Success 200 {object} models.Order
+// @Failure 400,404 {object} errorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/order/{uid}/as-of [get]
+func (h *Handler) GetOrderAsOf(c *gin.Context) {
+	uid := strings.TrimSpace(c.Param("uid"))
+	if uid == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing uid")
+		return
+	}
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid at: expected RFC3339 timestamp")
+		return
+	}
+
+	order, err := h.svc.GetOrderAsOf(c.Request.Context(), uid, at)
+	if err != nil {
+		newServiceErrorResponse(c, err, "order not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
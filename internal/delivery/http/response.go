@@ -1,6 +1,12 @@
 package http
 
 import (
+	"errors"
+	"net/http"
+
+	"l0-demo/internal/repository/cache"
+	"l0-demo/internal/service"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -13,3 +19,39 @@ func newErrorResponse(c *gin.Context, statusCode int, message string) {
 	logrus.Error(message)
 	c.AbortWithStatusJSON(statusCode, errorResponse{message})
 }
+
+type validationErrorResponse struct {
+	Message string               `json:"message"`
+	Errors  []service.FieldError `json:"errors"`
+}
+
+// newValidationErrorResponse answers 422 with every check the input failed.
+func newValidationErrorResponse(c *gin.Context, err *service.ValidationError) {
+	logrus.Warn(err.Error())
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, validationErrorResponse{
+		Message: "validation failed",
+		Errors:  err.Errors,
+	})
+}
+
+// newServiceErrorResponse answers with the status matching an error of the
+// service: 422 with the failed checks for a *ValidationError, 400 for other
+// invalid input, 404 with notFound, 501 when the storage lacks the feature.
+func newServiceErrorResponse(c *gin.Context, err error, notFound string) {
+	var ve *service.ValidationError
+	var ce cache.ErrorHandler
+	switch {
+	case errors.As(err, &ve):
+		newValidationErrorResponse(c, ve)
+	case errors.Is(err, service.ErrValidation):
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrNotFound):
+		newErrorResponse(c, http.StatusNotFound, notFound)
+	case errors.Is(err, service.ErrUnsupported):
+		newErrorResponse(c, http.StatusNotImplemented, err.Error())
+	case errors.As(err, &ce):
+		newErrorResponse(c, ce.StatusCode, err.Error())
+	default:
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
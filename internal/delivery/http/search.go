@@ -0,0 +1,86 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchOrders
+// @Summary SearchOrders
+// @Description Allows to find orders in the postgres database by item name or brand, by delivery name, city or address, or by a part of the track number. Results are ranked by relevance
+// @ID search-orders
+// @Accept json
+// @Produce json
+// @Param q query string true "search text"
+// @Param limit query int false "page size, 20 by default, at most 100"
+// @Param offset query int false "number of results to skip"
+// @Success 200 {object} models.SearchResult
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/search [get]
+func (h *Handler) SearchOrders(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing q")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		newErrorResponse(c, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		newErrorResponse(c, http.StatusBadRequest, "invalid offset")
+		return
+	}
+
+	res, err := h.svc.SearchOrders(c.Request.Context(), q, limit, offset)
+	if err != nil {
+		newServiceErrorResponse(c, err, "not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// LookupOrders
+// @Summary LookupOrders
+// @Description Allows to find the orders delivered to an email and/or a phone number. The email is compared case-insensitively and the phone by its digits only. Works on encrypted deliveries through their blind indexes
+// @ID lookup-orders
+// @Accept json
+// @Produce json
+// @Param email query string false "delivery email"
+// @Param phone query string false "delivery phone"
+// @Param limit query int false "page size, 20 by default, at most 100"
+// @Success 200 {object} getAllOrdersResponse
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/orders/lookup [get]
+func (h *Handler) LookupOrders(c *gin.Context) {
+	email, phone := strings.TrimSpace(c.Query("email")), strings.TrimSpace(c.Query("phone"))
+	if email == "" && phone == "" {
+		newErrorResponse(c, http.StatusBadRequest, "missing email or phone")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		newErrorResponse(c, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	orders, err := h.svc.LookupOrders(c.Request.Context(), email, phone, limit)
+	if err != nil {
+		newServiceErrorResponse(c, err, "not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getAllOrdersResponse{Data: orders})
+}
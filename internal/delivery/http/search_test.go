@@ -0,0 +1,130 @@
+package http_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/service"
+)
+
+func Test_SearchOrders_OK(t *testing.T) {
+	o := mustOrder(t)
+	var gotQ string
+	var gotLimit, gotOffset int
+	r := newRouter(&svcStub{
+		search: func(q string, limit, offset int) (models.SearchResult, error) {
+			gotQ, gotLimit, gotOffset = q, limit, offset
+			return models.SearchResult{
+				Total:  1,
+				Limit:  limit,
+				Offset: offset,
+				Hits:   []models.SearchHit{{Rank: 0.5, Order: o}},
+			}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/search?q=Vivienne+Sabo&limit=5&offset=10", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, "Vivienne Sabo", gotQ)
+	require.Equal(t, 5, gotLimit)
+	require.Equal(t, 10, gotOffset)
+	require.Contains(t, w.Body.String(), `"total":1`)
+	require.Contains(t, w.Body.String(), `"order_uid":"`+o.OrderUid+`"`)
+}
+
+func Test_SearchOrders_BadRequest(t *testing.T) {
+	for _, path := range []string{
+		"/api/search",
+		"/api/search?q=%20",
+		"/api/search?q=x&limit=abc",
+		"/api/search?q=x&limit=-1",
+		"/api/search?q=x&offset=-5",
+	} {
+		r := newRouter(&svcStub{})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, "path=%s body=%s", path, w.Body.String())
+	}
+}
+
+func Test_SearchOrders_ServiceErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: empty search query", service.ErrValidation), http.StatusBadRequest},
+		{&service.ValidationError{Errors: []service.FieldError{{Field: "q", Rule: "required", Message: "is required"}}}, http.StatusUnprocessableEntity},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			search: func(string, int, int) (models.SearchResult, error) { return models.SearchResult{}, tc.err },
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/search?q=x", nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, tc.code, w.Code, "body=%s", w.Body.String())
+	}
+}
+
+func Test_LookupOrders_OK(t *testing.T) {
+	o := mustOrder(t)
+	var gotEmail, gotPhone string
+	var gotLimit int
+	r := newRouter(&svcStub{
+		lookup: func(email, phone string, limit int) ([]models.Order, error) {
+			gotEmail, gotPhone, gotLimit = email, phone, limit
+			return []models.Order{o}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/lookup?email=Test%40gmail.com&phone=%2B9720000000&limit=3", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, "Test@gmail.com", gotEmail)
+	require.Equal(t, "+9720000000", gotPhone)
+	require.Equal(t, 3, gotLimit)
+	require.Contains(t, w.Body.String(), `"order_uid":"`+o.OrderUid+`"`)
+}
+
+func Test_LookupOrders_Errors(t *testing.T) {
+	for _, path := range []string{
+		"/api/orders/lookup",
+		"/api/orders/lookup?email=%20",
+		"/api/orders/lookup?email=a%40b.c&limit=-1",
+	} {
+		r := newRouter(&svcStub{})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, "path=%s body=%s", path, w.Body.String())
+	}
+
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: phone has no digits", service.ErrValidation), http.StatusBadRequest},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("db down"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			lookup: func(string, string, int) ([]models.Order, error) { return nil, tc.err },
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/lookup?phone=abc", nil))
+		require.Equal(t, tc.code, w.Code, "body=%s", w.Body.String())
+	}
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"l0-demo/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type getRevenueStatsResponse struct {
+	Data []models.RevenueRow `json:"data"`
+}
+
+type getBrandStatsResponse struct {
+	Data []models.BrandRow `json:"data"`
+}
+
+type getBasketStatsResponse struct {
+	Data []models.BasketRow `json:"data"`
+}
+
+// RevenueStats
+// @Summary RevenueStats
+// @Description Returns order count and revenue (payment amount, goods total, delivery cost) grouped by the requested dimension. Amounts are reported per currency and never summed across currencies
+// @ID revenue-stats
+// @Accept json
+// @Produce json
+// @Param from query string false "start of the range, RFC3339 or YYYY-MM-DD, 30 days before to by default"
+// @Param to query string false "end of the range (exclusive), RFC3339 or YYYY-MM-DD (inclusive day), now by default"
+// @Param currency query string false "only orders paid in this currency"
+// @Param group_by query string false "day (default), week, month, delivery_service, provider, bank or none"
+// @Success 200 {object} getRevenueStatsResponse
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/stats/revenue [get]
+func (h *Handler) RevenueStats(c *gin.Context) {
+	f, err := parseStatsFilter(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := h.svc.RevenueStats(c.Request.Context(), f)
+	if err != nil {
+		newServiceErrorResponse(c, err, "not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getRevenueStatsResponse{Data: rows})
+}
+
+// TopBrandStats
+// @Summary TopBrandStats
+// @Description Returns item brands ordered by revenue, per currency
+// @ID top-brand-stats
+// @Accept json
+// @Produce json
+// @Param from query string false "start of the range, RFC3339 or YYYY-MM-DD, 30 days before to by default"
+// @Param to query string false "end of the range (exclusive), RFC3339 or YYYY-MM-DD (inclusive day), now by default"
+// @Param currency query string false "only orders paid in this currency"
+// @Param limit query int false "number of brands, 10 by default, at most 100"
+// @Success 200 {object} getBrandStatsResponse
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/stats/brands [get]
+func (h *Handler) TopBrandStats(c *gin.Context) {
+	f, err := parseStatsFilter(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || f.Limit < 0 {
+		newErrorResponse(c, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	rows, err := h.svc.TopBrandStats(c.Request.Context(), f)
+	if err != nil {
+		newServiceErrorResponse(c, err, "not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getBrandStatsResponse{Data: rows})
+}
+
+// BasketStats
+// @Summary BasketStats
+// @Description Returns the average number of items and the average payment amount per order, per currency
+// @ID basket-stats
+// @Accept json
+// @Produce json
+// @Param from query string false "start of the range, RFC3339 or YYYY-MM-DD, 30 days before to by default"
+// @Param to query string false "end of the range (exclusive), RFC3339 or YYYY-MM-DD (inclusive day), now by default"
+// @Param currency query string false "only orders paid in this currency"
+// @Param group_by query string false "none (default), day, week, month, delivery_service, provider or bank"
+// @Success 200 {object} getBasketStatsResponse
+// @Failure 400 {object} errorResponse
+// @Failure 422 {object} validationErrorResponse
+// @Failure 500,501 {object} errorResponse
+// @Failure default {object} errorResponse
+// @Router /api/stats/basket [get]
+func (h *Handler) BasketStats(c *gin.Context) {
+	f, err := parseStatsFilter(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := h.svc.BasketStats(c.Request.Context(), f)
+	if err != nil {
+		newServiceErrorResponse(c, err, "not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getBasketStatsResponse{Data: rows})
+}
+
+func parseStatsFilter(c *gin.Context) (models.StatsFilter, error) {
+	var f models.StatsFilter
+	var err error
+	if v := c.Query("from"); v != "" {
+		if f.From, _, err = parseStatsTime(v); err != nil {
+			return f, fmt.Errorf("invalid from")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		var dateOnly bool
+		if f.To, dateOnly, err = parseStatsTime(v); err != nil {
+			return f, fmt.Errorf("invalid to")
+		}
+		if dateOnly {
+			f.To = f.To.AddDate(0, 0, 1)
+		}
+	}
+	f.Currency = c.Query("currency")
+	f.GroupBy = c.Query("group_by")
+	return f, nil
+}
+
+func parseStatsTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
@@ -0,0 +1,92 @@
+package http_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/service"
+)
+
+func Test_RevenueStats_ParsesFilter(t *testing.T) {
+	var got models.StatsFilter
+	r := newRouter(&svcStub{
+		revenueStats: func(f models.StatsFilter) ([]models.RevenueRow, error) {
+			got = f
+			return []models.RevenueRow{{Group: "wbil", Currency: "USD", Orders: 2, Revenue: 3634}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/revenue?from=2021-11-01&to=2021-11-30&currency=USD&group_by=delivery_service", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC), got.From)
+	require.Equal(t, time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), got.To)
+	require.Equal(t, "USD", got.Currency)
+	require.Equal(t, "delivery_service", got.GroupBy)
+	require.Contains(t, w.Body.String(), `"group":"wbil"`)
+	require.Contains(t, w.Body.String(), `"revenue":3634`)
+}
+
+func Test_TopBrandStats_Limit(t *testing.T) {
+	var got models.StatsFilter
+	r := newRouter(&svcStub{
+		brandStats: func(f models.StatsFilter) ([]models.BrandRow, error) {
+			got = f
+			return []models.BrandRow{{Brand: "Vivienne Sabo", Currency: "USD", Items: 1, Orders: 1, Revenue: 317}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/brands?from=2021-11-26T00:00:00Z&limit=5", nil)
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Equal(t, 5, got.Limit)
+	require.Equal(t, time.Date(2021, 11, 26, 0, 0, 0, 0, time.UTC), got.From)
+	require.True(t, got.To.IsZero())
+	require.Contains(t, w.Body.String(), `"brand":"Vivienne Sabo"`)
+}
+
+func Test_Stats_BadRequest(t *testing.T) {
+	for _, path := range []string{
+		"/api/stats/revenue?from=yesterday",
+		"/api/stats/basket?to=2021-13-01",
+		"/api/stats/brands?limit=abc",
+		"/api/stats/brands?limit=-1",
+	} {
+		r := newRouter(&svcStub{})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, "path=%s body=%s", path, w.Body.String())
+	}
+}
+
+func Test_Stats_ServiceErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: unknown group_by", service.ErrValidation), http.StatusBadRequest},
+		{&service.ValidationError{Errors: []service.FieldError{{Field: "group_by", Rule: "required", Message: "is required"}}}, http.StatusUnprocessableEntity},
+		{service.ErrUnsupported, http.StatusNotImplemented},
+		{fmt.Errorf("boom"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		r := newRouter(&svcStub{
+			basketStats: func(models.StatsFilter) ([]models.BasketRow, error) { return nil, tc.err },
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/stats/basket?group_by=month", nil)
+		r.ServeHTTP(w, req)
+		require.Equal(t, tc.code, w.Code, "err=%v body=%s", tc.err, w.Body.String())
+	}
+}
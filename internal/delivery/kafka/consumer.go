@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	kafka "github.com/segmentio/kafka-go"
 
+	"l0-demo/internal/models"
 	"l0-demo/internal/service"
 )
 
@@ -19,24 +21,36 @@ type Config struct {
 	DLQ         string
 	MaxRetries  int
 	BaseBackoff time.Duration
+
+	// OffsetsInDB keeps consumed offsets in Postgres, in the same transaction
+	// as the order, instead of committing them to Kafka.
+	OffsetsInDB bool
 }
 
 type Consumer struct {
 	reader *kafka.Reader
 	dlq    *kafka.Writer
 	svc    service.Order
+
+	brokers     []string
+	topic       string
+	groupID     string
+	offsetsInDB bool
 }
 
 func NewConsumer(cfg Config, svc service.Order) *Consumer {
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        cfg.Brokers,
-		GroupID:        cfg.GroupID,
-		Topic:          cfg.Topic,
-		MinBytes:       1,
-		MaxBytes:       10e6,
-		MaxWait:        100 * time.Millisecond,
-		CommitInterval: 0,
-	})
+	var r *kafka.Reader
+	if !cfg.OffsetsInDB {
+		r = kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        cfg.Brokers,
+			GroupID:        cfg.GroupID,
+			Topic:          cfg.Topic,
+			MinBytes:       1,
+			MaxBytes:       10e6,
+			MaxWait:        100 * time.Millisecond,
+			CommitInterval: 0,
+		})
+	}
 	w := &kafka.Writer{
 		Addr:                   kafka.TCP(cfg.Brokers...),
 		Topic:                  cfg.DLQ,
@@ -53,10 +67,21 @@ func NewConsumer(cfg Config, svc service.Order) *Consumer {
 		cfg.BaseBackoff = 200 * time.Millisecond
 	}
 
-	return &Consumer{reader: r, dlq: w, svc: svc}
+	return &Consumer{
+		reader:      r,
+		dlq:         w,
+		svc:         svc,
+		brokers:     cfg.Brokers,
+		topic:       cfg.Topic,
+		groupID:     cfg.GroupID,
+		offsetsInDB: cfg.OffsetsInDB,
+	}
 }
 
 func (c *Consumer) Subscribe(ctx context.Context) error {
+	if c.offsetsInDB {
+		return c.subscribeWithDBOffsets(ctx)
+	}
 	    for {
         select {
         case <-ctx.Done():
@@ -79,22 +104,10 @@ func (c *Consumer) Subscribe(ctx context.Context) error {
         }
 
         log.Printf("[cons] fetched topic=%s part=%d off=%d key=%q", m.Topic, m.Partition, m.Offset, string(m.Key))
+		receivedAt := time.Now().UTC()
 
 
-		ok := false
-		var last error
-		for attempt := 0; attempt <= c.cfg().MaxRetries; attempt++ {
-			if e := c.svc.HandleMessage(ctx, m.Value); e == nil {
-				ok = true
-				break
-			} else if isNonRetryable(e) {
-				last = e
-				break
-			} else {
-				last = e
-				time.Sleep(backoff(attempt, c.cfg().BaseBackoff))
-			}
-		}
+		ok, _, last := c.handle(service.WithMessageMeta(ctx, messageMeta(m)), m, receivedAt)
 
 		if ok {
 			if err := c.reader.CommitMessages(ctx, m); err != nil {
@@ -107,18 +120,7 @@ func (c *Consumer) Subscribe(ctx context.Context) error {
                 return nil
             }
 
-		dlqMsg := kafka.Message{
-			Key:   m.Key,
-			Value: m.Value,
-			Headers: append(m.Headers,
-				kafka.Header{Key: "x-dlq-reason", Value: []byte(trimErr(last))},
-				kafka.Header{Key: "x-dlq-attempts", Value: []byte(strconv.Itoa(c.cfg().MaxRetries + 1))},
-				kafka.Header{Key: "x-dlq-ts", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
-				kafka.Header{Key: "x-dlq-source-topic", Value: []byte(c.reader.Config().Topic)},
-				kafka.Header{Key: "x-dlq-group", Value: []byte(c.reader.Config().GroupID)},
-			),
-		}
-		if err := c.dlq.WriteMessages(ctx, dlqMsg); err != nil {
+		if err := c.dlq.WriteMessages(ctx, c.dlqMessage(m, last)); err != nil {
                 if ctx.Err() != nil {
                     return nil
                 }
@@ -175,6 +177,100 @@ func trimErr(err error) string {
     return s
 }
 
+// handle runs HandleMessage with retries and archives the message with its
+// outcome. ok reports whether the message is done with; otherwise it belongs
+// in the DLQ.
+func (c *Consumer) handle(ctx context.Context, m kafka.Message, receivedAt time.Time) (ok bool, outcome string, last error) {
+	outcome = models.MessageDeadLetter
+	for attempt := 0; attempt <= c.cfg().MaxRetries; attempt++ {
+		if e := c.svc.HandleMessage(ctx, m.Value); e == nil {
+			ok = true
+			outcome = models.MessageProcessed
+			break
+		} else if errors.Is(e, service.ErrStale) {
+			log.Printf("[cons] skip stale message (offset %d, partition %d): %v", m.Offset, m.Partition, e)
+			ok = true
+			outcome = models.MessageStale
+			last = e
+			break
+		} else if isNonRetryable(e) {
+			last = e
+			outcome = models.MessageInvalid
+			break
+		} else if ctx.Err() != nil {
+			return false, outcome, e
+		} else {
+			last = e
+			time.Sleep(backoff(attempt, c.cfg().BaseBackoff))
+		}
+	}
+
+	c.record(ctx, m, receivedAt, outcome, last)
+	return ok, outcome, last
+}
+
+// dlqMessage copies m for the DLQ with headers telling why it failed. The
+// checks an invalid order failed are listed in x-dlq-validation as the JSON
+// array of the service's FieldError.
+func (c *Consumer) dlqMessage(m kafka.Message, last error) kafka.Message {
+	headers := append(m.Headers,
+		kafka.Header{Key: "x-dlq-reason", Value: []byte(trimErr(last))},
+		kafka.Header{Key: "x-dlq-attempts", Value: []byte(strconv.Itoa(c.cfg().MaxRetries + 1))},
+		kafka.Header{Key: "x-dlq-ts", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+		kafka.Header{Key: "x-dlq-source-topic", Value: []byte(c.topic)},
+		kafka.Header{Key: "x-dlq-group", Value: []byte(c.groupID)},
+	)
+	var ve *service.ValidationError
+	if errors.As(last, &ve) {
+		if b, err := json.Marshal(ve.Errors); err == nil {
+			headers = append(headers, kafka.Header{Key: "x-dlq-validation", Value: b})
+		}
+	}
+	return kafka.Message{
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}
+}
+
+// record archives the message with its outcome. Failing to archive is logged
+// and does not hold the message back.
+func (c *Consumer) record(ctx context.Context, m kafka.Message, receivedAt time.Time, outcome string, cause error) {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	err := c.svc.RecordMessage(ctx, models.RawMessage{
+		Topic:      m.Topic,
+		Partition:  m.Partition,
+		Offset:     m.Offset,
+		Key:        string(m.Key),
+		Headers:    headers,
+		Payload:    string(m.Value),
+		ReceivedAt: receivedAt,
+		Outcome:    outcome,
+		Error:      trimErr(cause),
+	})
+	if err != nil && !errors.Is(err, service.ErrUnsupported) {
+		log.Printf("archive message failed (offset %d, partition %d): %v", m.Offset, m.Partition, err)
+	}
+}
+
+func messageMeta(m kafka.Message) service.MessageMeta {
+	headers := make(map[string][]byte, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = h.Value
+	}
+	return service.MessageMeta{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Headers:   headers,
+		Time:      m.Time,
+	}
+}
+
 func isNonRetryable(err error) bool {
 	return errors.Is(err, service.ErrDecode) || errors.Is(err, service.ErrValidation)
 }
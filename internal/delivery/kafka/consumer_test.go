@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository"
+	"l0-demo/internal/service"
+)
+
+func TestConsumer_InvalidOrderGoesToDLQWithFieldErrors(t *testing.T) {
+	c := &Consumer{svc: service.NewService(&repository.Repository{}), topic: "orders", groupID: "order-svc"}
+	m := kafka.Message{Topic: "orders", Value: []byte(`{"order_uid":"short","locale":"de"}`)}
+
+	start := time.Now()
+	ok, outcome, last := c.handle(context.Background(), m, time.Now())
+	require.False(t, ok)
+	require.Equal(t, models.MessageInvalid, outcome)
+	require.ErrorIs(t, last, service.ErrValidation)
+	require.Less(t, time.Since(start), 200*time.Millisecond, "invalid orders are not retried")
+
+	dlq := c.dlqMessage(m, last)
+	var fields []service.FieldError
+	for _, h := range dlq.Headers {
+		if h.Key == "x-dlq-validation" {
+			require.NoError(t, json.Unmarshal(h.Value, &fields))
+		}
+	}
+	require.Contains(t, fields, service.FieldError{Field: "order_uid", Rule: "len", Param: "19", Message: "must be 19 characters long"})
+	require.Contains(t, fields, service.FieldError{Field: "locale", Rule: "oneof", Param: "ru en", Message: "must be one of ru, en"})
+
+	dlq = c.dlqMessage(m, context.DeadlineExceeded)
+	for _, h := range dlq.Headers {
+		require.NotEqual(t, "x-dlq-validation", h.Key)
+	}
+}
+
+func TestConsumer_OffsetsInDBNeedOffsetStore(t *testing.T) {
+	c := &Consumer{svc: service.NewService(&repository.Repository{}), topic: "orders", groupID: "order-svc", offsetsInDB: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.ErrorIs(t, c.Subscribe(ctx), service.ErrUnsupported)
+
+	start := time.Now()
+	c.storeOffset(ctx, kafka.Message{Topic: "orders", Offset: 1})
+	require.Less(t, time.Since(start), 200*time.Millisecond, "unsupported offsets are not retried")
+}
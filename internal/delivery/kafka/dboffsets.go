@@ -0,0 +1,189 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+	"l0-demo/internal/service"
+)
+
+// subscribeWithDBOffsets consumes as a member of the group, but reads every
+// assigned partition from the offset stored in Postgres. Orders are written
+// together with their offset, so a message is applied at most once even if
+// the process dies before anything is committed to Kafka. Kafka commits are
+// still made, best effort, to keep lag monitoring working. It fails at once
+// if the order store cannot keep offsets.
+func (c *Consumer) subscribeWithDBOffsets(ctx context.Context) error {
+	if _, err := c.svc.StoredOffsets(ctx, c.groupID, c.topic); errors.Is(err, service.ErrUnsupported) {
+		return fmt.Errorf("offsets in the database: %w", err)
+	}
+
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      c.groupID,
+		Brokers: c.brokers,
+		Topics:  []string{c.topic},
+	})
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+
+	for {
+		gen, err := group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				return nil
+			}
+			log.Printf("kafka group error: %v", err)
+			select {
+			case <-time.After(300 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		for _, a := range gen.Assignments[c.topic] {
+			partition, committed := a.ID, a.Offset
+			gen.Start(func(gctx context.Context) {
+				c.consumePartition(gctx, gen, partition, committed)
+			})
+		}
+	}
+}
+
+// consumePartition must only return once ctx is done: the group ends the
+// generation as soon as one of its functions exits.
+func (c *Consumer) consumePartition(ctx context.Context, gen *kafka.Generation, partition int, committed int64) {
+	offset, ok := c.storedOffset(ctx, partition)
+	if !ok {
+		<-ctx.Done()
+		return
+	}
+	if offset < 0 {
+		offset = committed
+	}
+	log.Printf("[cons] partition %d assigned, reading from offset %d", partition, offset)
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.brokers,
+		Topic:     c.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+		MaxWait:   100 * time.Millisecond,
+	})
+	defer r.Close()
+	if err := r.SetOffset(offset); err != nil {
+		log.Printf("seek partition %d to %d failed: %v", partition, offset, err)
+		<-ctx.Done()
+		return
+	}
+
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("kafka read error (partition %d): %v", partition, err)
+			if !sleepCtx(ctx, 300*time.Millisecond) {
+				return
+			}
+			continue
+		}
+		receivedAt := time.Now().UTC()
+
+		meta := messageMeta(m)
+		meta.Group = c.groupID
+		ok, outcome, last := c.handle(service.WithMessageMeta(ctx, meta), m, receivedAt)
+		if !ok && !c.deadLetterUntilDone(ctx, m, last) {
+			return
+		}
+		if outcome != models.MessageProcessed {
+			c.storeOffset(ctx, m)
+		}
+
+		if err := gen.CommitOffsets(map[string]map[int]int64{m.Topic: {m.Partition: m.Offset + 1}}); err != nil && ctx.Err() == nil {
+			log.Printf("kafka commit (offset %d, partition %d) failed: %v", m.Offset, m.Partition, err)
+		}
+	}
+}
+
+// storedOffset returns the offset to resume the partition from, or -1 when
+// none is stored yet. It keeps retrying while the database is unavailable,
+// but not when the store cannot keep offsets at all.
+func (c *Consumer) storedOffset(ctx context.Context, partition int) (int64, bool) {
+	for {
+		offsets, err := c.svc.StoredOffsets(ctx, c.groupID, c.topic)
+		if err == nil {
+			if next, ok := offsets[partition]; ok {
+				return next, true
+			}
+			return -1, true
+		}
+		log.Printf("load stored offsets failed (partition %d): %v", partition, err)
+		if errors.Is(err, service.ErrUnsupported) {
+			return 0, false
+		}
+		if !sleepCtx(ctx, time.Second) {
+			return 0, false
+		}
+	}
+}
+
+// storeOffset records a message that left no order behind, so it is not read
+// again after a restart. Like storedOffset, it gives up only when the store
+// cannot keep offsets.
+func (c *Consumer) storeOffset(ctx context.Context, m kafka.Message) {
+	off := storage.Offset{Group: c.groupID, Topic: m.Topic, Partition: m.Partition, Offset: m.Offset}
+	for {
+		err := c.svc.CommitOffset(ctx, off)
+		if err == nil {
+			return
+		}
+		log.Printf("store offset %d (partition %d) failed: %v", m.Offset, m.Partition, err)
+		if errors.Is(err, service.ErrUnsupported) {
+			return
+		}
+		if !sleepCtx(ctx, time.Second) {
+			return
+		}
+	}
+}
+
+func (c *Consumer) deadLetterUntilDone(ctx context.Context, m kafka.Message, last error) bool {
+	for {
+		if c.dlq == nil {
+			log.Printf("DLQ disabled, drop message (offset %d, partition %d): %v", m.Offset, m.Partition, last)
+			return true
+		}
+		err := c.dlq.WriteMessages(ctx, c.dlqMessage(m, last))
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		log.Printf("write to DLQ failed (offset %d, partition %d): %v", m.Offset, m.Partition, err)
+		if !sleepCtx(ctx, 500*time.Millisecond) {
+			return false
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
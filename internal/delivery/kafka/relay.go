@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/service"
+)
+
+const relayPurgeInterval = 10 * time.Minute
+
+type RelayConfig struct {
+	Brokers   []string
+	Topic     string
+	Interval  time.Duration
+	BatchSize int
+	Retention time.Duration
+}
+
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Relay publishes events stored in the outbox to Kafka. Events are keyed by
+// order uid, so events of one order land on one partition in the order they
+// were stored. A crash between publishing and marking a batch delivered
+// publishes it again, so consumers must tolerate duplicates.
+type Relay struct {
+	writer messageWriter
+	events service.OrderEvents
+	cfg    RelayConfig
+}
+
+func NewRelay(cfg RelayConfig, events service.OrderEvents) *Relay {
+	w := &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Brokers...),
+		Topic:                  cfg.Topic,
+		RequiredAcks:           kafka.RequireAll,
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+		BatchTimeout:           10 * time.Millisecond,
+	}
+	return newRelay(cfg, events, w)
+}
+
+func newRelay(cfg RelayConfig, events service.OrderEvents, w messageWriter) *Relay {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 500 * time.Millisecond
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return &Relay{writer: w, events: events, cfg: cfg}
+}
+
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+	for {
+		if err := r.drain(ctx); err != nil {
+			if errors.Is(err, service.ErrUnsupported) {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[relay] publish failed, will retry: %v", err)
+		}
+
+		if r.cfg.Retention > 0 && time.Since(lastPurge) >= relayPurgeInterval {
+			n, err := r.events.PurgeOrderEvents(ctx, time.Now().Add(-r.cfg.Retention))
+			if err != nil {
+				log.Printf("[relay] purge failed: %v", err)
+			} else if n > 0 {
+				log.Printf("[relay] purged %d delivered events", n)
+			}
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain relays full batches until the outbox has no more pending events.
+func (r *Relay) drain(ctx context.Context) error {
+	for {
+		n, err := r.events.RelayOrderEvents(ctx, r.cfg.BatchSize, func(events []models.OrderEvent) error {
+			return r.publish(ctx, events)
+		})
+		if err != nil {
+			return err
+		}
+		if n < r.cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, events []models.OrderEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(e.OrderUid),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: service.VersionHeader, Value: []byte(strconv.FormatInt(e.Version, 10))},
+				{Key: "x-event-type", Value: []byte(e.Type)},
+			},
+		})
+	}
+	return r.writer.WriteMessages(ctx, msgs...)
+}
+
+func (r *Relay) Close() error {
+	return r.writer.Close()
+}
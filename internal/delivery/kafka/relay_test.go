@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/service"
+)
+
+type writerStub struct {
+	err  error
+	msgs []kafka.Message
+}
+
+func (w *writerStub) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func (w *writerStub) Close() error { return nil }
+
+type eventsStub struct {
+	pending   []models.OrderEvent
+	delivered []models.OrderEvent
+	purged    time.Time
+}
+
+func (s *eventsStub) RelayOrderEvents(_ context.Context, limit int, publish func([]models.OrderEvent) error) (int, error) {
+	n := min(limit, len(s.pending))
+	if n == 0 {
+		return 0, nil
+	}
+	if err := publish(s.pending[:n]); err != nil {
+		return 0, err
+	}
+	s.delivered = append(s.delivered, s.pending[:n]...)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *eventsStub) PurgeOrderEvents(_ context.Context, before time.Time) (int64, error) {
+	s.purged = before
+	return 0, nil
+}
+
+var _ service.OrderEvents = (*eventsStub)(nil)
+
+func TestRelay_DrainsInOrderKeyedByUID(t *testing.T) {
+	ev := &eventsStub{}
+	for i, uid := range []string{"a", "b", "a", "c", "a"} {
+		ev.pending = append(ev.pending, models.OrderEvent{ID: int64(i + 1), Type: models.OrderStoredEvent, OrderUid: uid, Version: int64(i)})
+	}
+	w := &writerStub{}
+	r := newRelay(RelayConfig{BatchSize: 2}, ev, w)
+
+	require.NoError(t, r.drain(context.Background()))
+	require.Empty(t, ev.pending)
+	require.Len(t, w.msgs, 5)
+
+	var versionsOfA []string
+	for _, m := range w.msgs {
+		if string(m.Key) == "a" {
+			versionsOfA = append(versionsOfA, string(m.Headers[0].Value))
+		}
+	}
+	require.Equal(t, []string{"0", "2", "4"}, versionsOfA)
+	require.JSONEq(t, `{"type":"order.stored","order_uid":"a","version":0,"stored_at":"0001-01-01T00:00:00Z"}`, string(w.msgs[0].Value))
+}
+
+func TestRelay_PublishFailureKeepsEventsPending(t *testing.T) {
+	ev := &eventsStub{pending: []models.OrderEvent{{ID: 1, OrderUid: "a"}}}
+	w := &writerStub{err: errors.New("broker down")}
+	r := newRelay(RelayConfig{}, ev, w)
+
+	require.Error(t, r.drain(context.Background()))
+	require.Len(t, ev.pending, 1)
+
+	w.err = nil
+	require.NoError(t, r.drain(context.Background()))
+	require.Empty(t, ev.pending)
+	require.Len(t, w.msgs, 1)
+}
+
+func TestRelay_RunPurgesAndStops(t *testing.T) {
+	ev := &eventsStub{}
+	r := newRelay(RelayConfig{Interval: time.Millisecond, Retention: time.Hour}, ev, &writerStub{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.NoError(t, r.Run(ctx))
+	require.WithinDuration(t, time.Now().Add(-time.Hour), ev.purged, time.Second)
+}
@@ -0,0 +1,47 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"l0-demo/internal/models"
+)
+
+// csvWriter writes a header, then one record per item.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) Write(o models.Order) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	for _, r := range Rows(o) {
+		if err := w.w.Write(r.record()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the header once, also for an export without orders.
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.w.Write(Columns())
+}
+
+func (w *csvWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,128 @@
+// Package export writes orders for analysis: as NDJSON with one order per
+// line, or flattened to one row per item as CSV or Parquet.
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+type Format string
+
+const (
+	NDJSON  Format = "ndjson"
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+var ErrFormat = errors.New("unknown export format")
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case NDJSON, CSV, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w %q: want ndjson, csv or parquet", ErrFormat, s)
+}
+
+// Writer encodes orders one at a time.
+type Writer interface {
+	Write(o models.Order) error
+	// Close flushes what is buffered. It does not close the underlying
+	// writer.
+	Close() error
+}
+
+// NewWriter returns a Writer encoding orders in format f to w. With
+// compress, NDJSON and CSV are gzipped as a whole, while Parquet compresses
+// its column chunks with gzip so that the file stays readable as Parquet.
+func NewWriter(f Format, w io.Writer, compress bool) (Writer, error) {
+	if f == Parquet {
+		return newParquetWriter(w, compress), nil
+	}
+
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	var enc Writer
+	switch f {
+	case NDJSON:
+		enc = &ndjsonWriter{enc: json.NewEncoder(w)}
+	case CSV:
+		enc = newCSVWriter(w)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrFormat, f)
+	}
+	if zw == nil {
+		return enc, nil
+	}
+	return &gzipWriter{Writer: enc, zw: zw}, nil
+}
+
+// Source is the part of an order store an export reads from.
+type Source interface {
+	Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error
+}
+
+type Options struct {
+	Filter         storage.OrderFilter
+	IncludeDeleted bool
+	// Anonymize replaces the personal data of deliveries with the
+	// placeholders of an erasure.
+	Anonymize bool
+	BatchSize int
+}
+
+// Orders streams the orders of src selected by opts to w, in order_uid
+// order, and returns how many it wrote. It does not close w.
+func Orders(ctx context.Context, src Source, w Writer, opts Options) (int, error) {
+	readOpts := []storage.ReadOption{storage.Matching(opts.Filter)}
+	if opts.IncludeDeleted {
+		readOpts = append(readOpts, storage.IncludeDeleted())
+	}
+	n := 0
+	err := src.Each(ctx, opts.BatchSize, func(o models.Order) error {
+		if opts.Anonymize && o.Delivery != nil {
+			d := *o.Delivery
+			pii.EraseDelivery(&d)
+			o.Delivery = &d
+		}
+		if err := w.Write(o); err != nil {
+			return err
+		}
+		n++
+		return nil
+	}, readOpts...)
+	return n, err
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(o models.Order) error { return w.enc.Encode(o) }
+
+func (w *ndjsonWriter) Close() error { return nil }
+
+// gzipWriter ends the gzip stream once the encoder has flushed into it.
+type gzipWriter struct {
+	Writer
+	zw *gzip.Writer
+}
+
+func (w *gzipWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
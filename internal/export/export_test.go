@@ -0,0 +1,175 @@
+package export_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/export"
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/repotest"
+	"l0-demo/internal/repository/storage"
+)
+
+func orders() []models.Order {
+	a := repotest.FullOrder(repotest.UID("export-a"), 2)
+	a.DateCreated = time.Date(2024, 3, 10, 12, 0, 0, 0, time.FixedZone("MSK", 3*3600))
+	b := repotest.FullOrder(repotest.UID("export-b"), 0)
+	b.DateCreated = time.Date(2024, 3, 11, 9, 30, 0, 0, time.UTC)
+	deleted := time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+	b.DeletedAt = &deleted
+	return []models.Order{a, b}
+}
+
+func write(t *testing.T, f export.Format, compress bool, in []models.Order) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := export.NewWriter(f, &buf, compress)
+	require.NoError(t, err)
+	for _, o := range in {
+		require.NoError(t, w.Write(o))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return out
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []export.Format{export.NDJSON, export.CSV, export.Parquet} {
+		got, err := export.ParseFormat(string(f))
+		require.NoError(t, err)
+		require.Equal(t, f, got)
+	}
+	_, err := export.ParseFormat("xlsx")
+	require.ErrorIs(t, err, export.ErrFormat)
+}
+
+func TestNDJSON(t *testing.T) {
+	in := orders()
+	for _, compress := range []bool{false, true} {
+		data := write(t, export.NDJSON, compress, in)
+		if compress {
+			data = gunzip(t, data)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for _, want := range in {
+			var got models.Order
+			require.NoError(t, dec.Decode(&got))
+			require.Equal(t, want.OrderUid, got.OrderUid)
+			require.Len(t, got.Items, len(want.Items))
+		}
+		require.False(t, dec.More())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	data := gunzip(t, write(t, export.CSV, true, orders()))
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4, "header, two items of a and the item-less b")
+
+	cols := make(map[string]int)
+	for i, c := range records[0] {
+		cols[c] = i
+	}
+	require.Equal(t, export.Columns(), records[0])
+	a, b := orders()[0], orders()[1]
+	require.Equal(t, a.OrderUid, records[1][cols["order_uid"]])
+	require.Equal(t, a.Items[1].Rid, records[2][cols["item_rid"]])
+	require.Equal(t, "2024-03-10T09:00:00Z", records[1][cols["date_created"]], "times are exported in UTC")
+	require.Equal(t, "", records[1][cols["deleted_at"]])
+	require.Equal(t, "1000", records[1][cols["payment_amount"]])
+	require.Equal(t, b.OrderUid, records[3][cols["order_uid"]])
+	require.Equal(t, "", records[3][cols["item_rid"]])
+	require.Equal(t, "2024-03-12T00:00:00Z", records[3][cols["deleted_at"]])
+
+	empty := write(t, export.CSV, false, nil)
+	records, err = csv.NewReader(bytes.NewReader(empty)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{export.Columns()}, records, "an empty export still has a header")
+}
+
+func TestParquet(t *testing.T) {
+	in := orders()
+	var want []export.Row
+	for _, o := range in {
+		want = append(want, export.Rows(o)...)
+	}
+	for _, compress := range []bool{false, true} {
+		data := write(t, export.Parquet, compress, in)
+		got, err := parquet.Read[export.Row](bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Len(t, got, len(want))
+		for i := range want {
+			require.Equal(t, want[i].OrderUid, got[i].OrderUid)
+			require.Equal(t, want[i].ItemRid, got[i].ItemRid)
+			require.Equal(t, want[i].PaymentAmount, got[i].PaymentAmount)
+			require.True(t, want[i].DateCreated.Equal(got[i].DateCreated))
+			require.Equal(t, want[i].DeletedAt == nil, got[i].DeletedAt == nil)
+		}
+	}
+}
+
+type sourceStub struct {
+	orders []models.Order
+	opts   storage.ReadOptions
+	batch  int
+}
+
+func (s *sourceStub) Each(_ context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error {
+	s.batch, s.opts = batchSize, storage.NewReadOptions(opts...)
+	for _, o := range s.orders {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestOrders(t *testing.T) {
+	in := orders()
+	src := &sourceStub{orders: in}
+	filter := storage.OrderFilter{CustomerId: "test", From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	var buf bytes.Buffer
+	w, err := export.NewWriter(export.NDJSON, &buf, false)
+	require.NoError(t, err)
+	n, err := export.Orders(context.Background(), src, w, export.Options{
+		Filter: filter, IncludeDeleted: true, Anonymize: true, BatchSize: 50,
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, 2, n)
+	require.Equal(t, 50, src.batch)
+	require.Equal(t, storage.ReadOptions{IncludeDeleted: true, Filter: filter}, src.opts)
+	require.NotContains(t, buf.String(), in[0].Delivery.Name)
+	require.NotContains(t, buf.String(), in[0].Delivery.Email)
+	require.Contains(t, buf.String(), `"city":"`+in[0].Delivery.City+`"`)
+	require.Equal(t, "John Doe", in[0].Delivery.Name, "the source orders are not modified")
+
+	_, err = export.Orders(context.Background(), src, failingWriter{}, export.Options{})
+	require.EqualError(t, err, "disk full")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(models.Order) error { return errors.New("disk full") }
+func (failingWriter) Close() error             { return nil }
@@ -0,0 +1,28 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"l0-demo/internal/models"
+)
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[Row]
+}
+
+func newParquetWriter(w io.Writer, compress bool) *parquetWriter {
+	var opts []parquet.WriterOption
+	if compress {
+		opts = append(opts, parquet.Compression(&parquet.Gzip))
+	}
+	return &parquetWriter{w: parquet.NewGenericWriter[Row](w, opts...)}
+}
+
+func (w *parquetWriter) Write(o models.Order) error {
+	_, err := w.w.Write(Rows(o))
+	return err
+}
+
+func (w *parquetWriter) Close() error { return w.w.Close() }
@@ -0,0 +1,156 @@
+package export
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"l0-demo/internal/models"
+)
+
+// Row is an order flattened to one of its items, the shape of the CSV and
+// Parquet exports. An order without items gives one row with empty item
+// columns.
+type Row struct {
+	OrderUid          string     `parquet:"order_uid"`
+	TrackNumber       string     `parquet:"track_number"`
+	Entry             string     `parquet:"entry"`
+	Locale            string     `parquet:"locale"`
+	InternalSignature string     `parquet:"internal_signature"`
+	CustomerId        string     `parquet:"customer_id"`
+	DeliveryService   string     `parquet:"delivery_service"`
+	ShardKey          string     `parquet:"shardkey"`
+	SmId              int64      `parquet:"sm_id"`
+	DateCreated       time.Time  `parquet:"date_created"`
+	OofShard          string     `parquet:"oof_shard"`
+	Version           int64      `parquet:"version"`
+	DeletedAt         *time.Time `parquet:"deleted_at,optional"`
+
+	DeliveryName    string `parquet:"delivery_name"`
+	DeliveryPhone   string `parquet:"delivery_phone"`
+	DeliveryZip     string `parquet:"delivery_zip"`
+	DeliveryCity    string `parquet:"delivery_city"`
+	DeliveryAddress string `parquet:"delivery_address"`
+	DeliveryRegion  string `parquet:"delivery_region"`
+	DeliveryEmail   string `parquet:"delivery_email"`
+
+	PaymentTransaction  string `parquet:"payment_transaction"`
+	PaymentRequestId    string `parquet:"payment_request_id"`
+	PaymentCurrency     string `parquet:"payment_currency"`
+	PaymentProvider     string `parquet:"payment_provider"`
+	PaymentAmount       int64  `parquet:"payment_amount"`
+	PaymentDt           int64  `parquet:"payment_dt"`
+	PaymentBank         string `parquet:"payment_bank"`
+	PaymentDeliveryCost int64  `parquet:"payment_delivery_cost"`
+	PaymentGoodsTotal   int64  `parquet:"payment_goods_total"`
+	PaymentCustomFee    int64  `parquet:"payment_custom_fee"`
+
+	ItemChrtId      int64  `parquet:"item_chrt_id"`
+	ItemTrackNumber string `parquet:"item_track_number"`
+	ItemPrice       int64  `parquet:"item_price"`
+	ItemRid         string `parquet:"item_rid"`
+	ItemName        string `parquet:"item_name"`
+	ItemSale        int64  `parquet:"item_sale"`
+	ItemSize        string `parquet:"item_size"`
+	ItemTotalPrice  int64  `parquet:"item_total_price"`
+	ItemNmId        int64  `parquet:"item_nm_id"`
+	ItemBrand       string `parquet:"item_brand"`
+	ItemStatus      int64  `parquet:"item_status"`
+}
+
+// Rows flattens o to one row per item.
+func Rows(o models.Order) []Row {
+	head := Row{
+		OrderUid:          o.OrderUid,
+		TrackNumber:       o.TrackNumber,
+		Entry:             o.Entry,
+		Locale:            o.Locale,
+		InternalSignature: o.InternalSignature,
+		CustomerId:        o.CustomerId,
+		DeliveryService:   o.DeliveryService,
+		ShardKey:          o.ShardKey,
+		SmId:              int64(o.SmId),
+		DateCreated:       o.DateCreated.UTC(),
+		OofShard:          o.OofShard,
+		Version:           o.Version,
+	}
+	if o.DeletedAt != nil {
+		deleted := o.DeletedAt.UTC()
+		head.DeletedAt = &deleted
+	}
+	if d := o.Delivery; d != nil {
+		head.DeliveryName = d.Name
+		head.DeliveryPhone = d.Phone
+		head.DeliveryZip = d.Zip
+		head.DeliveryCity = d.City
+		head.DeliveryAddress = d.Address
+		head.DeliveryRegion = d.Region
+		head.DeliveryEmail = d.Email
+	}
+	if p := o.Payment; p != nil {
+		head.PaymentTransaction = p.Transaction
+		head.PaymentRequestId = p.RequestId
+		head.PaymentCurrency = p.Currency
+		head.PaymentProvider = p.Provider
+		head.PaymentAmount = int64(p.Amount)
+		head.PaymentDt = int64(p.PaymentDt)
+		head.PaymentBank = p.Bank
+		head.PaymentDeliveryCost = int64(p.DeliveryCost)
+		head.PaymentGoodsTotal = int64(p.GoodsTotal)
+		head.PaymentCustomFee = int64(p.CustomFee)
+	}
+	if len(o.Items) == 0 {
+		return []Row{head}
+	}
+
+	rows := make([]Row, 0, len(o.Items))
+	for _, it := range o.Items {
+		r := head
+		r.ItemChrtId = int64(it.ChrtId)
+		r.ItemTrackNumber = it.TrackNumber
+		r.ItemPrice = int64(it.Price)
+		r.ItemRid = it.Rid
+		r.ItemName = it.Name
+		r.ItemSale = int64(it.Sale)
+		r.ItemSize = it.Size
+		r.ItemTotalPrice = int64(it.TotalPrice)
+		r.ItemNmId = int64(it.NmId)
+		r.ItemBrand = it.Brand
+		r.ItemStatus = int64(it.Status)
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// Columns are the names of the Row columns, in order.
+func Columns() []string {
+	t := reflect.TypeOf(Row{})
+	cols := make([]string, t.NumField())
+	for i := range cols {
+		cols[i], _, _ = strings.Cut(t.Field(i).Tag.Get("parquet"), ",")
+	}
+	return cols
+}
+
+// record renders r as CSV fields, in the order of Columns. Times are
+// RFC 3339 in UTC and a missing deleted_at is empty.
+func (r Row) record() []string {
+	v := reflect.ValueOf(r)
+	out := make([]string, v.NumField())
+	for i := range out {
+		switch f := v.Field(i).Interface().(type) {
+		case string:
+			out[i] = f
+		case int64:
+			out[i] = strconv.FormatInt(f, 10)
+		case time.Time:
+			out[i] = f.Format(time.RFC3339Nano)
+		case *time.Time:
+			if f != nil {
+				out[i] = f.Format(time.RFC3339Nano)
+			}
+		}
+	}
+	return out
+}
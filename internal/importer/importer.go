@@ -0,0 +1,152 @@
+// Package importer loads orders into the store in bulk, bypassing Kafka:
+// records are read from NDJSON or a JSON array, checked like consumed
+// messages and written in batches.
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"l0-demo/internal/models"
+)
+
+// Store decodes and stores orders; it is implemented by service.Service.
+type Store interface {
+	DecodeOrder(ctx context.Context, payload []byte) (models.Order, error)
+	ImportOrders(ctx context.Context, orders []models.Order) ([]error, error)
+}
+
+type Options struct {
+	BatchSize int
+	// DryRun only decodes and validates the records. Errors that only the
+	// database can tell, such as stale versions, are not reported then.
+	DryRun bool
+	// ResumeFrom skips the records starting before this line.
+	ResumeFrom int
+}
+
+// Failure is an entry of the error report: a record that was not imported.
+type Failure struct {
+	Line     int    `json:"line"`
+	OrderUid string `json:"order_uid,omitempty"`
+	Error    string `json:"error"`
+}
+
+type Summary struct {
+	Read     int
+	Imported int
+	Failed   int
+	Skipped  int
+	// Resume is the line of the first record not imported yet when Import
+	// failed, to be passed as ResumeFrom to continue.
+	Resume int
+}
+
+const DefaultBatchSize = 500
+
+// Import reads the records of r and stores the valid ones through st in
+// batches of opts.BatchSize. Every record that is rejected, on decoding or by
+// the store, is passed to report and counted as failed without stopping the
+// import; an error of the reader, the store or report stops it.
+func Import(ctx context.Context, r io.Reader, st Store, report func(Failure) error, opts Options) (Summary, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	var (
+		sum    Summary
+		batch  []models.Order
+		lines  []int
+		next   = max(opts.ResumeFrom, 1)
+		reject = func(line int, uid string, err error) error {
+			sum.Failed++
+			return report(Failure{Line: line, OrderUid: uid, Error: err.Error()})
+		}
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if opts.DryRun {
+			sum.Imported += len(batch)
+		} else {
+			errs, err := st.ImportOrders(ctx, batch)
+			if err != nil {
+				return err
+			}
+			for i, err := range errs {
+				if err == nil {
+					sum.Imported++
+				} else if err := reject(lines[i], batch[i].OrderUid, err); err != nil {
+					return err
+				}
+			}
+		}
+		batch, lines = batch[:0], lines[:0]
+		return nil
+	}
+	fail := func(err error) (Summary, error) {
+		if len(lines) > 0 {
+			next = lines[0]
+		}
+		sum.Resume = next
+		return sum, err
+	}
+
+	in := NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		rec, err := in.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ferr := flush(); ferr != nil {
+				return fail(ferr)
+			}
+			return fail(err)
+		}
+		if rec.Line < opts.ResumeFrom {
+			sum.Skipped++
+			continue
+		}
+		sum.Read++
+
+		o, err := st.DecodeOrder(ctx, rec.Data)
+		if err != nil {
+			if err := reject(rec.Line, recordUid(o, rec.Data), err); err != nil {
+				return fail(err)
+			}
+		} else {
+			batch = append(batch, o)
+			lines = append(lines, rec.Line)
+		}
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+		}
+		next = rec.Line + 1
+	}
+	if err := flush(); err != nil {
+		return fail(err)
+	}
+	return sum, nil
+}
+
+// recordUid returns the uid of a rejected record, reading it from the raw
+// data when the record could not be decoded as an order.
+func recordUid(o models.Order, data []byte) string {
+	if o.OrderUid != "" {
+		return o.OrderUid
+	}
+	var head struct {
+		OrderUid string `json:"order_uid"`
+	}
+	json.Unmarshal(data, &head)
+	return strings.TrimSpace(head.OrderUid)
+}
@@ -0,0 +1,173 @@
+package importer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/importer"
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository"
+	"l0-demo/internal/repository/repotest"
+	"l0-demo/internal/service"
+)
+
+// storeStub decodes with the service and records the stored batches.
+type storeStub struct {
+	*service.Service
+	batches [][]string
+	reject  map[string]error
+	failAt  int
+}
+
+func newStore() *storeStub {
+	return &storeStub{Service: service.NewService(&repository.Repository{}), reject: map[string]error{}}
+}
+
+func (s *storeStub) ImportOrders(_ context.Context, orders []models.Order) ([]error, error) {
+	if s.failAt > 0 && len(s.batches)+1 == s.failAt {
+		return nil, errors.New("db down")
+	}
+	var uids []string
+	errs := make([]error, len(orders))
+	for i, o := range orders {
+		uids = append(uids, o.OrderUid)
+		errs[i] = s.reject[o.OrderUid]
+	}
+	s.batches = append(s.batches, uids)
+	return errs, nil
+}
+
+func payload(t *testing.T, uid string) string {
+	t.Helper()
+	b, err := json.Marshal(repotest.FullOrder(repotest.UID(uid), 1))
+	require.NoError(t, err)
+	return string(b)
+}
+
+func records(t *testing.T, in string) []importer.Record {
+	t.Helper()
+	r := importer.NewReader(strings.NewReader(in))
+	var out []importer.Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+		out = append(out, rec)
+	}
+}
+
+func TestReader_NDJSON(t *testing.T) {
+	got := records(t, "\n  {\"a\":1}\r\n\n{\"b\":2}\nnot json\n{\"c\":3}")
+	require.Len(t, got, 4)
+	for i, want := range []struct {
+		line int
+		data string
+	}{{2, `{"a":1}`}, {4, `{"b":2}`}, {5, `not json`}, {6, `{"c":3}`}} {
+		require.Equal(t, want.line, got[i].Line)
+		require.Equal(t, want.data, string(got[i].Data))
+	}
+
+	require.Empty(t, records(t, ""))
+	require.Empty(t, records(t, "\n \n"))
+}
+
+func TestReader_Array(t *testing.T) {
+	got := records(t, "\n[\n  {\"a\":1},\n  {\"b\":\n 2}, {\"c\":3},\n\n{\"d\":[4]}\n]\n")
+	require.Len(t, got, 4)
+	for i, line := range []int{3, 4, 5, 7} {
+		require.Equal(t, line, got[i].Line, "record %d", i)
+	}
+	require.Equal(t, `{"d":[4]}`, string(got[3].Data))
+
+	require.Empty(t, records(t, "[]"))
+
+	for _, in := range []string{"[{\"a\":1},", "[{\"a\":1} {\"b\":2}]", "[{\"a\":1}] {}", "[{\"a\":"} {
+		r := importer.NewReader(strings.NewReader(in))
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+		require.NotErrorIs(t, err, io.EOF, "input %q", in)
+	}
+}
+
+func TestImport(t *testing.T) {
+	invalid := repotest.FullOrder(repotest.UID("import-invalid"), 1)
+	invalid.TrackNumber = ""
+	b, err := json.Marshal(invalid)
+	require.NoError(t, err)
+
+	in := strings.Join([]string{
+		payload(t, "import-1"),
+		payload(t, "import-2"),
+		`{"order_uid": "broken", "items": 5}`,
+		string(b),
+		payload(t, "import-stale"),
+		payload(t, "import-3"),
+		"",
+	}, "\n")
+
+	st := newStore()
+	st.reject[repotest.UID("import-stale")] = service.ErrStale
+	var report []importer.Failure
+	collect := func(f importer.Failure) error { report = append(report, f); return nil }
+
+	sum, err := importer.Import(context.Background(), strings.NewReader(in), st, collect, importer.Options{BatchSize: 2})
+	require.NoError(t, err)
+	require.Equal(t, importer.Summary{Read: 6, Imported: 3, Failed: 3}, sum)
+	require.Equal(t, [][]string{
+		{repotest.UID("import-1"), repotest.UID("import-2")},
+		{repotest.UID("import-stale"), repotest.UID("import-3")},
+	}, st.batches)
+
+	require.Len(t, report, 3)
+	require.Equal(t, 3, report[0].Line)
+	require.Equal(t, "broken", report[0].OrderUid)
+	require.Contains(t, report[0].Error, service.ErrDecode.Error())
+	require.Equal(t, 4, report[1].Line)
+	require.Equal(t, invalid.OrderUid, report[1].OrderUid)
+	require.Contains(t, report[1].Error, "track_number: is required")
+	require.Equal(t, importer.Failure{Line: 5, OrderUid: repotest.UID("import-stale"), Error: service.ErrStale.Error()}, report[2])
+
+	t.Run("dry run", func(t *testing.T) {
+		st, report = newStore(), nil
+		sum, err := importer.Import(context.Background(), strings.NewReader(in), st, collect, importer.Options{DryRun: true})
+		require.NoError(t, err)
+		require.Equal(t, importer.Summary{Read: 6, Imported: 4, Failed: 2}, sum)
+		require.Empty(t, st.batches)
+		require.Len(t, report, 2)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		st, report = newStore(), nil
+		st.failAt = 2
+		sum, err := importer.Import(context.Background(), strings.NewReader(in), st, collect, importer.Options{BatchSize: 2})
+		require.EqualError(t, err, "db down")
+		require.Equal(t, 5, sum.Resume, "the failed batch starts on line 5")
+		require.Equal(t, 2, sum.Imported)
+
+		st, report = newStore(), nil
+		sum, err = importer.Import(context.Background(), strings.NewReader(in), st, collect, importer.Options{BatchSize: 2, ResumeFrom: sum.Resume})
+		require.NoError(t, err)
+		require.Equal(t, importer.Summary{Read: 2, Imported: 2, Skipped: 4}, sum)
+		require.Equal(t, [][]string{{repotest.UID("import-stale"), repotest.UID("import-3")}}, st.batches)
+		require.Empty(t, report)
+	})
+
+	t.Run("broken array", func(t *testing.T) {
+		st, report = newStore(), nil
+		in := "[\n" + payload(t, "import-1") + ",\n" + payload(t, "import-2") + "\n{]"
+		sum, err := importer.Import(context.Background(), strings.NewReader(in), st, collect, importer.Options{})
+		require.Error(t, err)
+		require.Equal(t, 4, sum.Resume)
+		require.Equal(t, [][]string{{repotest.UID("import-1"), repotest.UID("import-2")}}, st.batches, "what was read is stored")
+	})
+}
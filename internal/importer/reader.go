@@ -0,0 +1,150 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Record is one order of an input and the line it starts on.
+type Record struct {
+	Line int
+	Data json.RawMessage
+}
+
+// Reader splits an input into records. An input starting with '[' is read
+// as a JSON array of orders, anything else as NDJSON with one order per
+// line; blank lines are skipped.
+type Reader struct {
+	br      *bufio.Reader
+	started bool
+	line    int
+
+	// Set for a JSON array.
+	dec   *json.Decoder
+	lines *lineCounter
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r), line: 1}
+}
+
+// Next returns the next record, or io.EOF after the last one. A record of
+// NDJSON is returned as is, even if it is not valid JSON; a malformed JSON
+// array cannot be read past and fails the reader.
+func (r *Reader) Next() (Record, error) {
+	if !r.started {
+		r.started = true
+		if err := r.start(); err != nil {
+			return Record{}, err
+		}
+	}
+	if r.dec != nil {
+		return r.nextElement()
+	}
+	return r.nextLine()
+}
+
+// start skips the leading whitespace and picks the format by the first
+// byte after it.
+func (r *Reader) start() error {
+	for {
+		c, err := r.br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch c {
+		case '\n':
+			r.line++
+		case ' ', '\t', '\r':
+		case '[':
+			// The '[' is fed back to the decoder, so its offsets start one
+			// byte before those of the rest of the input.
+			r.lines = &lineCounter{r: r.br, offset: 1, line: r.line}
+			r.dec = json.NewDecoder(io.MultiReader(bytes.NewReader([]byte{c}), r.lines))
+			if _, err := r.dec.Token(); err != nil {
+				return err
+			}
+			return nil
+		default:
+			return r.br.UnreadByte()
+		}
+	}
+}
+
+func (r *Reader) nextLine() (Record, error) {
+	for {
+		b, err := r.br.ReadBytes('\n')
+		if len(b) == 0 && err != nil {
+			return Record{}, err
+		}
+		line := r.line
+		r.line++
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			return Record{Line: line, Data: b}, nil
+		}
+		if err != nil {
+			return Record{}, err
+		}
+	}
+}
+
+func (r *Reader) nextElement() (Record, error) {
+	if !r.dec.More() {
+		if _, err := r.dec.Token(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Record{}, fmt.Errorf("after line %d: %w", r.lines.line, err)
+		}
+		if _, err := r.dec.Token(); err != io.EOF {
+			return Record{}, fmt.Errorf("after line %d: data after the end of the array", r.lines.line)
+		}
+		return Record{}, io.EOF
+	}
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, fmt.Errorf("after line %d: %w", r.lines.line, err)
+	}
+	start := r.dec.InputOffset() - int64(len(raw))
+	return Record{Line: r.lines.lineAt(start), Data: raw}, nil
+}
+
+// lineCounter remembers where the newlines passing through it are, so that
+// the line of an offset the decoder has already read past can be told.
+type lineCounter struct {
+	r        io.Reader
+	offset   int64
+	newlines []int64
+	line     int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.offset+int64(i)+1)
+		}
+	}
+	c.offset += int64(n)
+	return n, err
+}
+
+// lineAt returns the line of offset, which must not be less than the
+// offsets asked before.
+func (c *lineCounter) lineAt(offset int64) int {
+	for len(c.newlines) > 0 && c.newlines[0] <= offset {
+		c.newlines = c.newlines[1:]
+		c.line++
+	}
+	return c.line
+}
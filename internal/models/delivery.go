@@ -1,7 +1,7 @@
 package models
 
 type Delivery struct {
-	OrderRefer string `json:"-" gorm:"type:varchar(19);index"`
+	OrderRefer string `json:"-" gorm:"type:varchar(19);unique_index"`
 	Name       string `json:"name"    validate:"required,max=30"`
 	Phone      string `json:"phone"   validate:"required"`
 	Zip        string `json:"zip"     validate:"required,max=10"`
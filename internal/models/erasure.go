@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ErasureReceipt records the erasure of the personal data of a customer:
+// the orders it covered and how many stored records were rewritten.
+type ErasureReceipt struct {
+	ID         int64     `json:"id"`
+	CustomerId string    `json:"customer_id"`
+	OrderUids  []string  `json:"order_uids"`
+	Deliveries int       `json:"deliveries"`
+	Revisions  int       `json:"revisions"`
+	Messages   int       `json:"messages"`
+	ErasedAt   time.Time `json:"erased_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+const OrderStoredEvent = "order.stored"
+
+type OrderEvent struct {
+	ID       int64     `json:"-"`
+	Type     string    `json:"type"`
+	OrderUid string    `json:"order_uid"`
+	Version  int64     `json:"version"`
+	StoredAt time.Time `json:"stored_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Outcomes of processing a consumed message.
+const (
+	MessageProcessed  = "ok"
+	MessageStale      = "stale"
+	MessageInvalid    = "validation_error"
+	MessageDeadLetter = "dlq"
+)
+
+// RawMessage is a consumed Kafka message as it was received, together with
+// the outcome of processing it.
+type RawMessage struct {
+	ID         int64             `json:"id"`
+	OrderUid   string            `json:"order_uid,omitempty"`
+	Topic      string            `json:"topic"`
+	Partition  int               `json:"partition"`
+	Offset     int64             `json:"offset"`
+	Key        string            `json:"key,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Payload    string            `json:"payload"`
+	ReceivedAt time.Time         `json:"received_at"`
+	Outcome    string            `json:"outcome" enums:"ok,stale,validation_error,dlq"`
+	Error      string            `json:"error,omitempty"`
+}
@@ -1,7 +1,7 @@
 package models
 
 type Payment struct {
-	OrderRefer   string `json:"-" gorm:"type:varchar(19);index"`
+	OrderRefer   string `json:"-" gorm:"type:varchar(19);unique_index"`
 	Transaction  string `json:"transaction"   validate:"required"`
 	RequestId    string `json:"request_id"`
 	Currency     string `json:"currency"      validate:"required"`
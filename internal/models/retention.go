@@ -0,0 +1,12 @@
+package models
+
+type DroppedPartition struct {
+	Name    string `json:"name"`
+	Orders  int    `json:"orders"`
+	Archive string `json:"archive,omitempty"`
+}
+
+type RetentionReport struct {
+	Partitions    []DroppedPartition `json:"partitions"`
+	EvictedOrders int                `json:"evicted_orders"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type OrderRevision struct {
+	ID        uint            `json:"id"`
+	OrderUid  string          `json:"order_uid"`
+	Version   int64           `json:"version"`
+	Diff      json.RawMessage `json:"diff" swaggertype:"object"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+type SearchHit struct {
+	Rank  float64 `json:"rank"`
+	Order Order   `json:"order"`
+}
+
+type SearchResult struct {
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+	Hits   []SearchHit `json:"hits"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+const (
+	GroupByNone            = "none"
+	GroupByDay             = "day"
+	GroupByWeek            = "week"
+	GroupByMonth           = "month"
+	GroupByDeliveryService = "delivery_service"
+	GroupByProvider        = "provider"
+	GroupByBank            = "bank"
+)
+
+// StatsFilter selects orders by date_created in [From, To). Amounts are never
+// summed across currencies: every row is reported per currency.
+type StatsFilter struct {
+	From     time.Time
+	To       time.Time
+	Currency string
+	GroupBy  string
+	Limit    int
+}
+
+type RevenueRow struct {
+	Group        string `json:"group"`
+	Currency     string `json:"currency"`
+	Orders       int    `json:"orders"`
+	Revenue      int64  `json:"revenue"`
+	GoodsTotal   int64  `json:"goods_total"`
+	DeliveryCost int64  `json:"delivery_cost"`
+}
+
+type BrandRow struct {
+	Brand    string `json:"brand"`
+	Currency string `json:"currency"`
+	Items    int    `json:"items"`
+	Orders   int    `json:"orders"`
+	Revenue  int64  `json:"revenue"`
+}
+
+type BasketRow struct {
+	Group     string  `json:"group"`
+	Currency  string  `json:"currency"`
+	Orders    int     `json:"orders"`
+	AvgItems  float64 `json:"avg_items"`
+	AvgAmount float64 `json:"avg_amount"`
+}
@@ -1,6 +1,7 @@
 package cache_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -15,20 +16,20 @@ import (
 func TestOrderCache_PutGet_All(t *testing.T) {
 	cch := cache.NewOrderCache(cache.NewCache())
 
-	_, err := cch.GetOrder("nope")
+	_, err := cch.GetOrder(context.Background(), "nope")
 	require.Error(t, err)
 	if eh, ok := err.(cache.ErrorHandler); ok {
 		require.Equal(t, http.StatusNotFound, eh.StatusCode)
 	}
 
 	in := models.Order{OrderUid: "u1", CustomerId: "cust"}
-	cch.PutOrder(in.OrderUid, in)
+	cch.PutOrder(context.Background(), in.OrderUid, in)
 
-	got, err := cch.GetOrder("u1")
+	got, err := cch.GetOrder(context.Background(), "u1")
 	require.NoError(t, err)
 	require.Equal(t, "u1", got.OrderUid)
 
-	all, err := cch.GetAllOrders()
+	all, err := cch.GetAllOrders(context.Background())
 	require.NoError(t, err)
 	require.Len(t, all, 1)
 	require.Equal(t, "u1", all[0].OrderUid)
@@ -46,7 +47,7 @@ func TestOrderCache_GetOrder_ConvertError_500(t *testing.T) {
 
 	cch := cache.NewOrderCache(base)
 
-	_, err := cch.GetOrder("bad")
+	_, err := cch.GetOrder(context.Background(), "bad")
 	require.Error(t, err)
 	eh, ok := err.(cache.ErrorHandler)
 	require.True(t, ok, "err should be cache.ErrorHandler")
@@ -57,7 +58,7 @@ func TestOrderCache_GetOrder_ConvertError_500(t *testing.T) {
 func TestOrderCache_GetAll_Empty_OK(t *testing.T) {
 	cch := cache.NewOrderCache(cache.NewCache())
 
-	out, err := cch.GetAllOrders()
+	out, err := cch.GetAllOrders(context.Background())
 	require.NoError(t, err)
 	require.Len(t, out, 0)
 }
@@ -69,7 +70,7 @@ func TestOrderCache_GetAll_ConvertError_500(t *testing.T) {
 
 	cch := cache.NewOrderCache(base)
 
-	out, err := cch.GetAllOrders()
+	out, err := cch.GetAllOrders(context.Background())
 	require.Nil(t, out)
 	eh, ok := err.(cache.ErrorHandler)
 	require.True(t, ok)
@@ -82,17 +83,27 @@ func TestOrderCache_Delete_RemovesKey(t *testing.T) {
 	cch := cache.NewOrderCache(base)
 
 	o := models.Order{OrderUid: "to_del"}
-	cch.PutOrder(o.OrderUid, o)
+	cch.PutOrder(context.Background(), o.OrderUid, o)
 
 	cch.Delete("to_del")
 
-	_, err := cch.GetOrder("to_del")
+	_, err := cch.GetOrder(context.Background(), "to_del")
 	require.Error(t, err)
 	eh, ok := err.(cache.ErrorHandler)
 	require.True(t, ok)
 	require.Equal(t, http.StatusNotFound, eh.StatusCode)
 }
 
+func TestOrderCache_DeleteOrder_RemovesKey(t *testing.T) {
+	cch := cache.NewOrderCache(cache.NewCache())
+
+	cch.PutOrder(context.Background(), "to_del", models.Order{OrderUid: "to_del"})
+	cch.DeleteOrder(context.Background(), "to_del")
+
+	_, err := cch.GetOrder(context.Background(), "to_del")
+	require.Error(t, err)
+}
+
 func TestCache_WithTTL_JanitorAndClose(t *testing.T) {
 	ttl := 30 * time.Millisecond
 	c := cache.NewCache(cache.WithTTL(ttl))
@@ -171,3 +182,16 @@ func TestCache_Snapshot_Mixed_WrappedAndRaw(t *testing.T) {
 	require.Equal(t, 123, snap["wrapped"])
 	require.Equal(t, "plain", snap["raw"])
 }
+
+func TestOrderCache_CancelledContext(t *testing.T) {
+	cch := cache.NewOrderCache(cache.NewCache())
+	cch.PutOrder(context.Background(), "u1", models.Order{OrderUid: "u1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cch.GetOrder(ctx, "u1")
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = cch.GetAllOrders(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 
 	"l0-demo/internal/models"
@@ -16,11 +17,14 @@ func NewOrderCache(cch KV) *OrderCacheRepo {
 	return &OrderCacheRepo{cch: cch}
 }
 
-func (o *OrderCacheRepo) PutOrder(uid string, ord models.Order) {
+func (o *OrderCacheRepo) PutOrder(_ context.Context, uid string, ord models.Order) {
 	o.cch.Put(uid, ord)
 }
 
-func (o *OrderCacheRepo) GetOrder(uid string) (models.Order, error) {
+func (o *OrderCacheRepo) GetOrder(ctx context.Context, uid string) (models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Order{}, err
+	}
 	v, ok := o.cch.Get(uid)
 	if !ok {
 		return models.Order{}, NewErrorHandler(fmt.Errorf("order %s not found", uid), http.StatusNotFound)
@@ -35,7 +39,10 @@ func (o *OrderCacheRepo) GetOrder(uid string) (models.Order, error) {
 	return ord, nil
 }
 
-func (o *OrderCacheRepo) GetAllOrders() ([]models.Order, error) {
+func (o *OrderCacheRepo) GetAllOrders(ctx context.Context) ([]models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	snap := o.cch.Snapshot()
 	if len(snap) == 0 {
 		return []models.Order{}, nil
@@ -57,3 +64,7 @@ func (o *OrderCacheRepo) GetAllOrders() ([]models.Order, error) {
 func (o *OrderCacheRepo) Delete(uid string) {
 	o.cch.Delete(uid)
 }
+
+func (o *OrderCacheRepo) DeleteOrder(_ context.Context, uid string) {
+	o.Delete(uid)
+}
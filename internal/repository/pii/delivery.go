@@ -0,0 +1,124 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"l0-demo/internal/models"
+)
+
+// Columns are the delivery columns that hold personal data, as named in the
+// deliveries table and in the JSON of an order.
+var Columns = []string{"name", "phone", "address", "email"}
+
+func field(d *models.Delivery, column string) *string {
+	switch column {
+	case "name":
+		return &d.Name
+	case "phone":
+		return &d.Phone
+	case "address":
+		return &d.Address
+	case "email":
+		return &d.Email
+	}
+	return nil
+}
+
+// FieldAAD binds a sealed delivery column to its order.
+func FieldAAD(column, uid string) string {
+	return "deliveries." + column + ":" + uid
+}
+
+// SealDelivery encrypts the personal data of d in place. d.OrderRefer must
+// be set. Without a keyring it leaves d as it is.
+func (k *Keyring) SealDelivery(d *models.Delivery) error {
+	if k == nil || d == nil {
+		return nil
+	}
+	for _, col := range Columns {
+		v := field(d, col)
+		if Sealed(*v) {
+			continue
+		}
+		sealed, err := k.Seal([]byte(*v), FieldAAD(col, d.OrderRefer))
+		if err != nil {
+			return err
+		}
+		*v = sealed
+	}
+	return nil
+}
+
+// OpenDelivery decrypts the personal data of d in place. Plaintext values,
+// stored before encryption was turned on, are left as they are.
+func (k *Keyring) OpenDelivery(d *models.Delivery) error {
+	if d == nil {
+		return nil
+	}
+	for _, col := range Columns {
+		v := field(d, col)
+		if !Sealed(*v) {
+			continue
+		}
+		plain, err := k.Open(*v, FieldAAD(col, d.OrderRefer))
+		if err != nil {
+			return err
+		}
+		*v = string(plain)
+	}
+	return nil
+}
+
+// CurrentDelivery reports whether every personal field of d is stored the
+// way the keyring would store it now.
+func (k *Keyring) CurrentDelivery(d *models.Delivery) bool {
+	for _, col := range Columns {
+		if !k.Current(*field(d, col)) {
+			return false
+		}
+	}
+	return true
+}
+
+// EmailIndex and PhoneIndex return the blind indexes of a contact: keyed
+// hashes of the normalized value that allow lookups by equality without
+// storing the value in clear. Without a keyring, and for erased data, they
+// return "".
+func (k *Keyring) EmailIndex(email string) string {
+	if email == ErasedEmail {
+		return ""
+	}
+	return k.blindIndex("email", NormalizeEmail(email))
+}
+
+func (k *Keyring) PhoneIndex(phone string) string {
+	return k.blindIndex("phone", NormalizePhone(phone))
+}
+
+func (k *Keyring) blindIndex(kind, v string) string {
+	if k == nil || v == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(kind + ":" + v))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NormalizeEmail lowercases an address and trims the spaces around it.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone keeps only the digits of a number, so "+7 (900) 123-45-67"
+// and "79001234567" are the same phone.
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
@@ -0,0 +1,129 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Prefix starts every sealed value, which reads
+//
+//	pii1:<key id>:<wrapped data key>:<ciphertext>
+//
+// with both binary parts in unpadded base64. Anything else is plaintext.
+const Prefix = "pii1:"
+
+var b64 = base64.RawStdEncoding
+
+// Sealed reports whether v was produced by Seal.
+func Sealed(v string) bool {
+	return strings.HasPrefix(v, Prefix)
+}
+
+// KeyID returns the id of the key that sealed v, or "" for plaintext.
+func KeyID(v string) string {
+	if !Sealed(v) {
+		return ""
+	}
+	id, _, _ := strings.Cut(v[len(Prefix):], ":")
+	return id
+}
+
+// Current reports whether v needs no re-encryption: it is sealed with the
+// primary key, or it is plaintext and there is no keyring to seal it with.
+func (k *Keyring) Current(v string) bool {
+	if k == nil {
+		return !Sealed(v)
+	}
+	return KeyID(v) == k.primary
+}
+
+// Seal encrypts plain under a fresh data key wrapped with the primary key.
+// aad binds the value to where it is stored, e.g. its column and row, so a
+// sealed value copied elsewhere fails to open.
+func (k *Keyring) Seal(plain []byte, aad string) (string, error) {
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := encrypt(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	ct, err := encrypt(dek, plain, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + k.primary + ":" + b64.EncodeToString(wrapped) + ":" + b64.EncodeToString(ct), nil
+}
+
+// Open decrypts a value produced by Seal with the same aad.
+func (k *Keyring) Open(v, aad string) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	parts := strings.Split(strings.TrimPrefix(v, Prefix), ":")
+	if !Sealed(v) || len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed envelope", ErrDecrypt)
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	ct, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+
+	dek, err := decrypt(kek, wrapped, []byte(parts[0]))
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(dek, ct, []byte(aad))
+}
+
+// encrypt returns nonce || AES-GCM ciphertext.
+func encrypt(key, plain, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, aad), nil
+}
+
+func decrypt(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecrypt)
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,75 @@
+package pii
+
+import (
+	"encoding/json"
+
+	"l0-demo/internal/models"
+)
+
+// Placeholders of erased personal data. They still pass the validation of
+// the models, so an erased order stays readable and cacheable.
+const (
+	ErasedValue = "erased"
+	ErasedEmail = "erased@erased.invalid"
+)
+
+// Erased returns the placeholder of an erased column.
+func Erased(column string) string {
+	if column == "email" {
+		return ErasedEmail
+	}
+	return ErasedValue
+}
+
+// EraseDelivery replaces the personal data of d with placeholders.
+func EraseDelivery(d *models.Delivery) {
+	for _, col := range Columns {
+		*field(d, col) = Erased(col)
+	}
+}
+
+// DeliveryErased reports whether the personal data of an opened delivery
+// has been erased.
+func DeliveryErased(d *models.Delivery) bool {
+	for _, col := range Columns {
+		if *field(d, col) != Erased(col) {
+			return false
+		}
+	}
+	return true
+}
+
+// ErasePayload returns an order payload with the personal data of its
+// delivery erased and everything else kept. A payload that is not a JSON
+// object cannot be told apart, so it is erased as a whole.
+func ErasePayload(payload []byte) []byte {
+	var o map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &o); err != nil || o == nil {
+		return []byte(`{}`)
+	}
+	raw, ok := o["delivery"]
+	if !ok {
+		return payload
+	}
+	var d map[string]any
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return []byte(`{}`)
+	}
+	if d == nil {
+		return payload
+	}
+	for _, col := range Columns {
+		if _, ok := d[col]; ok {
+			d[col] = Erased(col)
+		}
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return []byte(`{}`)
+	}
+	o["delivery"] = b
+	if payload, err = json.Marshal(o); err != nil {
+		return []byte(`{}`)
+	}
+	return payload
+}
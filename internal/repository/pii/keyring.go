@@ -0,0 +1,110 @@
+// Package pii encrypts the personal data of orders before it is stored.
+//
+// Every value is sealed on its own with envelope encryption: a fresh data
+// key encrypts the value, and a key from the keyring, the key encryption
+// key, wraps the data key. The sealed value records the id of that key, so
+// keys can be rotated by adding a new primary key to the keyring and
+// re-encrypting; values sealed with older keys stay readable meanwhile.
+package pii
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("pii: unknown key id")
+	ErrNoKeyring  = errors.New("pii: value is encrypted but no keyring is configured")
+	ErrDecrypt    = errors.New("pii: cannot decrypt value")
+)
+
+// Keyring holds the key encryption keys by id, the id of the primary key
+// that seals new values, and the key of the blind indexes.
+//
+// A nil *Keyring is valid: it stores values as they are and fails to open
+// values that are encrypted.
+type Keyring struct {
+	primary  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// keyringFile is the JSON layout of a keyring file. Keys are base64-encoded
+// 32-byte AES keys.
+//
+//	{
+//	  "primary": "2026-10",
+//	  "keys": {"2026-04": "...", "2026-10": "..."},
+//	  "index_key": "..."
+//	}
+type keyringFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %w", err)
+	}
+	k, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode keyring: %w", err)
+	}
+
+	k := &Keyring{primary: f.Primary, keys: make(map[string][]byte, len(f.Keys))}
+	for id, enc := range f.Keys {
+		if id == "" || strings.ContainsAny(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		key, err := decodeKey(enc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[k.primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", k.primary)
+	}
+
+	key, err := decodeKey(f.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index_key: %w", err)
+	}
+	k.indexKey = key
+	return k, nil
+}
+
+func decodeKey(enc string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("want %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// Primary returns the id of the key that seals new values.
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
@@ -0,0 +1,198 @@
+package pii_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func keyring(t *testing.T, primary string, ids ...string) *pii.Keyring {
+	t.Helper()
+	keys := make([]string, 0, len(ids))
+	for i, id := range ids {
+		keys = append(keys, fmt.Sprintf("%q: %q", id, key(byte('a'+i))))
+	}
+	k, err := pii.ParseKeyring([]byte(fmt.Sprintf(`{"primary": %q, "keys": {%s}, "index_key": %q}`,
+		primary, strings.Join(keys, ", "), key('z'))))
+	require.NoError(t, err)
+	return k
+}
+
+func delivery() models.Delivery {
+	return models.Delivery{
+		OrderRefer: "b563feb7b2b84b6test",
+		Name:       "Test Testov",
+		Phone:      "+9720000000",
+		Zip:        "2639809",
+		City:       "Kiryat Mozkin",
+		Address:    "Ploshad Mira 15",
+		Region:     "Kraiot",
+		Email:      "test@gmail.com",
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"primary": "k1", "keys": {"k1": "`+key('a')+`"}, "index_key": "`+key('z')+`"}`), 0o600))
+
+	k, err := pii.LoadKeyring(path)
+	require.NoError(t, err)
+	require.Equal(t, "k1", k.Primary())
+
+	_, err = pii.LoadKeyring(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
+
+func TestParseKeyring_Rejects(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":        `keys`,
+		"missing primary": `{"primary": "k2", "keys": {"k1": "` + key('a') + `"}, "index_key": "` + key('z') + `"}`,
+		"short key":       `{"primary": "k1", "keys": {"k1": "c2hvcnQ="}, "index_key": "` + key('z') + `"}`,
+		"bad base64":      `{"primary": "k1", "keys": {"k1": "***"}, "index_key": "` + key('z') + `"}`,
+		"colon in id":     `{"primary": "k:1", "keys": {"k:1": "` + key('a') + `"}, "index_key": "` + key('z') + `"}`,
+		"no index key":    `{"primary": "k1", "keys": {"k1": "` + key('a') + `"}}`,
+	} {
+		_, err := pii.ParseKeyring([]byte(data))
+		require.Error(t, err, name)
+	}
+}
+
+func TestSealOpen_RoundTripAndBinding(t *testing.T) {
+	k := keyring(t, "k1", "k1")
+
+	a, err := k.Seal([]byte("secret"), "deliveries.name:u1")
+	require.NoError(t, err)
+	b, err := k.Seal([]byte("secret"), "deliveries.name:u1")
+	require.NoError(t, err)
+	require.True(t, pii.Sealed(a))
+	require.Equal(t, "k1", pii.KeyID(a))
+	require.NotEqual(t, a, b, "every value gets its own data key and nonce")
+	require.NotContains(t, a, "secret")
+
+	plain, err := k.Open(a, "deliveries.name:u1")
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(plain))
+
+	_, err = k.Open(a, "deliveries.name:u2")
+	require.ErrorIs(t, err, pii.ErrDecrypt, "a value moved to another row must not open")
+
+	tampered := a[:len(a)-2] + "AA"
+	_, err = k.Open(tampered, "deliveries.name:u1")
+	require.ErrorIs(t, err, pii.ErrDecrypt)
+
+	_, err = k.Open("pii1:k1:only-two", "deliveries.name:u1")
+	require.ErrorIs(t, err, pii.ErrDecrypt)
+}
+
+func TestRotation(t *testing.T) {
+	old := keyring(t, "k1", "k1")
+	sealed, err := old.Seal([]byte("secret"), "aad")
+	require.NoError(t, err)
+
+	rotated := keyring(t, "k2", "k1", "k2")
+	require.False(t, rotated.Current(sealed))
+	plain, err := rotated.Open(sealed, "aad")
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(plain))
+
+	resealed, err := rotated.Seal(plain, "aad")
+	require.NoError(t, err)
+	require.Equal(t, "k2", pii.KeyID(resealed))
+	require.True(t, rotated.Current(resealed))
+
+	retired := keyring(t, "k2", "k2")
+	_, err = retired.Open(sealed, "aad")
+	require.ErrorIs(t, err, pii.ErrUnknownKey)
+}
+
+func TestDelivery_SealAndOpen(t *testing.T) {
+	k := keyring(t, "k1", "k1")
+	d := delivery()
+
+	require.False(t, k.CurrentDelivery(&d))
+	require.NoError(t, k.SealDelivery(&d))
+	require.True(t, k.CurrentDelivery(&d))
+	for _, v := range []string{d.Name, d.Phone, d.Address, d.Email} {
+		require.True(t, pii.Sealed(v))
+	}
+	require.Equal(t, delivery().City, d.City, "only personal data is encrypted")
+
+	sealed := d
+	require.NoError(t, k.SealDelivery(&d), "sealing twice is a no-op")
+	require.Equal(t, sealed, d)
+
+	require.NoError(t, k.OpenDelivery(&d))
+	require.Equal(t, delivery(), d)
+
+	moved := sealed
+	moved.OrderRefer = "another-order-uid00"
+	require.ErrorIs(t, k.OpenDelivery(&moved), pii.ErrDecrypt)
+}
+
+func TestDelivery_NilKeyring(t *testing.T) {
+	var k *pii.Keyring
+	d := delivery()
+
+	require.NoError(t, k.SealDelivery(&d))
+	require.Equal(t, delivery(), d)
+	require.True(t, k.CurrentDelivery(&d))
+	require.NoError(t, k.OpenDelivery(&d), "plaintext opens without a keyring")
+	require.Empty(t, k.EmailIndex(d.Email))
+
+	require.NoError(t, keyring(t, "k1", "k1").SealDelivery(&d))
+	require.False(t, k.CurrentDelivery(&d))
+	require.ErrorIs(t, k.OpenDelivery(&d), pii.ErrNoKeyring)
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := keyring(t, "k1", "k1")
+
+	require.Equal(t, k.EmailIndex("test@gmail.com"), k.EmailIndex("  Test@GMAIL.com "))
+	require.Equal(t, k.PhoneIndex("+7 (900) 123-45-67"), k.PhoneIndex("79001234567"))
+	require.NotEqual(t, k.EmailIndex("a@b.c"), k.EmailIndex("a@b.d"))
+	require.NotEqual(t, k.EmailIndex("79001234567"), k.PhoneIndex("79001234567"), "kinds do not collide")
+	require.Len(t, k.EmailIndex("a@b.c"), 64)
+	require.Empty(t, k.PhoneIndex("n/a"))
+
+	other, err := pii.ParseKeyring([]byte(`{"primary": "k1", "keys": {"k1": "` + key('a') + `"}, "index_key": "` + key('y') + `"}`))
+	require.NoError(t, err)
+	require.NotEqual(t, k.EmailIndex("a@b.c"), other.EmailIndex("a@b.c"))
+}
+
+func TestEraseDelivery(t *testing.T) {
+	d := delivery()
+	require.False(t, pii.DeliveryErased(&d))
+	pii.EraseDelivery(&d)
+	require.True(t, pii.DeliveryErased(&d))
+	require.Equal(t, pii.ErasedEmail, d.Email)
+	require.Equal(t, delivery().City, d.City, "only personal data is erased")
+	require.NoError(t, validator.New().Struct(d), "erased deliveries stay valid")
+
+	k := keyring(t, "k1", "k1")
+	require.Empty(t, k.EmailIndex(d.Email))
+	require.Empty(t, k.PhoneIndex(d.Phone))
+}
+
+func TestErasePayload(t *testing.T) {
+	payload := []byte(`{"order_uid":"b563feb7b2b84b6test","delivery":{"name":"Test Testov","phone":"+9720000000","city":"Kiryat Mozkin","email":"test@gmail.com"},"payment":{"amount":1817}}`)
+	erased := pii.ErasePayload(payload)
+	require.JSONEq(t, `{"order_uid":"b563feb7b2b84b6test","delivery":{"name":"erased","phone":"erased","city":"Kiryat Mozkin","email":"erased@erased.invalid"},"payment":{"amount":1817}}`, string(erased))
+	require.Equal(t, erased, pii.ErasePayload(erased), "erasing twice changes nothing")
+
+	require.Equal(t, `{"order_uid":"x"}`, string(pii.ErasePayload([]byte(`{"order_uid":"x"}`))))
+	require.Equal(t, `{}`, string(pii.ErasePayload([]byte(`not json, Test Testov`))))
+	require.Equal(t, `{}`, string(pii.ErasePayload([]byte(`{"delivery":"Test Testov"}`))))
+}
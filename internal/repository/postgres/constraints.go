@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+
+	"l0-demo/internal/repository/storage"
+)
+
+type constraint struct {
+	table string
+	name  string
+	def   string
+}
+
+// notNullColumns and checkConstraints mirror the validate tags of the models,
+// so rows written around the service are held to the same rules.
+var notNullColumns = map[string][]string{
+	"orders": {
+		"track_number", "entry", "locale", "customer_id", "delivery_service",
+		"sm_id", "date_created", "oof_shard", "version",
+	},
+	"deliveries": {"order_refer", "name", "phone", "zip", "city", "address", "region", "email"},
+	"payments": {
+		"order_refer", "transaction", "currency", "provider", "amount",
+		"payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
+	},
+	"items": {
+		"order_refer", "chrt_id", "track_number", "price", "rid", "name",
+		"sale", "size", "total_price", "nm_id", "brand", "status",
+	},
+}
+
+var checkConstraints = []constraint{
+	{"orders", "chk_orders_order_uid_len", `CHECK (char_length(order_uid) = 19)`},
+	{"orders", "chk_orders_track_number_len", `CHECK (char_length(track_number) = 14)`},
+	{"orders", "chk_orders_entry_len", `CHECK (char_length(entry) = 4)`},
+	{"orders", "chk_orders_locale", `CHECK (locale IN ('ru', 'en'))`},
+	{"orders", "chk_orders_customer_id_len", `CHECK (char_length(customer_id) = 4)`},
+	{"orders", "chk_orders_delivery_service_len", `CHECK (char_length(delivery_service) = 5)`},
+	{"orders", "chk_orders_sm_id_range", `CHECK (sm_id BETWEEN 0 AND 100)`},
+	{"orders", "chk_orders_oof_shard_len", `CHECK (char_length(oof_shard) BETWEEN 1 AND 2)`},
+	{"orders", "chk_orders_version", `CHECK (version >= 0)`},
+
+	// Name, address and email may hold ciphertext, so only the service can
+	// check their length.
+	{"deliveries", "chk_deliveries_name", `CHECK (name <> '')`},
+	{"deliveries", "chk_deliveries_phone", `CHECK (phone <> '')`},
+	{"deliveries", "chk_deliveries_zip_len", `CHECK (char_length(zip) BETWEEN 1 AND 10)`},
+	{"deliveries", "chk_deliveries_city_len", `CHECK (char_length(city) BETWEEN 1 AND 30)`},
+	{"deliveries", "chk_deliveries_address", `CHECK (address <> '')`},
+	{"deliveries", "chk_deliveries_region_len", `CHECK (char_length(region) BETWEEN 1 AND 30)`},
+	{"deliveries", "chk_deliveries_email", `CHECK (email <> '')`},
+
+	{"payments", "chk_payments_transaction", `CHECK ("transaction" <> '')`},
+	{"payments", "chk_payments_currency", `CHECK (currency <> '')`},
+	{"payments", "chk_payments_provider", `CHECK (provider <> '')`},
+	{"payments", "chk_payments_amount", `CHECK (amount > 0)`},
+	{"payments", "chk_payments_payment_dt", `CHECK (payment_dt <> 0)`},
+	{"payments", "chk_payments_bank", `CHECK (bank <> '')`},
+	{"payments", "chk_payments_delivery_cost", `CHECK (delivery_cost > 0)`},
+	{"payments", "chk_payments_goods_total", `CHECK (goods_total > 0)`},
+	{"payments", "chk_payments_custom_fee", `CHECK (custom_fee >= 0)`},
+
+	{"items", "chk_items_chrt_id", `CHECK (chrt_id <> 0)`},
+	{"items", "chk_items_track_number_len", `CHECK (char_length(track_number) = 14)`},
+	{"items", "chk_items_price", `CHECK (price > 0)`},
+	{"items", "chk_items_rid_len", `CHECK (char_length(rid) = 21)`},
+	{"items", "chk_items_name", `CHECK (name <> '')`},
+	{"items", "chk_items_sale", `CHECK (sale > 0)`},
+	{"items", "chk_items_size", `CHECK (size <> '')`},
+	{"items", "chk_items_total_price", `CHECK (total_price > 0)`},
+	{"items", "chk_items_nm_id", `CHECK (nm_id <> 0)`},
+	{"items", "chk_items_brand", `CHECK (brand <> '')`},
+	{"items", "chk_items_status_range", `CHECK (status BETWEEN 0 AND 999)`},
+}
+
+// retiredConstraints were created by earlier versions and are dropped.
+var retiredConstraints = []constraint{
+	{table: "deliveries", name: "chk_deliveries_name_len"},
+	{table: "deliveries", name: "chk_deliveries_address_len"},
+	{table: "deliveries", name: "chk_deliveries_email_len"},
+}
+
+// foreignKeys can only be created while orders is not partitioned: a
+// partitioned orders table has no unique key on order_uid alone to reference.
+var foreignKeys = []constraint{
+	{"deliveries", "fk_deliveries_order", `FOREIGN KEY (order_refer) REFERENCES orders (order_uid) ON DELETE CASCADE`},
+	{"payments", "fk_payments_order", `FOREIGN KEY (order_refer) REFERENCES orders (order_uid) ON DELETE CASCADE`},
+	{"items", "fk_items_order", `FOREIGN KEY (order_refer) REFERENCES orders (order_uid) ON DELETE CASCADE`},
+}
+
+// migrateConstraints adds missing constraints as NOT VALID, so adding them
+// never scans a table under an exclusive lock, and then validates them.
+// Children left without an order are deleted before the foreign keys are
+// validated, as the cascade would have done. A check that rows stored before
+// it existed still violate is logged and left NOT VALID: it holds for every
+// new write, and validation is retried on the next start. The checks of the
+// unchecked columns are dropped instead.
+func migrateConstraints(db *gorm.DB, unchecked map[string][]string) error {
+	for _, table := range []string{"orders", "deliveries", "payments", "items"} {
+		stmt := `ALTER TABLE ` + table
+		for i, col := range notNullColumns[table] {
+			if i > 0 {
+				stmt += `,`
+			}
+			stmt += ` ALTER COLUMN "` + col + `" SET NOT NULL`
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	var all, dropped []constraint
+	for _, c := range checkConstraints {
+		if slices.Contains(unchecked[c.table], checkColumn(c.table, c.name)) {
+			dropped = append(dropped, c)
+			continue
+		}
+		all = append(all, c)
+	}
+	if len(dropped) > 0 {
+		names := make([]string, len(dropped))
+		for i, c := range dropped {
+			names[i] = c.name
+		}
+		logrus.WithField("constraints", names).Warn("checks left out, validation profiles replace the rules of their columns")
+	}
+
+	for _, c := range append(retiredConstraints[:len(retiredConstraints):len(retiredConstraints)], dropped...) {
+		if err := db.Exec(`ALTER TABLE ` + c.table + ` DROP CONSTRAINT IF EXISTS ` + c.name).Error; err != nil {
+			return err
+		}
+	}
+
+	var kind string
+	if err := db.Raw(ordersRelkindSQL).Row().Scan(&kind); err != nil {
+		return err
+	}
+	if kind == "p" {
+		logrus.Error("orders is partitioned: deliveries, payments and items have no foreign key to their order, " +
+			"so only the repository keeps them consistent with it")
+	} else {
+		all = append(all[:len(all):len(all)], foreignKeys...)
+	}
+
+	for _, c := range all {
+		var exists bool
+		if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = ? AND conrelid = ?::regclass)`,
+			c.name, c.table).Row().Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := db.Exec(`ALTER TABLE ` + c.table + ` ADD CONSTRAINT ` + c.name + ` ` + c.def + ` NOT VALID`).Error; err != nil {
+			return err
+		}
+	}
+
+	if kind != "p" {
+		for _, fk := range foreignKeys {
+			if err := db.Exec(`DELETE FROM ` + fk.table + ` c
+				WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = c.order_refer)`).Error; err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range all {
+		if err := validateConstraint(db, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateConstraint validates c unless it already is. Rows that violate a
+// check are reported instead of failing the migration.
+func validateConstraint(db *gorm.DB, c constraint) error {
+	var validated bool
+	if err := db.Raw(`SELECT convalidated FROM pg_constraint WHERE conname = ? AND conrelid = ?::regclass`,
+		c.name, c.table).Row().Scan(&validated); err != nil {
+		return err
+	}
+	if validated {
+		return nil
+	}
+	err := mapError(db.Exec(`ALTER TABLE ` + c.table + ` VALIDATE CONSTRAINT ` + c.name).Error)
+	if errors.Is(err, storage.ErrCheck) {
+		logrus.WithError(err).WithField("constraint", c.name).
+			Error("stored rows violate the constraint; it holds for new writes only until they are fixed")
+		return nil
+	}
+	return err
+}
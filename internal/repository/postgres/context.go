@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Timeouts bound single repository calls on top of the caller's context.
+// Zero leaves a call bounded by the caller only.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func WithTimeouts(t Timeouts) Option {
+	return func(r *OrderPostgresRepo) { r.timeouts = t }
+}
+
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ctxConn lets gorm, which knows nothing about contexts, run its statements
+// through the context-aware database/sql calls, so that a cancelled or
+// expired ctx aborts the query on the server.
+type ctxConn struct {
+	ctx  context.Context
+	exec executor
+}
+
+func (c ctxConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.exec.ExecContext(c.ctx, query, args...)
+}
+
+func (c ctxConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.exec.PrepareContext(c.ctx, query)
+}
+
+func (c ctxConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.exec.QueryContext(c.ctx, query, args...)
+}
+
+func (c ctxConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.exec.QueryRowContext(c.ctx, query, args...)
+}
+
+// bind returns a gorm handle whose statements run on exec with ctx. The
+// handle cannot start transactions of its own; use transaction for that.
+func bind(ctx context.Context, exec executor) *gorm.DB {
+	// Open only fails when it has to dial a DSN itself.
+	db, _ := gorm.Open("postgres", ctxConn{ctx: ctx, exec: exec})
+	return db
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// conn runs fn on the primary outside a transaction, bounded by timeout.
+func (r *OrderPostgresRepo) conn(ctx context.Context, timeout time.Duration, fn func(db *gorm.DB) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	return fn(bind(ctx, r.db.DB()))
+}
+
+// transaction runs fn in a transaction on the primary, bounded by timeout,
+// and commits if fn returns nil.
+func (r *OrderPostgresRepo) transaction(ctx context.Context, timeout time.Duration, fn func(tx *gorm.DB) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	sqlTx, err := r.db.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	if err := fn(bind(ctx, sqlTx)); err != nil {
+		return err
+	}
+	return sqlTx.Commit()
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+)
+
+// eachCursor is the name of the server-side cursor Each reads through. It
+// only lives as long as the transaction, so a fixed name is safe.
+const eachCursor = "orders_each"
+
+// eachTxOptions give Each a single snapshot for the whole walk, so every
+// order is seen exactly once even while writers keep going.
+var eachTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// declareEachSQL returns the statement declaring the cursor over the uids of
+// the orders o selects, and its arguments.
+func declareEachSQL(o storage.ReadOptions) (string, []interface{}) {
+	where, args := eachWhere(o)
+	return `DECLARE ` + eachCursor + ` NO SCROLL CURSOR FOR SELECT o.order_uid FROM orders o` + where + ` ORDER BY o.order_uid`, args
+}
+
+// eachWhere returns the WHERE clause, if any, selecting the orders Each
+// walks, and its arguments.
+func eachWhere(o storage.ReadOptions) (string, []interface{}) {
+	var conds []string
+	if !o.IncludeDeleted {
+		conds = append(conds, `o.deleted_at IS NULL`)
+	}
+	cond, args := o.Filter.SQL(1, pgParam)
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	if len(conds) == 0 {
+		return ``, nil
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), args
+}
+
+func pgParam(n int) string { return fmt.Sprintf("$%d", n) }
+
+func fetchEachSQL(n int) string {
+	// FETCH takes its count as a literal, not as a parameter.
+	return fmt.Sprintf(`FETCH FORWARD %d FROM %s`, n, eachCursor)
+}
+
+// Each calls fn for every order, or every order matching storage.Matching,
+// in order_uid order, loading at most batchSize orders with their children
+// at a time. A server-side cursor walks the order uids, so memory stays flat
+// however large the table is. The read timeout bounds each batch rather than
+// the whole walk. An error from fn stops the walk and is returned as is.
+func (r *OrderPostgresRepo) Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error {
+	o := storage.NewReadOptions(opts...)
+	n := storage.BatchSize(batchSize)
+
+	if r.replica != nil && !o.Primary && r.replica.usable(ctx) {
+		called := false
+		err := r.each(ctx, r.replica.db.DB(), n, o, func(ord models.Order) error {
+			called = true
+			return fn(ord)
+		})
+		// Falling back after fn has seen orders would hand them out twice.
+		if err == nil || called || ctx.Err() != nil {
+			return err
+		}
+		r.replica.markDown()
+	}
+	return r.each(ctx, r.db.DB(), n, o, fn)
+}
+
+func (r *OrderPostgresRepo) each(ctx context.Context, db *sql.DB, n int, o storage.ReadOptions, fn func(models.Order) error) error {
+	sqlTx, err := db.BeginTx(ctx, eachTxOptions)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	declare, args := declareEachSQL(o)
+	if _, err := sqlTx.ExecContext(ctx, declare, args...); err != nil {
+		return err
+	}
+	for {
+		batch, err := r.eachBatch(ctx, sqlTx, n, o.IncludeDeleted)
+		if err != nil {
+			return err
+		}
+		for _, ord := range batch {
+			if err := fn(ord); err != nil {
+				return err
+			}
+		}
+		if len(batch) < n {
+			return sqlTx.Commit()
+		}
+	}
+}
+
+// eachBatch fetches the next n uids from the cursor and loads their orders.
+func (r *OrderPostgresRepo) eachBatch(ctx context.Context, sqlTx *sql.Tx, n int, includeDeleted bool) ([]models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	rows, err := sqlTx.QueryContext(ctx, fetchEachSQL(n))
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, n)
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	db := bind(ctx, sqlTx)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+	batch := make([]models.Order, 0, len(uids))
+	err = db.Preload("Delivery").
+		Preload("Payment").
+		Preload("Items").
+		Where("order_uid IN (?)", uids).
+		Order("order_uid").
+		Find(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return batch, openOrders(r.ring, batch)
+}
@@ -0,0 +1,322 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+type erasureReceipt struct {
+	ID         int64     `gorm:"primary_key"`
+	CustomerId string    `gorm:"type:text;not null;index"`
+	OrderUids  string    `gorm:"type:jsonb;not null"`
+	Deliveries int       `gorm:"not null"`
+	Revisions  int       `gorm:"not null"`
+	Messages   int       `gorm:"not null"`
+	ErasedAt   time.Time `gorm:"not null"`
+}
+
+func (erasureReceipt) TableName() string { return "erasure_receipts" }
+
+// erasedOrder marks an order whose personal data has been erased, so that a
+// copy of it arriving later can be erased as well.
+type erasedOrder struct {
+	OrderUid   string    `gorm:"primary_key;type:text"`
+	CustomerId string    `gorm:"type:text;not null"`
+	ErasedAt   time.Time `gorm:"not null"`
+}
+
+func (erasedOrder) TableName() string { return "erased_orders" }
+
+// erasureMigrations mark the orders of the receipts stored before
+// erased_orders was added.
+var erasureMigrations = []string{
+	`INSERT INTO erased_orders (order_uid, customer_id, erased_at)
+	SELECT uid, customer_id, erased_at FROM erasure_receipts, jsonb_array_elements_text(order_uids) AS uid
+	ON CONFLICT (order_uid) DO NOTHING`,
+}
+
+func (row erasureReceipt) model() (models.ErasureReceipt, error) {
+	rec := models.ErasureReceipt{
+		ID:         row.ID,
+		CustomerId: row.CustomerId,
+		Deliveries: row.Deliveries,
+		Revisions:  row.Revisions,
+		Messages:   row.Messages,
+		ErasedAt:   row.ErasedAt,
+	}
+	err := json.Unmarshal([]byte(row.OrderUids), &rec.OrderUids)
+	return rec, err
+}
+
+// EraseCustomer replaces the personal data of every order of customerID,
+// soft-deleted ones included, with placeholders: in the delivery rows, in
+// the revision snapshots and diffs, and in the archived Kafka payloads.
+// Orders, payments and items are kept for accounting. A receipt of the
+// erasure is stored in the same transaction and returned, and the orders
+// are marked as erased for ErasedOrders. Erasing a customer whose data is
+// already erased changes nothing and returns the last receipt.
+func (r *OrderPostgresRepo) EraseCustomer(ctx context.Context, customerID string) (models.ErasureReceipt, error) {
+	var rec models.ErasureReceipt
+	err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		// Serializes erasures of one customer, also when it has no orders yet.
+		if err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('erasure:' || ?))`, customerID).Error; err != nil {
+			return err
+		}
+
+		uids := []string{}
+		rows, err := tx.Raw(`SELECT order_uid FROM orders WHERE customer_id = ? ORDER BY order_uid FOR UPDATE`, customerID).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var uid string
+			if err := rows.Scan(&uid); err != nil {
+				return err
+			}
+			uids = append(uids, uid)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		row := erasureReceipt{CustomerId: customerID}
+		if len(uids) > 0 {
+			for _, uid := range uids {
+				if err := tx.Exec(`INSERT INTO erased_orders (order_uid, customer_id, erased_at)
+					VALUES (?, ?, ?) ON CONFLICT (order_uid) DO NOTHING`,
+					uid, customerID, time.Now().UTC()).Error; err != nil {
+					return err
+				}
+			}
+			if row.Deliveries, err = r.eraseDeliveries(tx, uids); err != nil {
+				return err
+			}
+			if row.Revisions, err = r.eraseRevisions(tx, uids); err != nil {
+				return err
+			}
+			if row.Messages, err = r.eraseMessages(tx, uids); err != nil {
+				return err
+			}
+		}
+
+		if row.Deliveries+row.Revisions+row.Messages == 0 {
+			var last erasureReceipt
+			err := tx.Where("customer_id = ?", customerID).Order("id DESC").First(&last).Error
+			if err == nil {
+				rec, err = last.model()
+				return err
+			}
+			if !gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(uids)
+		if err != nil {
+			return err
+		}
+		row.OrderUids = string(data)
+		row.ErasedAt = time.Now().UTC()
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+		rec, err = row.model()
+		return err
+	})
+	return rec, err
+}
+
+// ErasureReceipts returns the receipts of the erasures of customerID, oldest
+// first.
+func (r *OrderPostgresRepo) ErasureReceipts(ctx context.Context, customerID string) ([]models.ErasureReceipt, error) {
+	var rows []erasureReceipt
+	if err := r.read(ctx, nil, func(db *gorm.DB) error {
+		rows = nil
+		return db.Where("customer_id = ?", customerID).Order("id").Find(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+	out := make([]models.ErasureReceipt, 0, len(rows))
+	for _, row := range rows {
+		rec, err := row.model()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// ErasedOrders returns which of uids belong to erased orders. It reads from
+// the primary, so an erasure that has just committed is seen.
+func (r *OrderPostgresRepo) ErasedOrders(ctx context.Context, uids []string) (map[string]bool, error) {
+	erased := make(map[string]bool)
+	if len(uids) == 0 {
+		return erased, nil
+	}
+	var rows []erasedOrder
+	if err := r.conn(ctx, r.timeouts.Read, func(db *gorm.DB) error {
+		rows = nil
+		return db.Where("order_uid IN (?)", uids).Find(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		erased[row.OrderUid] = true
+	}
+	return erased, nil
+}
+
+func (r *OrderPostgresRepo) eraseDeliveries(tx *gorm.DB, uids []string) (int, error) {
+	var rows []models.Delivery
+	if err := tx.Raw(`SELECT order_refer, name, phone, address, email FROM deliveries
+		WHERE order_refer IN (?) ORDER BY order_refer FOR UPDATE`, uids).Scan(&rows).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, d := range rows {
+		if r.ring.OpenDelivery(&d) == nil && pii.DeliveryErased(&d) {
+			continue
+		}
+		pii.EraseDelivery(&d)
+		sealed, email, phone, err := sealDelivery(r.ring, d.OrderRefer, d)
+		if err != nil {
+			return count, err
+		}
+		if err := tx.Exec(`UPDATE deliveries
+			SET name = ?, phone = ?, address = ?, email = ?, email_bidx = ?, phone_bidx = ?
+			WHERE order_refer = ?`,
+			sealed.Name, sealed.Phone, sealed.Address, sealed.Email, email, phone, d.OrderRefer).Error; err != nil {
+			return count, err
+		}
+		// The content hash is kept: a redelivered payload of the same
+		// version is taken for a duplicate and not written at all.
+		count++
+	}
+	return count, nil
+}
+
+func (r *OrderPostgresRepo) eraseRevisions(tx *gorm.DB, uids []string) (int, error) {
+	var revs []orderRevision
+	if err := tx.Raw(`SELECT id, order_uid, data, diff FROM order_revisions
+		WHERE order_uid IN (?) ORDER BY id FOR UPDATE`, uids).Scan(&revs).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, rev := range revs {
+		data, diff, changed, err := r.eraseRevision(rev)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+		if err := tx.Exec(`UPDATE order_revisions SET data = ?, diff = ? WHERE id = ?`,
+			data, diff, rev.ID).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// eraseRevision returns the snapshot and the diff of rev with the personal
+// data erased, and whether that changed anything. A diff keeps recording
+// that a personal field changed, with placeholders on both sides.
+func (r *OrderPostgresRepo) eraseRevision(rev orderRevision) (string, string, bool, error) {
+	var o models.Order
+	if err := json.Unmarshal([]byte(rev.Data), &o); err != nil {
+		return "", "", false, err
+	}
+	var changes map[string]storage.Change
+	if err := json.Unmarshal([]byte(rev.Diff), &changes); err != nil {
+		return "", "", false, err
+	}
+
+	changed := false
+	if o.Delivery != nil {
+		d := *o.Delivery
+		d.OrderRefer = rev.OrderUid
+		if r.ring.OpenDelivery(&d) != nil || !pii.DeliveryErased(&d) {
+			changed = true
+		}
+		pii.EraseDelivery(o.Delivery)
+	}
+	if err := mapChanges(changes, func(v, col string) (string, error) {
+		if pii.Sealed(v) {
+			plain, err := r.ring.Open(v, pii.FieldAAD(col, rev.OrderUid))
+			v = string(plain)
+			if err != nil {
+				v = ""
+			}
+		}
+		changed = changed || v != pii.Erased(col)
+		return pii.Erased(col), nil
+	}); err != nil {
+		return "", "", false, err
+	}
+	if !changed {
+		return "", "", false, nil
+	}
+
+	data, err := sealSnapshot(r.ring, o)
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := sealChanges(r.ring, rev.OrderUid, changes); err != nil {
+		return "", "", false, err
+	}
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return "", "", false, err
+	}
+	return string(data), string(diff), true, nil
+}
+
+// eraseMessages erases the personal data of the archived payloads. A sealed
+// payload that cannot be opened any more is erased as a whole.
+func (r *OrderPostgresRepo) eraseMessages(tx *gorm.DB, uids []string) (int, error) {
+	var msgs []rawMessage
+	if err := tx.Raw(`SELECT id, topic, kafka_partition, kafka_offset, payload FROM raw_messages
+		WHERE order_uid IN (?) ORDER BY id FOR UPDATE`, uids).Scan(&msgs).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, m := range msgs {
+		aad := rawMessageAAD(m.Topic, m.Partition, m.Offset)
+		plain := m.Payload
+		if pii.Sealed(string(plain)) {
+			var err error
+			if plain, err = r.ring.Open(string(plain), aad); err != nil {
+				plain = nil
+			}
+		}
+		erased := pii.ErasePayload(plain)
+		if plain != nil && bytes.Equal(erased, plain) {
+			continue
+		}
+		payload := erased
+		if r.ring != nil {
+			sealed, err := r.ring.Seal(erased, aad)
+			if err != nil {
+				return count, err
+			}
+			payload = []byte(sealed)
+		}
+		if err := tx.Exec(`UPDATE raw_messages SET payload = ? WHERE id = ?`, payload, m.ID).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+
+	"l0-demo/internal/repository/storage"
+)
+
+var constraintKinds = map[string]error{
+	"23502": storage.ErrNotNull,
+	"23503": storage.ErrForeignKey,
+	"23505": storage.ErrUnique,
+	"23514": storage.ErrCheck,
+}
+
+// mapError turns constraint violations reported by Postgres, through either
+// driver, into *storage.ConstraintError and leaves every other error as is.
+func mapError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return constraintError(string(pqErr.Code), pqErr.Table, pqErr.Column, pqErr.Constraint, err)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return constraintError(pgErr.Code, pgErr.TableName, pgErr.ColumnName, pgErr.ConstraintName, err)
+	}
+	return err
+}
+
+func constraintError(code, table, column, constraint string, err error) error {
+	kind, ok := constraintKinds[code]
+	if !ok {
+		return err
+	}
+	if column == "" {
+		column = checkColumn(table, constraint)
+	}
+	return &storage.ConstraintError{
+		Kind:       kind,
+		Table:      table,
+		Column:     column,
+		Constraint: constraint,
+		Err:        err,
+	}
+}
+
+// checkColumn names the column a check constraint guards, which Postgres
+// does not report for check violations. Checks are named
+// chk_<table>_<column>, optionally suffixed with _len or _range.
+func checkColumn(table, constraint string) string {
+	column, ok := strings.CutPrefix(constraint, "chk_"+table+"_")
+	if !ok {
+		return ""
+	}
+	for _, suffix := range []string{"_len", "_range"} {
+		column = strings.TrimSuffix(column, suffix)
+	}
+	return column
+}
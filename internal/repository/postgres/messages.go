@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+)
+
+var messageMigrations = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_raw_messages_position ON raw_messages (topic, kafka_partition, kafka_offset)`,
+}
+
+type rawMessage struct {
+	ID         int64     `gorm:"primary_key"`
+	OrderUid   string    `gorm:"type:text;index"`
+	Topic      string    `gorm:"type:text;not null"`
+	Partition  int       `gorm:"column:kafka_partition;not null"`
+	Offset     int64     `gorm:"column:kafka_offset;not null"`
+	Key        []byte    `gorm:"column:message_key;type:bytea"`
+	Headers    string    `gorm:"type:jsonb;not null"`
+	Payload    []byte    `gorm:"type:bytea;not null"`
+	ReceivedAt time.Time `gorm:"not null"`
+	Outcome    string    `gorm:"type:varchar(32);not null"`
+	Error      string    `gorm:"type:text"`
+}
+
+func (rawMessage) TableName() string { return "raw_messages" }
+
+// SaveRawMessage stores a consumed message. A message redelivered at the same
+// position replaces the earlier record, so the last outcome wins.
+func (r *OrderPostgresRepo) SaveRawMessage(ctx context.Context, m models.RawMessage) error {
+	headers, err := json.Marshal(m.Headers)
+	if err != nil {
+		return err
+	}
+	if m.Headers == nil {
+		headers = []byte(`{}`)
+	}
+	payload := []byte(m.Payload)
+	if r.ring != nil {
+		sealed, err := r.ring.Seal(payload, rawMessageAAD(m.Topic, m.Partition, m.Offset))
+		if err != nil {
+			return err
+		}
+		payload = []byte(sealed)
+	}
+	return r.conn(ctx, r.timeouts.Write, func(db *gorm.DB) error {
+		return db.Exec(`INSERT INTO raw_messages
+		(order_uid, topic, kafka_partition, kafka_offset, message_key, headers, payload, received_at, outcome, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (topic, kafka_partition, kafka_offset) DO UPDATE SET
+			order_uid = EXCLUDED.order_uid,
+			message_key = EXCLUDED.message_key,
+			headers = EXCLUDED.headers,
+			payload = EXCLUDED.payload,
+			received_at = EXCLUDED.received_at,
+			outcome = EXCLUDED.outcome,
+			error = EXCLUDED.error`,
+			m.OrderUid, m.Topic, m.Partition, m.Offset, []byte(m.Key), string(headers),
+			payload, m.ReceivedAt, m.Outcome, m.Error).Error
+	})
+}
+
+// rawMessageAAD binds a sealed payload to its position in the topic.
+func rawMessageAAD(topic string, partition int, offset int64) string {
+	return fmt.Sprintf("raw_messages.payload:%s/%d/%d", topic, partition, offset)
+}
+
+func (r *OrderPostgresRepo) RawMessages(ctx context.Context, uid string) ([]models.RawMessage, error) {
+	var rows []rawMessage
+	if err := r.read(ctx, nil, func(db *gorm.DB) error {
+		rows = nil
+		return db.Where("order_uid = ?", uid).Order("received_at, id").Find(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	out := make([]models.RawMessage, 0, len(rows))
+	for _, row := range rows {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(row.Headers), &headers); err != nil {
+			return nil, err
+		}
+		payload := row.Payload
+		if pii.Sealed(string(payload)) {
+			var err error
+			if payload, err = r.ring.Open(string(payload), rawMessageAAD(row.Topic, row.Partition, row.Offset)); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, models.RawMessage{
+			ID:         row.ID,
+			OrderUid:   row.OrderUid,
+			Topic:      row.Topic,
+			Partition:  row.Partition,
+			Offset:     row.Offset,
+			Key:        string(row.Key),
+			Headers:    headers,
+			Payload:    string(payload),
+			ReceivedAt: row.ReceivedAt,
+			Outcome:    row.Outcome,
+			Error:      row.Error,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+)
+
+// MigrateOption changes what Migrate does.
+type MigrateOption func(*migration)
+
+type migration struct {
+	unchecked map[string][]string
+}
+
+// WithoutChecks leaves out the check constraints of the given columns, by
+// table, and drops them if an earlier migration added them. It is meant for
+// the columns whose rules validation profiles replace.
+func WithoutChecks(columns map[string][]string) MigrateOption {
+	return func(m *migration) { m.unchecked = columns }
+}
+
+// Migrate brings the schema up to date. Children of an order are deduplicated
+// before the unique indexes on order_refer are created, since the old
+// count-then-insert upsert could leave several rows per order behind.
+func Migrate(db *gorm.DB, opts ...MigrateOption) error {
+	var m migration
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	for _, table := range []string{"deliveries", "payments"} {
+		if !db.HasTable(table) {
+			continue
+		}
+		if err := db.Exec(`DELETE FROM ` + table + ` a USING ` + table + ` b
+			WHERE a.order_refer = b.order_refer AND a.ctid < b.ctid`).Error; err != nil {
+			return err
+		}
+		if err := db.Exec(`DROP INDEX IF EXISTS idx_` + table + `_order_refer`).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.AutoMigrate(
+		&models.Order{},
+		&models.Delivery{},
+		&models.Payment{},
+		&models.Item{},
+		&orderRevision{},
+		&outboxEvent{},
+		&rawMessage{},
+		&consumerOffset{},
+		&erasureReceipt{},
+		&erasedOrder{},
+	).Error; err != nil {
+		return err
+	}
+
+	if err := migrateConstraints(db, m.unchecked); err != nil {
+		return err
+	}
+	if err := migrateDeliverySearch(db); err != nil {
+		return err
+	}
+
+	for _, stmts := range [][]string{searchMigrations, statsMigrations, outboxMigrations, messageMigrations, piiMigrations, erasureMigrations} {
+		for _, stmt := range stmts {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
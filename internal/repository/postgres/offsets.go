@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/repository/storage"
+)
+
+// consumerOffset holds the next offset to read, as Kafka commits do.
+type consumerOffset struct {
+	GroupID    string    `gorm:"primary_key;type:text"`
+	Topic      string    `gorm:"primary_key;type:text"`
+	Partition  int       `gorm:"column:kafka_partition;primary_key;type:integer"`
+	NextOffset int64     `gorm:"not null"`
+	UpdatedAt  time.Time `gorm:"not null"`
+}
+
+func (consumerOffset) TableName() string { return "consumer_offsets" }
+
+const claimOffsetSQL = `INSERT INTO consumer_offsets (group_id, topic, kafka_partition, next_offset, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (group_id, topic, kafka_partition) DO UPDATE
+	SET next_offset = EXCLUDED.next_offset, updated_at = EXCLUDED.updated_at
+	WHERE consumer_offsets.next_offset <= ?`
+
+func claimOffsetArgs(off storage.Offset) []interface{} {
+	return []interface{}{off.Group, off.Topic, off.Partition, off.Offset + 1, time.Now().UTC(), off.Offset}
+}
+
+// claimOffset moves the stored position past off. Nothing is written and
+// ErrOffsetProcessed is returned if it is already there, which also stops a
+// consumer of an old group generation from applying a message twice.
+func claimOffset(tx *gorm.DB, off storage.Offset) error {
+	res := tx.Exec(claimOffsetSQL, claimOffsetArgs(off)...)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrOffsetProcessed
+	}
+	return nil
+}
+
+// StoreOffset records a message that was handled without writing an order,
+// e.g. a stale or rejected one. The stored position never moves back.
+func (r *OrderPostgresRepo) StoreOffset(ctx context.Context, off storage.Offset) error {
+	return r.conn(ctx, r.timeouts.Write, func(db *gorm.DB) error {
+		if err := claimOffset(db, off); err != nil && !errors.Is(err, storage.ErrOffsetProcessed) {
+			return err
+		}
+		return nil
+	})
+}
+
+// Offsets returns the next offset to read per partition. It always reads the
+// primary, since a lagging replica would hand out positions already applied.
+func (r *OrderPostgresRepo) Offsets(ctx context.Context, group, topic string) (map[int]int64, error) {
+	var rows []consumerOffset
+	if err := r.conn(ctx, r.timeouts.Read, func(db *gorm.DB) error {
+		return db.Where("group_id = ? AND topic = ?", group, topic).Find(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+	out := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		out[row.Partition] = row.NextOffset
+	}
+	return out, nil
+}
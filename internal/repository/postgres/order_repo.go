@@ -1,22 +1,44 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
 	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
 
 	"github.com/jinzhu/gorm"
 )
 
 type OrderPostgresRepo struct {
-	db *gorm.DB
+	db       *gorm.DB
+	replica  *replicaState
+	timeouts Timeouts
+	layout   ordersLayout
+	ring     *pii.Keyring
 }
 
-func NewOrderPostgres(db *gorm.DB) *OrderPostgresRepo {
-	return &OrderPostgresRepo{db: db}
+func NewOrderPostgres(db *gorm.DB, opts ...Option) *OrderPostgresRepo {
+	r := &OrderPostgresRepo{db: db}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
-func (r *OrderPostgresRepo) Create(o models.Order) error {
+func (r *OrderPostgresRepo) Create(ctx context.Context, o models.Order) error {
+	plain := o
+	var emailIdx, phoneIdx interface{}
 	if o.Delivery != nil {
-		o.Delivery.OrderRefer = o.OrderUid
+		// Seal a copy: the caller keeps using its order in clear.
+		d, email, phone, err := sealDelivery(r.ring, o.OrderUid, *o.Delivery)
+		if err != nil {
+			return err
+		}
+		o.Delivery, emailIdx, phoneIdx = &d, email, phone
 	}
 	if o.Payment != nil {
 		o.Payment.OrderRefer = o.OrderUid
@@ -25,12 +47,97 @@ func (r *OrderPostgresRepo) Create(o models.Order) error {
 		o.Items[i].OrderRefer = o.OrderUid
 	}
 
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		return tx.Create(&o).Error
+	err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		partitioned, err := r.partitioned(tx)
+		if err != nil {
+			return err
+		}
+		if partitioned {
+			if err := ensurePartition(tx, o.DateCreated); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&o).Error; err != nil {
+			return err
+		}
+		if emailIdx != nil || phoneIdx != nil {
+			if err := tx.Exec(`UPDATE deliveries SET email_bidx = ?, phone_bidx = ? WHERE order_refer = ?`,
+				emailIdx, phoneIdx, o.OrderUid).Error; err != nil {
+				return err
+			}
+		}
+		if err := recordRevision(tx, r.ring, plain); err != nil {
+			return err
+		}
+		return enqueueEvent(tx, models.OrderStoredEvent, o.OrderUid, o.Version)
 	})
+	return mapError(err)
 }
 
-func (r *OrderPostgresRepo) CreateOrUpdate(o models.Order) error {
+// CreateOrUpdate upserts o. An order stored already with the same content
+// and at least its version is left as it is and ErrUnchanged is returned
+// once the offset, if any, is stored.
+func (r *OrderPostgresRepo) CreateOrUpdate(ctx context.Context, o models.Order, opts ...storage.WriteOption) error {
+	wo := storage.NewWriteOptions(opts...)
+	unchanged := false
+	err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		if wo.Offset != nil {
+			if err := claimOffset(tx, *wo.Offset); err != nil {
+				return err
+			}
+		}
+		err := r.upsert(tx, o)
+		if errors.Is(err, storage.ErrUnchanged) {
+			unchanged = true
+			return nil
+		}
+		return err
+	})
+	if err == nil && unchanged {
+		return storage.ErrUnchanged
+	}
+	return mapError(err)
+}
+
+// CreateOrUpdateBatch upserts orders in one transaction, each under its own
+// savepoint: an order that is stale, unchanged or breaks a constraint is
+// rolled back alone and its error is returned at its index in errs, while the
+// others are still committed. Any other error aborts the whole batch. The write timeout
+// applies per order.
+func (r *OrderPostgresRepo) CreateOrUpdateBatch(ctx context.Context, orders []models.Order) (errs []error, err error) {
+	errs = make([]error, len(orders))
+	err = r.transaction(ctx, r.timeouts.Write*time.Duration(len(orders)), func(tx *gorm.DB) error {
+		for i, o := range orders {
+			if err := tx.Exec(`SAVEPOINT batch_order`).Error; err != nil {
+				return err
+			}
+			err := mapError(r.upsert(tx, o))
+			if err == nil {
+				if err := tx.Exec(`RELEASE SAVEPOINT batch_order`).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if !errors.Is(err, storage.ErrStaleVersion) && !errors.Is(err, storage.ErrUnchanged) &&
+				!errors.Is(err, storage.ErrConstraint) {
+				return err
+			}
+			if err := tx.Exec(`ROLLBACK TO SAVEPOINT batch_order`).Error; err != nil {
+				return err
+			}
+			errs[i] = err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return errs, nil
+}
+
+// upsert writes o and its children, records a revision and enqueues the
+// stored event within tx.
+func (r *OrderPostgresRepo) upsert(tx *gorm.DB, o models.Order) error {
 	if o.Delivery != nil {
 		o.Delivery.OrderRefer = o.OrderUid
 	}
@@ -41,118 +148,182 @@ func (r *OrderPostgresRepo) CreateOrUpdate(o models.Order) error {
 		o.Items[i].OrderRefer = o.OrderUid
 	}
 
-	return r.db.
-		Set("gorm:association_autocreate", false).
-		Set("gorm:association_autoupdate", false).
-		Transaction(func(tx *gorm.DB) error {
+	partitioned, err := r.partitioned(tx)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		err = upsertPartitionedOrder(tx, o)
+	} else {
+		err = upsertOrder(tx, o)
+	}
+	if err != nil {
+		return err
+	}
 
-			var count int
-			if err := tx.Model(&models.Order{}).
-				Where("order_uid = ?", o.OrderUid).
-				Count(&count).Error; err != nil {
-				return err
-			}
+	if d := o.Delivery; d != nil {
+		args, err := deliveryArgs(r.ring, o.OrderUid, *d)
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec(upsertDeliverySQL, args...).Error; err != nil {
+			return err
+		}
+	}
 
-			if count == 0 {
-				hdr := models.Order{
-					OrderUid:          o.OrderUid,
-					TrackNumber:       o.TrackNumber,
-					Entry:             o.Entry,
-					Locale:            o.Locale,
-					InternalSignature: o.InternalSignature,
-					CustomerId:        o.CustomerId,
-					DeliveryService:   o.DeliveryService,
-					ShardKey:          o.ShardKey,
-					SmId:              o.SmId,
-					DateCreated:       o.DateCreated,
-					OofShard:          o.OofShard,
-				}
-				if err := tx.Create(&hdr).Error; err != nil {
-					return err
-				}
-			} else {
-				if err := tx.Model(&models.Order{}).
-					Where("order_uid = ?", o.OrderUid).
-					Updates(map[string]interface{}{
-						"track_number":       o.TrackNumber,
-						"entry":              o.Entry,
-						"locale":             o.Locale,
-						"internal_signature": o.InternalSignature,
-						"customer_id":        o.CustomerId,
-						"delivery_service":   o.DeliveryService,
-						"shard_key":          o.ShardKey,
-						"sm_id":              o.SmId,
-						"date_created":       o.DateCreated,
-						"oof_shard":          o.OofShard,
-					}).Error; err != nil {
-					return err
-				}
-			}
+	if p := o.Payment; p != nil {
+		if err := tx.Exec(upsertPaymentSQL,
+			p.OrderRefer, p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
+			p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+		).Error; err != nil {
+			return err
+		}
+	}
 
-			if o.Delivery != nil {
-				var d models.Delivery
-				err := tx.Where("order_refer = ?", o.OrderUid).First(&d).Error
-				switch {
-				case gorm.IsRecordNotFoundError(err):
-					if err := tx.Model(&models.Delivery{}).Create(o.Delivery).Error; err != nil {
-						return err
-					}
-				case err != nil:
-					return err
-				default:
-					if err := tx.Model(&d).Updates(o.Delivery).Error; err != nil {
-						return err
-					}
-				}
-			}
+	query, args := replaceItemsSQL(o.OrderUid, o.Items)
+	if err := tx.Exec(query, args...).Error; err != nil {
+		return err
+	}
 
-			if o.Payment != nil {
-				var p models.Payment
-				err := tx.Where("order_refer = ?", o.OrderUid).First(&p).Error
-				switch {
-				case gorm.IsRecordNotFoundError(err):
-					if err := tx.Model(&models.Payment{}).Create(o.Payment).Error; err != nil {
-						return err
-					}
-				case err != nil:
-					return err
-				default:
-					if err := tx.Model(&p).Updates(o.Payment).Error; err != nil {
-						return err
-					}
-				}
-			}
+	// The upsert brings a soft-deleted order back.
+	o.DeletedAt = nil
+	if err := recordRevision(tx, r.ring, o); err != nil {
+		return err
+	}
+	return enqueueEvent(tx, models.OrderStoredEvent, o.OrderUid, o.Version)
+}
 
-			if err := tx.Where("order_refer = ?", o.OrderUid).Delete(models.Item{}).Error; err != nil {
-				return err
-			}
-			if len(o.Items) > 0 {
-				for i := range o.Items {
-					if err := tx.Model(&models.Item{}).Create(&o.Items[i]).Error; err != nil {
-						return err
-					}
-				}
-			}
+func upsertOrder(tx *gorm.DB, o models.Order) error {
+	res := tx.Exec(upsertOrderSQL,
+		o.OrderUid, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, o.Version,
+		o.ContentHash,
+	)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	if _, err := skippedOrder(tx, o); err != nil {
+		return err
+	}
+	return storage.ErrStaleVersion
+}
 
-			return nil
-		})
+// skippedOrder reports whether o, which an upsert left alone, is stored at
+// all, and if so returns ErrUnchanged or ErrStaleVersion.
+func skippedOrder(tx *gorm.DB, o models.Order) (bool, error) {
+	var same bool
+	err := tx.Raw(orderStateSQL, o.ContentHash, o.ContentHash, o.Version, o.OrderUid).Row().Scan(&same)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	case same:
+		return true, storage.ErrUnchanged
+	default:
+		return true, storage.ErrStaleVersion
+	}
+}
+
+// upsertPartitionedOrder serializes writers of one uid with an advisory lock,
+// since a partitioned table cannot enforce uniqueness of order_uid alone.
+func upsertPartitionedOrder(tx *gorm.DB, o models.Order) error {
+	if err := tx.Exec(orderLockSQL, o.OrderUid).Error; err != nil {
+		return err
+	}
+	if err := ensurePartition(tx, o.DateCreated); err != nil {
+		return err
+	}
+
+	res := tx.Exec(updateOrderSQL,
+		o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, o.Version,
+		o.ContentHash, o.OrderUid, o.Version, o.Version, o.ContentHash, o.ContentHash,
+	)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	if exists, err := skippedOrder(tx, o); exists || err != nil {
+		return err
+	}
+	return tx.Exec(insertOrderSQL,
+		o.OrderUid, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, o.Version,
+		o.ContentHash,
+	).Error
 }
 
-func (r *OrderPostgresRepo) Get(uid string) (models.Order, error) {
+func (r *OrderPostgresRepo) Get(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error) {
 	var o models.Order
-	q := r.db.Preload("Delivery").
-		Preload("Payment").
-		Preload("Items").
-		Where("order_uid = ?", uid).
-		First(&o)
-	return o, q.Error
+	err := r.read(ctx, opts, func(db *gorm.DB) error {
+		o = models.Order{}
+		return db.Preload("Delivery").
+			Preload("Payment").
+			Preload("Items").
+			Where("order_uid = ?", uid).
+			First(&o).Error
+	})
+	if err != nil {
+		return o, err
+	}
+	return o, openOrder(r.ring, &o)
 }
 
-func (r *OrderPostgresRepo) GetAll() ([]models.Order, error) {
+func (r *OrderPostgresRepo) GetAll(ctx context.Context, opts ...storage.ReadOption) ([]models.Order, error) {
 	var out []models.Order
-	q := r.db.Preload("Delivery").
-		Preload("Payment").
-		Preload("Items").
-		Find(&out)
-	return out, q.Error
+	err := r.read(ctx, opts, func(db *gorm.DB) error {
+		out = nil
+		return db.Preload("Delivery").
+			Preload("Payment").
+			Preload("Items").
+			Find(&out).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, openOrders(r.ring, out)
+}
+
+func (r *OrderPostgresRepo) Delete(ctx context.Context, uid string) error {
+	err := r.conn(ctx, r.timeouts.Write, func(db *gorm.DB) error {
+		res := db.Where("order_uid = ?", uid).Delete(&models.Order{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return mapError(err)
+}
+
+func (r *OrderPostgresRepo) HardDelete(ctx context.Context, uid string) error {
+	err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		for _, table := range []string{"items", "deliveries", "payments"} {
+			if err := tx.Exec(`DELETE FROM `+table+` WHERE order_refer = ?`, uid).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec(`DELETE FROM order_revisions WHERE order_uid = ?`, uid).Error; err != nil {
+			return err
+		}
+
+		res := tx.Exec(`DELETE FROM orders WHERE order_uid = ?`, uid)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return mapError(err)
 }
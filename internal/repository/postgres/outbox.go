@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+)
+
+// outboxLockKey serializes relays across instances so that events of one
+// order are never published out of order.
+const outboxLockKey = 0x6f75_7462_6f78
+
+var outboxMigrations = []string{
+	`CREATE INDEX IF NOT EXISTS idx_order_outbox_pending ON order_outbox (id) WHERE delivered_at IS NULL`,
+}
+
+type outboxEvent struct {
+	ID          int64      `gorm:"primary_key"`
+	Type        string     `gorm:"type:varchar(64);not null"`
+	OrderUid    string     `gorm:"type:varchar(19);not null"`
+	Version     int64      `gorm:"not null"`
+	CreatedAt   time.Time  `gorm:"not null"`
+	DeliveredAt *time.Time `gorm:"index"`
+}
+
+func (outboxEvent) TableName() string { return "order_outbox" }
+
+func enqueueEvent(tx *gorm.DB, typ, uid string, version int64) error {
+	return tx.Create(&outboxEvent{Type: typ, OrderUid: uid, Version: version}).Error
+}
+
+// RelayOutbox hands up to limit undelivered events, oldest first, to publish
+// and marks them delivered once it returns nil. If publish fails the events
+// stay pending and are handed out again on the next call.
+func (r *OrderPostgresRepo) RelayOutbox(ctx context.Context, limit int, publish func([]models.OrderEvent) error) (int, error) {
+	relayed := 0
+	err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw(`SELECT pg_try_advisory_xact_lock(?)`, outboxLockKey).Row().Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var rows []outboxEvent
+		if err := tx.Where("delivered_at IS NULL").Order("id").Limit(limit).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		events := make([]models.OrderEvent, 0, len(rows))
+		ids := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			events = append(events, models.OrderEvent{
+				ID:       row.ID,
+				Type:     row.Type,
+				OrderUid: row.OrderUid,
+				Version:  row.Version,
+				StoredAt: row.CreatedAt,
+			})
+			ids = append(ids, row.ID)
+		}
+		if err := publish(events); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&outboxEvent{}).
+			Where("id IN (?)", ids).
+			Update("delivered_at", time.Now().UTC()).Error; err != nil {
+			return err
+		}
+		relayed = len(rows)
+		return nil
+	})
+	return relayed, err
+}
+
+func (r *OrderPostgresRepo) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := r.conn(ctx, r.timeouts.Write, func(db *gorm.DB) error {
+		res := db.Where("delivered_at < ?", before).Delete(&outboxEvent{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	return purged, err
+}
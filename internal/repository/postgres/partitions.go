@@ -0,0 +1,305 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+)
+
+const (
+	partitionLockKey    = 0x7061_7274
+	partitionNameLayout = "orders_p2006_01"
+	archiveBatchSize    = 500
+)
+
+var partitionNameRe = regexp.MustCompile(`^orders_p\d{4}_\d{2}$`)
+
+const (
+	ordersRelkindSQL   = `SELECT relkind FROM pg_class WHERE oid = 'orders'::regclass`
+	partitionExistsSQL = `SELECT to_regclass(?) IS NOT NULL`
+	partitionLockSQL   = `SELECT pg_advisory_xact_lock(?)`
+)
+
+// PartitionOrders turns orders into a table range-partitioned by month of
+// date_created, copying existing rows into their partitions, and makes sure
+// partitions exist for the current month and monthsAhead months after it.
+// Deliveries, payments and items stay unpartitioned; they are cleaned up
+// together with the partition their order lives in. The conversion fails,
+// changing nothing, while an order has no date_created to be placed by.
+func PartitionOrders(db *gorm.DB, monthsAhead int, opts ...MigrateOption) error {
+	var kind string
+	if err := db.Raw(ordersRelkindSQL).Row().Scan(&kind); err != nil {
+		return err
+	}
+
+	if kind != "p" {
+		if err := db.Transaction(convertToPartitioned); err != nil {
+			return fmt.Errorf("partition orders: %w", err)
+		}
+		if err := Migrate(db, opts...); err != nil {
+			return err
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return ensurePartitionsAhead(tx, monthsAhead)
+	})
+}
+
+// convertToPartitioned copies every order into a partitioned table inside
+// tx. The copy takes as long as the table is large, so it runs without a
+// statement timeout. The foreign keys of the children are dropped by name
+// rather than by CASCADE, so the conversion fails on anything else that
+// depends on the old table; Migrate adds the constraints back afterwards.
+func convertToPartitioned(tx *gorm.DB) error {
+	if err := tx.Exec(`SET LOCAL statement_timeout = 0`).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec(`LOCK TABLE orders IN ACCESS EXCLUSIVE MODE`).Error; err != nil {
+		return err
+	}
+	var undated int
+	if err := tx.Raw(`SELECT count(*) FROM orders WHERE date_created IS NULL`).Row().Scan(&undated); err != nil {
+		return err
+	}
+	if undated > 0 {
+		return fmt.Errorf("%d orders have no date_created and fit no partition", undated)
+	}
+
+	for _, fk := range foreignKeys {
+		if err := tx.Exec(`ALTER TABLE ` + fk.table + ` DROP CONSTRAINT IF EXISTS ` + fk.name).Error; err != nil {
+			return err
+		}
+	}
+	for _, stmt := range []string{
+		`ALTER TABLE orders RENAME TO orders_unpartitioned`,
+		`CREATE TABLE orders (LIKE orders_unpartitioned INCLUDING DEFAULTS) PARTITION BY RANGE (date_created)`,
+	} {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	var months []struct{ Month time.Time }
+	if err := tx.Raw(`SELECT DISTINCT date_trunc('month', date_created AT TIME ZONE 'UTC') AS month
+		FROM orders_unpartitioned`).
+		Scan(&months).Error; err != nil {
+		return err
+	}
+	for _, m := range months {
+		if err := createPartition(tx, m.Month); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range []string{
+		`INSERT INTO orders SELECT * FROM orders_unpartitioned`,
+		`DROP TABLE orders_unpartitioned`,
+		`ALTER TABLE orders ADD PRIMARY KEY (order_uid, date_created)`,
+	} {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensurePartitionsAhead(tx *gorm.DB, monthsAhead int) error {
+	start := monthStart(time.Now())
+	for i := 0; i <= monthsAhead; i++ {
+		if err := ensurePartition(tx, start.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensurePartition creates the monthly partition holding t inside tx unless it
+// already exists. Creators are serialized so that concurrent writers do not
+// race on the same CREATE TABLE.
+func ensurePartition(tx *gorm.DB, t time.Time) error {
+	var exists bool
+	if err := tx.Raw(partitionExistsSQL, partitionName(t)).Row().Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if err := tx.Exec(partitionLockSQL, partitionLockKey).Error; err != nil {
+		return err
+	}
+	return createPartition(tx, t)
+}
+
+func createPartition(tx *gorm.DB, t time.Time) error {
+	return tx.Exec(createPartitionSQL(t)).Error
+}
+
+func createPartitionSQL(t time.Time) string {
+	from := monthStart(t)
+	return fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF orders FOR VALUES FROM ('%s') TO ('%s')`,
+		partitionName(from), from.Format(time.RFC3339), from.AddDate(0, 1, 0).Format(time.RFC3339),
+	)
+}
+
+func partitionName(t time.Time) string {
+	return monthStart(t).Format(partitionNameLayout)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ordersLayout remembers whether orders is partitioned once it was looked up.
+type ordersLayout struct {
+	mu          sync.Mutex
+	known       bool
+	partitioned bool
+}
+
+func (l *ordersLayout) isPartitioned(relkind func() (string, error)) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.known {
+		return l.partitioned, nil
+	}
+
+	kind, err := relkind()
+	if err != nil {
+		return false, err
+	}
+	l.partitioned, l.known = kind == "p", true
+	return l.partitioned, nil
+}
+
+// partitioned tells whether orders is partitioned, querying it through db
+// the first time only.
+func (r *OrderPostgresRepo) partitioned(db *gorm.DB) (bool, error) {
+	return r.layout.isPartitioned(func() (string, error) {
+		var kind string
+		err := db.Raw(ordersRelkindSQL).Row().Scan(&kind)
+		return kind, err
+	})
+}
+
+func (r *OrderPostgresRepo) EnsurePartitions(ctx context.Context, monthsAhead int) error {
+	return r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+		partitioned, err := r.partitioned(tx)
+		if err != nil || !partitioned {
+			return err
+		}
+		return ensurePartitionsAhead(tx, monthsAhead)
+	})
+}
+
+// ExpiredPartitions lists monthly partitions whose whole range lies before
+// the given moment, oldest first.
+func (r *OrderPostgresRepo) ExpiredPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	var rows []struct{ Relname string }
+	if err := r.conn(ctx, r.timeouts.Read, func(db *gorm.DB) error {
+		partitioned, err := r.partitioned(db)
+		if err != nil || !partitioned {
+			return err
+		}
+		return db.Raw(`SELECT c.relname FROM pg_inherits i
+			JOIN pg_class c ON c.oid = i.inhrelid
+			WHERE i.inhparent = 'orders'::regclass
+			ORDER BY c.relname`).
+			Scan(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, row := range rows {
+		from, err := time.Parse(partitionNameLayout, row.Relname)
+		if err != nil {
+			continue
+		}
+		if !from.AddDate(0, 1, 0).After(before) {
+			out = append(out, row.Relname)
+		}
+	}
+	return out, nil
+}
+
+// DropPartition removes a partition together with the items, deliveries,
+// payments and revisions of its orders. When archive is set every order is
+// handed to it first, inside the same transaction, so nothing written to the
+// partition in the meantime is dropped unarchived. Archiving and deleting
+// the children may take long, so only ctx bounds the call and its statements
+// run without a statement timeout.
+func (r *OrderPostgresRepo) DropPartition(ctx context.Context, name string, archive storage.Archiver) (int, error) {
+	if !partitionNameRe.MatchString(name) {
+		return 0, fmt.Errorf("invalid partition name %q", name)
+	}
+
+	count := 0
+	err := r.transaction(ctx, 0, func(tx *gorm.DB) error {
+		if err := tx.Exec(`SET LOCAL statement_timeout = 0`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`LOCK TABLE ` + name + ` IN ACCESS EXCLUSIVE MODE`).Error; err != nil {
+			return err
+		}
+
+		if archive != nil {
+			last := ""
+			for {
+				var batch []models.Order
+				if err := tx.Unscoped().Table(name).
+					Preload("Delivery").
+					Preload("Payment").
+					Preload("Items").
+					Where("order_uid > ?", last).
+					Order("order_uid").
+					Limit(archiveBatchSize).
+					Find(&batch).Error; err != nil {
+					return err
+				}
+				for _, o := range batch {
+					if err := archive.Archive(o); err != nil {
+						return err
+					}
+				}
+				count += len(batch)
+				if len(batch) < archiveBatchSize {
+					break
+				}
+				last = batch[len(batch)-1].OrderUid
+			}
+			if err := archive.Close(); err != nil {
+				return err
+			}
+		} else if err := tx.Raw(`SELECT count(*) FROM ` + name).Row().Scan(&count); err != nil {
+			return err
+		}
+
+		for _, stmt := range []string{
+			`DELETE FROM items WHERE order_refer IN (SELECT order_uid FROM ` + name + `)`,
+			`DELETE FROM deliveries WHERE order_refer IN (SELECT order_uid FROM ` + name + `)`,
+			`DELETE FROM payments WHERE order_refer IN (SELECT order_uid FROM ` + name + `)`,
+			`DELETE FROM order_revisions WHERE order_uid IN (SELECT order_uid FROM ` + name + `)`,
+			`DROP TABLE ` + name,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,515 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+// selectOrderSQL loads orders together with their delivery, payment and
+// items in a single query, aggregating the children into JSON per order.
+const selectOrderSQL = `
+SELECT o.order_uid, o.track_number, o.entry, o.locale, coalesce(o.internal_signature, ''),
+	o.customer_id, o.delivery_service, coalesce(o.shard_key, ''), o.sm_id, o.date_created,
+	o.oof_shard, o.version, coalesce(o.content_hash, ''), o.updated_at, o.deleted_at,
+	(SELECT row_to_json(d) FROM (
+		SELECT name, phone, zip, city, address, region, email
+		FROM deliveries WHERE order_refer = o.order_uid
+	) d),
+	(SELECT row_to_json(p) FROM (
+		SELECT "transaction", request_id, currency, provider, amount,
+			payment_dt, bank, delivery_cost, goods_total, custom_fee
+		FROM payments WHERE order_refer = o.order_uid
+	) p),
+	(SELECT json_agg(i ORDER BY i.chrt_id, i.rid) FROM (
+		SELECT chrt_id, track_number, price, rid, name, sale, size,
+			total_price, nm_id, brand, status
+		FROM items WHERE order_refer = o.order_uid
+	) i)
+FROM orders o`
+
+const (
+	lastRevisionSQL   = `SELECT data, version FROM order_revisions WHERE order_uid = $1 ORDER BY id DESC LIMIT 1`
+	insertRevisionSQL = `INSERT INTO order_revisions (order_uid, version, data, diff, changed_at) VALUES ($1, $2, $3, $4, $5)`
+	insertOutboxSQL   = `INSERT INTO order_outbox (type, order_uid, version, created_at) VALUES ($1, $2, $3, $4)`
+	softDeleteSQL     = `UPDATE orders SET deleted_at = now() WHERE order_uid = $1 AND deleted_at IS NULL`
+)
+
+var (
+	pgxInsertOrderSQL     = numbered(insertOrderSQL)
+	pgxUpsertOrderSQL     = numbered(upsertOrderSQL)
+	pgxUpdateOrderSQL     = numbered(updateOrderSQL)
+	pgxOrderStateSQL      = numbered(orderStateSQL)
+	pgxOrderLockSQL       = numbered(orderLockSQL)
+	pgxInsertDeliverySQL  = numbered(insertDeliverySQL)
+	pgxUpsertDeliverySQL  = numbered(upsertDeliverySQL)
+	pgxInsertPaymentSQL   = numbered(insertPaymentSQL)
+	pgxUpsertPaymentSQL   = numbered(upsertPaymentSQL)
+	pgxClaimOffsetSQL     = numbered(claimOffsetSQL)
+	pgxPartitionExistsSQL = numbered(partitionExistsSQL)
+	pgxPartitionLockSQL   = numbered(partitionLockSQL)
+)
+
+// OrderPgxRepo stores orders through pgx with hand-written SQL. It writes
+// the same rows, revisions, outbox events and consumer offsets as
+// OrderPostgresRepo, so both can work on one database; it has no replica
+// routing.
+type OrderPgxRepo struct {
+	pool     *pgxpool.Pool
+	timeouts Timeouts
+	layout   ordersLayout
+	ring     *pii.Keyring
+}
+
+// NewOrderPgx returns a repository on pool. ring, which may be nil, seals
+// personal data the way WithKeyring does for OrderPostgresRepo.
+func NewOrderPgx(pool *pgxpool.Pool, timeouts Timeouts, ring *pii.Keyring) *OrderPgxRepo {
+	return &OrderPgxRepo{pool: pool, timeouts: timeouts, ring: ring}
+}
+
+func (r *OrderPgxRepo) Create(ctx context.Context, o models.Order) error {
+	return mapError(r.write(ctx, o, false, nil))
+}
+
+func (r *OrderPgxRepo) CreateOrUpdate(ctx context.Context, o models.Order, opts ...storage.WriteOption) error {
+	return mapError(r.write(ctx, o, true, storage.NewWriteOptions(opts...).Offset))
+}
+
+// write stores o and its children in one transaction. Statements that do not
+// depend on each other's results are sent as a single batch; the children of
+// an upsert are sent along with the order under a savepoint, which is rolled
+// back when the order turns out unchanged.
+func (r *OrderPgxRepo) write(ctx context.Context, o models.Order, upsert bool, off *storage.Offset) error {
+	unchanged := false
+	err := r.transaction(ctx, r.timeouts.Write, func(tx pgx.Tx) error {
+		partitioned, err := r.partitioned(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		b := &pgx.Batch{}
+		skipped := false
+		if off != nil {
+			b.Queue(pgxClaimOffsetSQL, claimOffsetArgs(*off)...).Exec(expectRows(storage.ErrOffsetProcessed))
+		}
+		switch {
+		case partitioned:
+			if b.Len() > 0 {
+				if err := tx.SendBatch(ctx, b).Close(); err != nil {
+					return err
+				}
+				b = &pgx.Batch{}
+			}
+			if upsert {
+				err = upsertPartitionedOrderPgx(ctx, tx, o)
+			} else if err = ensurePartitionPgx(ctx, tx, o.DateCreated); err == nil {
+				_, err = tx.Exec(ctx, pgxInsertOrderSQL, orderArgs(o)...)
+			}
+			if errors.Is(err, storage.ErrUnchanged) {
+				unchanged = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		case upsert:
+			b.Queue(`SAVEPOINT order_write`)
+			b.Queue(pgxUpsertOrderSQL, orderArgs(o)...).Exec(func(tag pgconn.CommandTag) error {
+				skipped = tag.RowsAffected() == 0
+				return nil
+			})
+		default:
+			b.Queue(pgxInsertOrderSQL, orderArgs(o)...)
+		}
+
+		if d := o.Delivery; d != nil {
+			query := pgxInsertDeliverySQL
+			if upsert {
+				query = pgxUpsertDeliverySQL
+			}
+			args, err := deliveryArgs(r.ring, o.OrderUid, *d)
+			if err != nil {
+				return err
+			}
+			b.Queue(query, args...)
+		}
+		if p := o.Payment; p != nil {
+			query := pgxInsertPaymentSQL
+			if upsert {
+				query = pgxUpsertPaymentSQL
+			}
+			b.Queue(query,
+				o.OrderUid, p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
+				p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+			)
+		}
+		query, args := replaceItemsSQL(o.OrderUid, o.Items)
+		b.Queue(numbered(query), args...)
+		if err := tx.SendBatch(ctx, b).Close(); err != nil {
+			return err
+		}
+		if skipped {
+			if _, err := skippedOrderPgx(ctx, tx, o); !errors.Is(err, storage.ErrUnchanged) {
+				if err == nil {
+					err = storage.ErrStaleVersion
+				}
+				return err
+			}
+			unchanged = true
+			_, err := tx.Exec(ctx, `ROLLBACK TO SAVEPOINT order_write`)
+			return err
+		}
+
+		// Both statements store the order live.
+		o.DeletedAt = nil
+		return recordRevisionPgx(ctx, tx, r.ring, o)
+	})
+	if err == nil && unchanged {
+		return storage.ErrUnchanged
+	}
+	return err
+}
+
+func upsertPartitionedOrderPgx(ctx context.Context, tx pgx.Tx, o models.Order) error {
+	if _, err := tx.Exec(ctx, pgxOrderLockSQL, o.OrderUid); err != nil {
+		return err
+	}
+	if err := ensurePartitionPgx(ctx, tx, o.DateCreated); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, pgxUpdateOrderSQL,
+		o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, o.Version,
+		o.ContentHash, o.OrderUid, o.Version, o.Version, o.ContentHash, o.ContentHash,
+	)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+
+	if exists, err := skippedOrderPgx(ctx, tx, o); exists || err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, pgxInsertOrderSQL, orderArgs(o)...)
+	return err
+}
+
+// skippedOrderPgx is skippedOrder on a pgx transaction.
+func skippedOrderPgx(ctx context.Context, tx pgx.Tx, o models.Order) (bool, error) {
+	var same bool
+	err := tx.QueryRow(ctx, pgxOrderStateSQL, o.ContentHash, o.ContentHash, o.Version, o.OrderUid).Scan(&same)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	case same:
+		return true, storage.ErrUnchanged
+	default:
+		return true, storage.ErrStaleVersion
+	}
+}
+
+func ensurePartitionPgx(ctx context.Context, tx pgx.Tx, t time.Time) error {
+	var exists bool
+	if err := tx.QueryRow(ctx, pgxPartitionExistsSQL, partitionName(t)).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, pgxPartitionLockSQL, partitionLockKey); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, createPartitionSQL(t))
+	return err
+}
+
+// recordRevisionPgx is recordRevision for pgx: the last snapshot, and the
+// stored order when o left a child out, are read in one batch, the revision
+// and the outbox event are written in another.
+func recordRevisionPgx(ctx context.Context, tx pgx.Tx, ring *pii.Keyring, o models.Order) error {
+	var (
+		cur  = o
+		prev orderRevision
+	)
+	reload := o.Delivery == nil || o.Payment == nil
+	b := &pgx.Batch{}
+	if reload {
+		b.Queue(selectOrderSQL+` WHERE o.order_uid = $1 AND o.deleted_at IS NULL`, o.OrderUid).QueryRow(func(row pgx.Row) (err error) {
+			cur, err = scanOrder(row)
+			return err
+		})
+	}
+	b.Queue(lastRevisionSQL, o.OrderUid).QueryRow(func(row pgx.Row) error {
+		if err := row.Scan(&prev.Data, &prev.Version); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		return nil
+	})
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return err
+	}
+
+	if reload {
+		if err := openOrder(ring, &cur); err != nil {
+			return err
+		}
+	}
+	rev, err := newRevision(ring, prev, cur)
+	if err != nil {
+		return err
+	}
+	b = &pgx.Batch{}
+	if rev != nil {
+		b.Queue(insertRevisionSQL, rev.OrderUid, rev.Version, rev.Data, rev.Diff, rev.ChangedAt)
+	}
+	b.Queue(insertOutboxSQL, models.OrderStoredEvent, cur.OrderUid, cur.Version, time.Now().UTC())
+	return tx.SendBatch(ctx, b).Close()
+}
+
+func (r *OrderPgxRepo) Get(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	query := selectOrderSQL + ` WHERE o.order_uid = $1`
+	if !storage.NewReadOptions(opts...).IncludeDeleted {
+		query += ` AND o.deleted_at IS NULL`
+	}
+	o, err := scanOrder(r.pool.QueryRow(ctx, query, uid))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The service tells a missing order by gorm's sentinel.
+		return models.Order{}, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return o, err
+	}
+	return o, openOrder(r.ring, &o)
+}
+
+func (r *OrderPgxRepo) GetAll(ctx context.Context, opts ...storage.ReadOption) ([]models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	query := selectOrderSQL
+	if !storage.NewReadOptions(opts...).IncludeDeleted {
+		query += ` WHERE o.deleted_at IS NULL`
+	}
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err == nil {
+			err = openOrder(r.ring, &o)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Each streams orders through a server-side cursor like the gorm
+// repository does, fetching batchSize orders with their children per round
+// trip.
+func (r *OrderPgxRepo) Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error {
+	n := storage.BatchSize(batchSize)
+	where, args := eachWhere(storage.NewReadOptions(opts...))
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DECLARE `+eachCursor+` NO SCROLL CURSOR FOR `+selectOrderSQL+where+` ORDER BY o.order_uid`, args...); err != nil {
+		return err
+	}
+	for {
+		batch, err := r.eachBatch(ctx, tx, n)
+		if err != nil {
+			return err
+		}
+		for _, o := range batch {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		if len(batch) < n {
+			return tx.Commit(ctx)
+		}
+	}
+}
+
+func (r *OrderPgxRepo) eachBatch(ctx context.Context, tx pgx.Tx, n int) ([]models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	rows, err := tx.Query(ctx, fetchEachSQL(n))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batch := make([]models.Order, 0, n)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err == nil {
+			err = openOrder(r.ring, &o)
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, o)
+	}
+	return batch, rows.Err()
+}
+
+func (r *OrderPgxRepo) Delete(ctx context.Context, uid string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, softDeleteSQL, uid)
+	if err != nil {
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *OrderPgxRepo) HardDelete(ctx context.Context, uid string) error {
+	err := r.transaction(ctx, r.timeouts.Write, func(tx pgx.Tx) error {
+		b := &pgx.Batch{}
+		for _, table := range []string{"items", "deliveries", "payments"} {
+			b.Queue(`DELETE FROM `+table+` WHERE order_refer = $1`, uid)
+		}
+		b.Queue(`DELETE FROM order_revisions WHERE order_uid = $1`, uid)
+		b.Queue(`DELETE FROM orders WHERE order_uid = $1`, uid).Exec(expectRows(gorm.ErrRecordNotFound))
+		return tx.SendBatch(ctx, b).Close()
+	})
+	return mapError(err)
+}
+
+func (r *OrderPgxRepo) partitioned(ctx context.Context, tx pgx.Tx) (bool, error) {
+	return r.layout.isPartitioned(func() (string, error) {
+		var kind string
+		err := tx.QueryRow(ctx, ordersRelkindSQL).Scan(&kind)
+		return kind, err
+	})
+}
+
+// transaction runs fn in a transaction bounded by timeout and commits if fn
+// returns nil.
+func (r *OrderPgxRepo) transaction(ctx context.Context, timeout time.Duration, fn func(tx pgx.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func scanOrder(row pgx.Row) (models.Order, error) {
+	var (
+		o                        models.Order
+		updatedAt                *time.Time
+		delivery, payment, items []byte
+	)
+	if err := row.Scan(
+		&o.OrderUid, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature,
+		&o.CustomerId, &o.DeliveryService, &o.ShardKey, &o.SmId, &o.DateCreated,
+		&o.OofShard, &o.Version, &o.ContentHash, &updatedAt, &o.DeletedAt,
+		&delivery, &payment, &items,
+	); err != nil {
+		return models.Order{}, err
+	}
+	if updatedAt != nil {
+		o.UpdatedAt = *updatedAt
+	}
+
+	if delivery != nil {
+		o.Delivery = &models.Delivery{}
+		if err := json.Unmarshal(delivery, o.Delivery); err != nil {
+			return models.Order{}, err
+		}
+		o.Delivery.OrderRefer = o.OrderUid
+	}
+	if payment != nil {
+		o.Payment = &models.Payment{}
+		if err := json.Unmarshal(payment, o.Payment); err != nil {
+			return models.Order{}, err
+		}
+		o.Payment.OrderRefer = o.OrderUid
+	}
+	if items != nil {
+		if err := json.Unmarshal(items, &o.Items); err != nil {
+			return models.Order{}, err
+		}
+		for i := range o.Items {
+			o.Items[i].OrderRefer = o.OrderUid
+		}
+	}
+	return o, nil
+}
+
+func orderArgs(o models.Order) []interface{} {
+	return []interface{}{
+		o.OrderUid, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, o.Version,
+		o.ContentHash,
+	}
+}
+
+// expectRows fails a batched statement with err when it changed no rows.
+func expectRows(err error) func(pgconn.CommandTag) error {
+	return func(tag pgconn.CommandTag) error {
+		if tag.RowsAffected() == 0 {
+			return err
+		}
+		return nil
+	}
+}
+
+// numbered rewrites the ? placeholders of the queries shared with gorm into
+// the $n placeholders pgx expects.
+func numbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
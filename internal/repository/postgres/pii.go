@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"encoding/json"
+	"strings"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+// piiMigrations add the blind indexes that keep deliveries searchable by
+// email and phone once those columns hold ciphertext.
+var piiMigrations = []string{
+	`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS email_bidx varchar(64)`,
+	`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS phone_bidx varchar(64)`,
+	`CREATE INDEX IF NOT EXISTS idx_deliveries_email_bidx ON deliveries (email_bidx)`,
+	`CREATE INDEX IF NOT EXISTS idx_deliveries_phone_bidx ON deliveries (phone_bidx)`,
+}
+
+// WithKeyring encrypts the personal data of deliveries, and the archived
+// Kafka payloads that carry it, with keys from k.
+func WithKeyring(k *pii.Keyring) Option {
+	return func(r *OrderPostgresRepo) { r.ring = k }
+}
+
+// sealDelivery returns a copy of d with its personal data sealed, and its
+// blind indexes, NULL without a keyring.
+func sealDelivery(ring *pii.Keyring, uid string, d models.Delivery) (models.Delivery, interface{}, interface{}, error) {
+	d.OrderRefer = uid
+	email, phone := nullString(ring.EmailIndex(d.Email)), nullString(ring.PhoneIndex(d.Phone))
+	if err := ring.SealDelivery(&d); err != nil {
+		return models.Delivery{}, nil, nil, err
+	}
+	return d, email, phone, nil
+}
+
+// deliveryArgs are the arguments of insertDeliverySQL and upsertDeliverySQL.
+func deliveryArgs(ring *pii.Keyring, uid string, d models.Delivery) ([]interface{}, error) {
+	d, email, phone, err := sealDelivery(ring, uid, d)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{uid, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email, email, phone}, nil
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func openOrder(ring *pii.Keyring, o *models.Order) error {
+	if o.Delivery == nil {
+		return nil
+	}
+	o.Delivery.OrderRefer = o.OrderUid
+	return ring.OpenDelivery(o.Delivery)
+}
+
+func openOrders(ring *pii.Keyring, orders []models.Order) error {
+	for i := range orders {
+		if err := openOrder(ring, &orders[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSnapshot returns the revision snapshot data with its personal data in
+// clear, for diffing against the next one.
+func openSnapshot(ring *pii.Keyring, data string) ([]byte, error) {
+	if !strings.Contains(data, pii.Prefix) {
+		return []byte(data), nil
+	}
+	var o models.Order
+	if err := json.Unmarshal([]byte(data), &o); err != nil {
+		return nil, err
+	}
+	if err := openOrder(ring, &o); err != nil {
+		return nil, err
+	}
+	return json.Marshal(o)
+}
+
+// sealSnapshot marshals a revision snapshot of o with its personal data
+// sealed like the delivery row it was read from.
+func sealSnapshot(ring *pii.Keyring, o models.Order) ([]byte, error) {
+	if o.Delivery != nil {
+		d, _, _, err := sealDelivery(ring, o.OrderUid, *o.Delivery)
+		if err != nil {
+			return nil, err
+		}
+		o.Delivery = &d
+	}
+	return json.Marshal(o)
+}
+
+// mapChanges rewrites with fn every personal value in a revision diff: the
+// changes of delivery.<column> and the columns of a delivery that appeared
+// or disappeared as a whole.
+func mapChanges(changes map[string]storage.Change, fn func(v, col string) (string, error)) error {
+	apply := func(v any, col string) (any, error) {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return v, nil
+		}
+		return fn(s, col)
+	}
+
+	for _, col := range pii.Columns {
+		c, ok := changes["delivery."+col]
+		if !ok {
+			continue
+		}
+		var err error
+		if c.Old, err = apply(c.Old, col); err != nil {
+			return err
+		}
+		if c.New, err = apply(c.New, col); err != nil {
+			return err
+		}
+		changes["delivery."+col] = c
+	}
+
+	if c, ok := changes["delivery"]; ok {
+		for _, side := range []any{c.Old, c.New} {
+			d, ok := side.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, col := range pii.Columns {
+				v, ok := d[col]
+				if !ok {
+					continue
+				}
+				var err error
+				if d[col], err = apply(v, col); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sealChanges(ring *pii.Keyring, uid string, changes map[string]storage.Change) error {
+	if ring == nil {
+		return nil
+	}
+	return mapChanges(changes, func(v, col string) (string, error) {
+		if pii.Sealed(v) {
+			return v, nil
+		}
+		return ring.Seal([]byte(v), pii.FieldAAD(col, uid))
+	})
+}
+
+func openChanges(ring *pii.Keyring, uid string, changes map[string]storage.Change) error {
+	return mapChanges(changes, func(v, col string) (string, error) {
+		if !pii.Sealed(v) {
+			return v, nil
+		}
+		plain, err := ring.Open(v, pii.FieldAAD(col, uid))
+		return string(plain), err
+	})
+}
+
+// openDiff returns a stored revision diff with its personal data in clear.
+func openDiff(ring *pii.Keyring, uid, diff string) (json.RawMessage, error) {
+	if !strings.Contains(diff, pii.Prefix) {
+		return json.RawMessage(diff), nil
+	}
+	var changes map[string]storage.Change
+	if err := json.Unmarshal([]byte(diff), &changes); err != nil {
+		return nil, err
+	}
+	if err := openChanges(ring, uid, changes); err != nil {
+		return nil, err
+	}
+	return json.Marshal(changes)
+}
@@ -1,25 +1,61 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/sirupsen/logrus"
+)
 
-	"l0-demo/internal/models"
+const (
+	connectBackoffMin = 250 * time.Millisecond
+	connectBackoffMax = 5 * time.Second
 )
 
 type Config struct {
+	URL      string
 	Host     string
 	Port     string
 	Username string
 	Password string
 	DbName   string
 	SslMode  string
+
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	StatementTimeout time.Duration
+	// ConnectRetry bounds how long ConnectDB keeps retrying an unreachable
+	// database; zero means a single attempt.
+	ConnectRetry time.Duration
+
+	PartitionOrders      bool
+	PartitionMonthsAhead int
+
+	// UncheckedColumns names, by table, the columns whose check constraints
+	// ConnectDB leaves out, as validation profiles replace their rules.
+	UncheckedColumns map[string][]string
 }
 
-func ConnectDB(c Config) (*gorm.DB, error) {
-	db, err := gorm.Open("postgres", fmt.Sprintf(
+func (c Config) DSN() string {
+	if c.URL != "" {
+		if c.StatementTimeout <= 0 {
+			return c.URL
+		}
+		sep := "?"
+		if strings.Contains(c.URL, "?") {
+			sep = "&"
+		}
+		return c.URL + sep + "statement_timeout=" + url.QueryEscape(fmt.Sprint(c.StatementTimeout.Milliseconds()))
+	}
+
+	dsn := fmt.Sprintf(
 		"host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
 		c.Host,
 		c.Port,
@@ -27,15 +63,133 @@ func ConnectDB(c Config) (*gorm.DB, error) {
 		c.DbName,
 		c.Password,
 		c.SslMode,
-	))
+	)
+	if c.StatementTimeout > 0 {
+		dsn += fmt.Sprintf(" statement_timeout=%d", c.StatementTimeout.Milliseconds())
+	}
+	return dsn
+}
+
+// ConnectDB brings the schema up to date and opens the pool. Migrations run
+// over a connection of their own without the statement timeout: deduplicating
+// rows, building indexes and moving orders into partitions take as long as
+// the table is large, which no query of the service should.
+func ConnectDB(ctx context.Context, c Config) (*gorm.DB, error) {
+	mc := c
+	mc.StatementTimeout = 0
+	mc.MaxOpenConns, mc.MaxIdleConns = 1, 1
+	mdb, err := connect(ctx, mc)
 	if err != nil {
 		return nil, err
 	}
+	err = migrate(mdb, c)
+	mdb.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return connect(ctx, c)
+}
+
+func migrate(db *gorm.DB, c Config) error {
+	opt := WithoutChecks(c.UncheckedColumns)
+	if err := Migrate(db, opt); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if c.PartitionOrders {
+		return PartitionOrders(db, c.PartitionMonthsAhead, opt)
+	}
+	return nil
+}
 
-	db.AutoMigrate(&models.Order{})
-	db.AutoMigrate(&models.Delivery{})
-	db.AutoMigrate(&models.Payment{})
-	db.AutoMigrate(&models.Item{})
+// ConnectReplica opens a read-only standby. Unlike ConnectDB it does not run
+// migrations, which a standby would reject.
+func ConnectReplica(ctx context.Context, c Config) (*gorm.DB, error) {
+	return connect(ctx, c)
+}
+
+func connect(ctx context.Context, c Config) (*gorm.DB, error) {
+	db, err := open(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
+	if c.MaxOpenConns > 0 {
+		db.DB().SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.DB().SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.DB().SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
 	return db, nil
 }
+
+func open(ctx context.Context, c Config) (*gorm.DB, error) {
+	var db *gorm.DB
+	err := retry(ctx, c.ConnectRetry, func() (err error) {
+		db, err = gorm.Open("postgres", c.DSN())
+		return err
+	})
+	return db, err
+}
+
+// ConnectPgx opens a pgx pool for OrderPgxRepo. It does not migrate, so the
+// schema is expected to be brought up to date by ConnectDB.
+func ConnectPgx(ctx context.Context, c Config) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(c.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxOpenConns > 0 {
+		cfg.MaxConns = int32(c.MaxOpenConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		cfg.MaxConnLifetime = c.ConnMaxLifetime
+	}
+
+	var pool *pgxpool.Pool
+	err = retry(ctx, c.ConnectRetry, func() error {
+		p, err := pgxpool.NewWithConfig(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		if err := p.Ping(ctx); err != nil {
+			p.Close()
+			return err
+		}
+		pool = p
+		return nil
+	})
+	return pool, err
+}
+
+// retry calls attempt with a growing backoff until it succeeds, the window
+// has passed or ctx is done.
+func retry(ctx context.Context, window time.Duration, attempt func() error) error {
+	deadline := time.Now().Add(window)
+	backoff := connectBackoffMin
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("connect after %d attempts: %w", n, err)
+		}
+
+		logrus.Warnf("postgres not ready (attempt %d), retrying in %s: %v", n, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("connect: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > connectBackoffMax {
+			backoff = connectBackoffMax
+		}
+	}
+}
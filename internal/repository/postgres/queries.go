@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"strings"
+
+	"l0-demo/internal/models"
+)
+
+const insertOrderSQL = `
+INSERT INTO orders (
+	order_uid, track_number, entry, locale, internal_signature, customer_id,
+	delivery_service, shard_key, sm_id, date_created, oof_shard, version, content_hash, updated_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, now())`
+
+const upsertOrderSQL = insertOrderSQL + `
+ON CONFLICT (order_uid) DO UPDATE SET
+	track_number       = EXCLUDED.track_number,
+	entry              = EXCLUDED.entry,
+	locale             = EXCLUDED.locale,
+	internal_signature = EXCLUDED.internal_signature,
+	customer_id        = EXCLUDED.customer_id,
+	delivery_service   = EXCLUDED.delivery_service,
+	shard_key          = EXCLUDED.shard_key,
+	sm_id              = EXCLUDED.sm_id,
+	date_created       = EXCLUDED.date_created,
+	oof_shard          = EXCLUDED.oof_shard,
+	version            = EXCLUDED.version,
+	content_hash       = EXCLUDED.content_hash,
+	updated_at         = EXCLUDED.updated_at,
+	deleted_at         = NULL
+WHERE orders.version < EXCLUDED.version
+	OR (orders.version = EXCLUDED.version AND orders.deleted_at IS NULL
+		AND (orders.content_hash IS DISTINCT FROM EXCLUDED.content_hash OR EXCLUDED.content_hash = ''))`
+
+// orderStateSQL tells why an upsert changed no row: it selects whether the
+// stored order has the content hash and at least the version of the skipped
+// one, and selects no row when there is no order at all.
+const orderStateSQL = `
+SELECT coalesce(content_hash, '') = ? AND ? <> '' AND version >= ? AND deleted_at IS NULL
+FROM orders WHERE order_uid = ?`
+
+const orderLockSQL = `SELECT pg_advisory_xact_lock(hashtext(?))`
+
+// updateOrderSQL is the partitioned counterpart of the ON CONFLICT branch of
+// upsertOrderSQL: a partitioned orders table has no unique index on order_uid
+// alone to conflict on. Changing date_created moves the row to another
+// partition.
+const updateOrderSQL = `
+UPDATE orders SET
+	track_number       = ?,
+	entry              = ?,
+	locale             = ?,
+	internal_signature = ?,
+	customer_id        = ?,
+	delivery_service   = ?,
+	shard_key          = ?,
+	sm_id              = ?,
+	date_created       = ?,
+	oof_shard          = ?,
+	version            = ?,
+	content_hash       = ?,
+	updated_at         = now(),
+	deleted_at         = NULL
+WHERE order_uid = ?
+	AND (version < ? OR (version = ? AND deleted_at IS NULL
+		AND (content_hash IS DISTINCT FROM ? OR ? = '')))`
+
+const insertDeliverySQL = `
+INSERT INTO deliveries (order_refer, name, phone, zip, city, address, region, email, email_bidx, phone_bidx)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const upsertDeliverySQL = insertDeliverySQL + `
+ON CONFLICT (order_refer) DO UPDATE SET
+	name       = EXCLUDED.name,
+	phone      = EXCLUDED.phone,
+	zip        = EXCLUDED.zip,
+	city       = EXCLUDED.city,
+	address    = EXCLUDED.address,
+	region     = EXCLUDED.region,
+	email      = EXCLUDED.email,
+	email_bidx = EXCLUDED.email_bidx,
+	phone_bidx = EXCLUDED.phone_bidx`
+
+const insertPaymentSQL = `
+INSERT INTO payments (
+	order_refer, "transaction", request_id, currency, provider, amount,
+	payment_dt, bank, delivery_cost, goods_total, custom_fee
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const upsertPaymentSQL = insertPaymentSQL + `
+ON CONFLICT (order_refer) DO UPDATE SET
+	"transaction" = EXCLUDED."transaction",
+	request_id    = EXCLUDED.request_id,
+	currency      = EXCLUDED.currency,
+	provider      = EXCLUDED.provider,
+	amount        = EXCLUDED.amount,
+	payment_dt    = EXCLUDED.payment_dt,
+	bank          = EXCLUDED.bank,
+	delivery_cost = EXCLUDED.delivery_cost,
+	goods_total   = EXCLUDED.goods_total,
+	custom_fee    = EXCLUDED.custom_fee`
+
+const itemColumns = `order_refer, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status`
+
+// replaceItemsSQL deletes the current items of an order and inserts the new
+// ones in a single statement, so readers never see a half-replaced list.
+func replaceItemsSQL(uid string, items []models.Item) (string, []interface{}) {
+	if len(items) == 0 {
+		return `DELETE FROM items WHERE order_refer = ?`, []interface{}{uid}
+	}
+
+	var b strings.Builder
+	b.WriteString(`WITH removed AS (DELETE FROM items WHERE order_refer = ?) INSERT INTO items (` + itemColumns + `) VALUES `)
+	args := make([]interface{}, 0, 1+len(items)*12)
+	args = append(args, uid)
+	for i, it := range items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			uid, it.ChrtId, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmId, it.Brand, it.Status,
+		)
+	}
+	return b.String(), args
+}
@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+// ReencryptReport counts the rows Reencrypt rewrote.
+type ReencryptReport struct {
+	Deliveries int
+	Revisions  int
+	Messages   int
+}
+
+// Reencrypt brings stored personal data in line with the keyring: values in
+// clear and values sealed with another key than the primary one are sealed
+// with the primary key, and the blind indexes of deliveries are refreshed.
+// It runs in batches of batchSize rows, each in its own transaction, so it
+// can run next to the service and be restarted after a failure. The keys of
+// the values being rewritten must still be in the keyring.
+func (r *OrderPostgresRepo) Reencrypt(ctx context.Context, batchSize int) (ReencryptReport, error) {
+	var (
+		rep ReencryptReport
+		err error
+	)
+	if r.ring == nil {
+		return rep, pii.ErrNoKeyring
+	}
+	n := storage.BatchSize(batchSize)
+
+	if rep.Deliveries, err = r.reencryptDeliveries(ctx, n); err != nil {
+		return rep, err
+	}
+	if rep.Revisions, err = r.reencryptRevisions(ctx, n); err != nil {
+		return rep, err
+	}
+	rep.Messages, err = r.reencryptMessages(ctx, n)
+	return rep, err
+}
+
+func (r *OrderPostgresRepo) reencryptDeliveries(ctx context.Context, n int) (int, error) {
+	count, last := 0, ""
+	for {
+		var batch []models.Delivery
+		err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+			rows, err := tx.Raw(`SELECT order_refer, name, phone, address, email, email_bidx, phone_bidx
+				FROM deliveries WHERE order_refer > ? ORDER BY order_refer LIMIT ? FOR UPDATE`, last, n).Rows()
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			var emailIdx, phoneIdx []sql.NullString
+			for rows.Next() {
+				var (
+					d            models.Delivery
+					email, phone sql.NullString
+				)
+				if err := rows.Scan(&d.OrderRefer, &d.Name, &d.Phone, &d.Address, &d.Email, &email, &phone); err != nil {
+					return err
+				}
+				batch = append(batch, d)
+				emailIdx, phoneIdx = append(emailIdx, email), append(phoneIdx, phone)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			rows.Close()
+
+			for i, d := range batch {
+				current := r.ring.CurrentDelivery(&d)
+				if err := r.ring.OpenDelivery(&d); err != nil {
+					return err
+				}
+				if current && emailIdx[i].String == r.ring.EmailIndex(d.Email) && phoneIdx[i].String == r.ring.PhoneIndex(d.Phone) {
+					continue
+				}
+				sealed, email, phone, err := sealDelivery(r.ring, d.OrderRefer, d)
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(`UPDATE deliveries
+					SET name = ?, phone = ?, address = ?, email = ?, email_bidx = ?, phone_bidx = ?
+					WHERE order_refer = ?`,
+					sealed.Name, sealed.Phone, sealed.Address, sealed.Email, email, phone, d.OrderRefer).Error; err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if len(batch) < n {
+			return count, nil
+		}
+		last = batch[len(batch)-1].OrderRefer
+	}
+}
+
+func (r *OrderPostgresRepo) reencryptRevisions(ctx context.Context, n int) (int, error) {
+	count := 0
+	var last uint
+	for {
+		var batch []orderRevision
+		err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+			if err := tx.Raw(`SELECT id, order_uid, data, diff FROM order_revisions
+				WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE`, last, n).Scan(&batch).Error; err != nil {
+				return err
+			}
+			for _, rev := range batch {
+				data, diff, changed, err := r.resealRevision(rev)
+				if err != nil {
+					return err
+				}
+				if !changed {
+					continue
+				}
+				if err := tx.Exec(`UPDATE order_revisions SET data = ?, diff = ? WHERE id = ?`,
+					data, diff, rev.ID).Error; err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if len(batch) < n {
+			return count, nil
+		}
+		last = batch[len(batch)-1].ID
+	}
+}
+
+// resealRevision returns the snapshot and the diff of rev sealed with the
+// primary key, and whether that changed anything.
+func (r *OrderPostgresRepo) resealRevision(rev orderRevision) (string, string, bool, error) {
+	var o models.Order
+	if err := json.Unmarshal([]byte(rev.Data), &o); err != nil {
+		return "", "", false, err
+	}
+	var changes map[string]storage.Change
+	if err := json.Unmarshal([]byte(rev.Diff), &changes); err != nil {
+		return "", "", false, err
+	}
+
+	stale := o.Delivery != nil && !r.ring.CurrentDelivery(o.Delivery)
+	if err := mapChanges(changes, func(v, _ string) (string, error) {
+		stale = stale || !r.ring.Current(v)
+		return v, nil
+	}); err != nil {
+		return "", "", false, err
+	}
+	if !stale {
+		return "", "", false, nil
+	}
+
+	if err := openOrder(r.ring, &o); err != nil {
+		return "", "", false, err
+	}
+	data, err := sealSnapshot(r.ring, o)
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := openChanges(r.ring, rev.OrderUid, changes); err != nil {
+		return "", "", false, err
+	}
+	if err := sealChanges(r.ring, rev.OrderUid, changes); err != nil {
+		return "", "", false, err
+	}
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return "", "", false, err
+	}
+	return string(data), string(diff), true, nil
+}
+
+func (r *OrderPostgresRepo) reencryptMessages(ctx context.Context, n int) (int, error) {
+	count := 0
+	var last int64
+	for {
+		var batch []rawMessage
+		err := r.transaction(ctx, r.timeouts.Write, func(tx *gorm.DB) error {
+			if err := tx.Raw(`SELECT id, topic, kafka_partition, kafka_offset, payload FROM raw_messages
+				WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE`, last, n).Scan(&batch).Error; err != nil {
+				return err
+			}
+			for _, m := range batch {
+				payload := string(m.Payload)
+				if r.ring.Current(payload) {
+					continue
+				}
+				aad := rawMessageAAD(m.Topic, m.Partition, m.Offset)
+				plain := m.Payload
+				if pii.Sealed(payload) {
+					var err error
+					if plain, err = r.ring.Open(payload, aad); err != nil {
+						return err
+					}
+				}
+				sealed, err := r.ring.Seal(plain, aad)
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(`UPDATE raw_messages SET payload = ? WHERE id = ?`, []byte(sealed), m.ID).Error; err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if len(batch) < n {
+			return count, nil
+		}
+		last = batch[len(batch)-1].ID
+	}
+}
@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"l0-demo/internal/repository/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	replicaCheckInterval = time.Second
+	replicaProbeTimeout  = time.Second
+)
+
+// replicaLagSQL reports how far the replica is behind in seconds. A replica
+// that has replayed everything it received counts as caught up even if the
+// last replayed transaction is old.
+const replicaLagSQL = `SELECT coalesce(
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE extract(epoch FROM now() - pg_last_xact_replay_timestamp()) END, 0)`
+
+type Option func(*OrderPostgresRepo)
+
+// WithReplica sends read-only queries to replica while it answers and lags
+// behind the primary by no more than maxLag.
+func WithReplica(replica *gorm.DB, maxLag time.Duration) Option {
+	return func(r *OrderPostgresRepo) {
+		r.replica = &replicaState{db: replica, maxLag: maxLag, probed: make(chan struct{})}
+	}
+}
+
+// replicaState tracks the health of the replica. The lag is probed in the
+// background at most once per replicaCheckInterval, so reads never queue
+// behind a probe; only the first reads wait for the first probe.
+type replicaState struct {
+	db     *gorm.DB
+	maxLag time.Duration
+
+	healthy   atomic.Bool
+	checkedAt atomic.Int64 // unix nanoseconds of the last probe
+	probing   atomic.Bool
+	probed    chan struct{} // closed once the first probe is done
+	probeOnce sync.Once
+}
+
+func (s *replicaState) usable(ctx context.Context) bool {
+	if time.Since(time.Unix(0, s.checkedAt.Load())) >= replicaCheckInterval && s.probing.CompareAndSwap(false, true) {
+		go s.probe()
+	}
+	select {
+	case <-s.probed:
+		return s.healthy.Load()
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *replicaState) probe() {
+	defer s.probing.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), replicaProbeTimeout)
+	defer cancel()
+
+	var lag float64
+	err := s.db.DB().QueryRowContext(ctx, replicaLagSQL).Scan(&lag)
+	s.healthy.Store(err == nil && time.Duration(lag*float64(time.Second)) <= s.maxLag)
+	s.checkedAt.Store(time.Now().UnixNano())
+	s.probeOnce.Do(func() { close(s.probed) })
+}
+
+func (s *replicaState) markDown() {
+	s.healthy.Store(false)
+	s.checkedAt.Store(time.Now().UnixNano())
+}
+
+// read runs fn against the replica when it is usable and the caller did not
+// ask for the primary, and retries on the primary if the replica fails.
+func (r *OrderPostgresRepo) read(ctx context.Context, opts []storage.ReadOption, fn func(db *gorm.DB) error) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	o := storage.NewReadOptions(opts...)
+	scope := func(db *gorm.DB) *gorm.DB {
+		if o.IncludeDeleted {
+			return db.Unscoped()
+		}
+		return db
+	}
+
+	if r.replica != nil && !o.Primary && r.replica.usable(ctx) {
+		err := fn(scope(bind(ctx, r.replica.db.DB())))
+		if err == nil || gorm.IsRecordNotFoundError(err) || ctx.Err() != nil {
+			return err
+		}
+		r.replica.markDown()
+	}
+	return fn(scope(bind(ctx, r.db.DB())))
+}
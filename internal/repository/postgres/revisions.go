@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+type orderRevision struct {
+	ID        uint      `gorm:"primary_key"`
+	OrderUid  string    `gorm:"type:varchar(19);not null;index:idx_order_revisions_uid_changed_at"`
+	Version   int64     `gorm:"not null"`
+	Data      string    `gorm:"type:jsonb;not null"`
+	Diff      string    `gorm:"type:jsonb;not null"`
+	ChangedAt time.Time `gorm:"not null;index:idx_order_revisions_uid_changed_at"`
+}
+
+func (orderRevision) TableName() string { return "order_revisions" }
+
+// recordRevision appends the order written inside tx to order_revisions
+// together with a diff against the previous snapshot. A write that replaced
+// the delivery, payment and items is snapshotted as given, in clear; one that
+// left a child out kept the stored one and is read back.
+func recordRevision(tx *gorm.DB, ring *pii.Keyring, o models.Order) error {
+	cur := o
+	if o.Delivery == nil || o.Payment == nil {
+		cur = models.Order{}
+		if err := tx.Preload("Delivery").
+			Preload("Payment").
+			Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("chrt_id, rid") }).
+			Where("order_uid = ?", o.OrderUid).
+			First(&cur).Error; err != nil {
+			return err
+		}
+		if err := openOrder(ring, &cur); err != nil {
+			return err
+		}
+	}
+
+	var prev orderRevision
+	err := tx.Where("order_uid = ?", o.OrderUid).Order("id DESC").First(&prev).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	rev, err := newRevision(ring, prev, cur)
+	if err != nil || rev == nil {
+		return err
+	}
+	return tx.Create(rev).Error
+}
+
+// newRevision snapshots cur and diffs it against the previous snapshot, if
+// any. Every new version is recorded, also when nothing changed; it returns
+// nil only for a rewrite of the version of prev that changes nothing. Items
+// are snapshotted by chrt_id and rid, and times in UTC at the precision
+// Postgres keeps, whatever order and zone they came in. cur is given in
+// clear; the snapshot and the diff keep its personal data sealed with ring,
+// and the comparison is made in clear since sealing the same value twice
+// differs.
+func newRevision(ring *pii.Keyring, prev orderRevision, cur models.Order) (*orderRevision, error) {
+	cur.DateCreated = cur.DateCreated.UTC().Truncate(time.Microsecond)
+	if cur.DeletedAt != nil {
+		deleted := cur.DeletedAt.UTC().Truncate(time.Microsecond)
+		cur.DeletedAt = &deleted
+	}
+	cur.Items = append(make([]models.Item, 0, len(cur.Items)), cur.Items...)
+	sort.SliceStable(cur.Items, func(i, j int) bool {
+		a, b := cur.Items[i], cur.Items[j]
+		if a.ChrtId != b.ChrtId {
+			return a.ChrtId < b.ChrtId
+		}
+		return a.Rid < b.Rid
+	})
+	plain, err := json.Marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+	prevPlain, err := openSnapshot(ring, prev.Data)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := storage.DiffJSON(prevPlain, plain)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 && prev.Data != "" && prev.Version == cur.Version {
+		return nil, nil
+	}
+	data, err := sealSnapshot(ring, cur)
+	if err != nil {
+		return nil, err
+	}
+	if err := sealChanges(ring, cur.OrderUid, changes); err != nil {
+		return nil, err
+	}
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &orderRevision{
+		OrderUid:  cur.OrderUid,
+		Version:   cur.Version,
+		Data:      string(data),
+		Diff:      string(diff),
+		ChangedAt: time.Now().UTC(),
+	}, nil
+}
+
+func (r *OrderPostgresRepo) Revisions(ctx context.Context, uid string) ([]models.OrderRevision, error) {
+	var rows []orderRevision
+	if err := r.read(ctx, nil, func(db *gorm.DB) error {
+		rows = nil
+		return db.Where("order_uid = ?", uid).Order("id").Find(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	out := make([]models.OrderRevision, 0, len(rows))
+	for _, row := range rows {
+		diff, err := openDiff(r.ring, row.OrderUid, row.Diff)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, models.OrderRevision{
+			ID:        row.ID,
+			OrderUid:  row.OrderUid,
+			Version:   row.Version,
+			Diff:      diff,
+			ChangedAt: row.ChangedAt,
+		})
+	}
+	return out, nil
+}
+
+func (r *OrderPostgresRepo) GetAsOf(ctx context.Context, uid string, at time.Time) (models.Order, error) {
+	var row orderRevision
+	if err := r.read(ctx, nil, func(db *gorm.DB) error {
+		return db.Where("order_uid = ? AND changed_at <= ?", uid, at).
+			Order("changed_at DESC, id DESC").
+			First(&row).Error
+	}); err != nil {
+		return models.Order{}, err
+	}
+
+	var o models.Order
+	if err := json.Unmarshal([]byte(row.Data), &o); err != nil {
+		return models.Order{}, err
+	}
+	if err := openOrder(r.ring, &o); err != nil {
+		return models.Order{}, err
+	}
+	return o, nil
+}
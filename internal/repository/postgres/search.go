@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"unicode"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+
+	"github.com/jinzhu/gorm"
+)
+
+var searchMigrations = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE items ADD COLUMN IF NOT EXISTS search_tsv tsvector
+		GENERATED ALWAYS AS (to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(brand, ''))) STORED`,
+	`ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS search_tsv tsvector
+		GENERATED ALWAYS AS (to_tsvector('simple',
+			coalesce(` + unlessSealed("name") + `, '') || ' ' || coalesce(city, '') || ' ' ||
+			coalesce(` + unlessSealed("address") + `, ''))) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_items_search_tsv ON items USING GIN (search_tsv)`,
+	`CREATE INDEX IF NOT EXISTS idx_deliveries_search_tsv ON deliveries USING GIN (search_tsv)`,
+	`CREATE INDEX IF NOT EXISTS idx_orders_track_number_trgm ON orders USING GIN (track_number gin_trgm_ops)`,
+}
+
+// unlessSealed keeps encrypted values out of the search vector: their
+// ciphertext would only add noise tokens.
+func unlessSealed(col string) string {
+	return `CASE WHEN ` + col + ` LIKE '` + pii.Prefix + `%' THEN NULL ELSE ` + col + ` END`
+}
+
+// migrateDeliverySearch drops a deliveries search vector generated before
+// it skipped encrypted values, so that searchMigrations add it anew.
+func migrateDeliverySearch(db *gorm.DB) error {
+	var expr string
+	err := db.Raw(`SELECT pg_get_expr(d.adbin, d.adrelid)
+		FROM pg_attrdef d
+		JOIN pg_attribute a ON a.attrelid = d.adrelid AND a.attnum = d.adnum
+		WHERE d.adrelid = 'deliveries'::regclass AND a.attname = 'search_tsv'`).Row().Scan(&expr)
+	if errors.Is(err, sql.ErrNoRows) || strings.Contains(expr, pii.Prefix) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.Exec(`ALTER TABLE deliveries DROP COLUMN search_tsv`).Error
+}
+
+type searchRow struct {
+	Uid   string
+	Rank  float64
+	Total int
+}
+
+// Search ranks orders whose items (name, brand) or delivery (name, city,
+// address) match all words of q as prefixes, or whose track number contains q.
+func (r *OrderPostgresRepo) Search(ctx context.Context, q string, limit, offset int) (models.SearchResult, error) {
+	var res models.SearchResult
+	err := r.read(ctx, nil, func(db *gorm.DB) error {
+		var err error
+		res, err = search(db, q, limit, offset)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	for i := range res.Hits {
+		if err := openOrder(r.ring, &res.Hits[i].Order); err != nil {
+			return models.SearchResult{}, err
+		}
+	}
+	return res, nil
+}
+
+func search(db *gorm.DB, q string, limit, offset int) (models.SearchResult, error) {
+	res := models.SearchResult{Limit: limit, Offset: offset, Hits: []models.SearchHit{}}
+
+	var (
+		branches []string
+		args     []interface{}
+	)
+	if tsq := prefixTsQuery(q); tsq != "" {
+		branches = append(branches,
+			`SELECT order_refer AS uid, ts_rank(search_tsv, to_tsquery('simple', ?)) AS rank
+			FROM items WHERE search_tsv @@ to_tsquery('simple', ?)`,
+			`SELECT order_refer AS uid, ts_rank(search_tsv, to_tsquery('simple', ?)) AS rank
+			FROM deliveries WHERE search_tsv @@ to_tsquery('simple', ?)`,
+		)
+		args = append(args, tsq, tsq, tsq, tsq)
+	}
+	branches = append(branches,
+		`SELECT order_uid AS uid, similarity(track_number, ?) AS rank
+		FROM orders WHERE track_number ILIKE ?`,
+	)
+	args = append(args, q, "%"+escapeLike(q)+"%")
+
+	query := `WITH matches AS (` + strings.Join(branches, " UNION ALL ") + `),
+	ranked AS (
+		SELECT m.uid, max(m.rank) AS rank
+		FROM matches m
+		JOIN orders o ON o.order_uid = m.uid AND o.deleted_at IS NULL
+		GROUP BY m.uid
+	)
+	SELECT uid, rank, count(*) OVER () AS total
+	FROM ranked
+	ORDER BY rank DESC, uid
+	LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	var rows []searchRow
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return res, err
+	}
+	if len(rows) == 0 {
+		return res, nil
+	}
+	res.Total = rows[0].Total
+
+	uids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		uids = append(uids, row.Uid)
+	}
+	var orders []models.Order
+	if err := db.Preload("Delivery").
+		Preload("Payment").
+		Preload("Items").
+		Where("order_uid IN (?)", uids).
+		Find(&orders).Error; err != nil {
+		return res, err
+	}
+	byUID := make(map[string]models.Order, len(orders))
+	for _, o := range orders {
+		byUID[o.OrderUid] = o
+	}
+
+	for _, row := range rows {
+		if o, ok := byUID[row.Uid]; ok {
+			res.Hits = append(res.Hits, models.SearchHit{Rank: row.Rank, Order: o})
+		}
+	}
+	return res, nil
+}
+
+// prefixTsQuery turns free text into a tsquery matching every word as a
+// prefix, e.g. "viv sabo" -> "viv:* & sabo:*".
+func prefixTsQuery(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// FindByContact returns up to limit live orders, newest first, whose
+// delivery has the given email and/or phone. With a keyring it matches the
+// blind indexes, so orders whose rows have not been re-encrypted yet are not
+// found; without one it compares the stored values.
+func (r *OrderPostgresRepo) FindByContact(ctx context.Context, email, phone string, limit int) ([]models.Order, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	switch {
+	case r.ring != nil && email != "":
+		conds, args = append(conds, `email_bidx = ?`), append(args, r.ring.EmailIndex(email))
+	case email != "":
+		conds, args = append(conds, `lower(trim(email)) = ?`), append(args, pii.NormalizeEmail(email))
+	}
+	switch {
+	case r.ring != nil && phone != "":
+		conds, args = append(conds, `phone_bidx = ?`), append(args, r.ring.PhoneIndex(phone))
+	case phone != "":
+		conds, args = append(conds, `regexp_replace(phone, '[^0-9]', '', 'g') = ?`), append(args, pii.NormalizePhone(phone))
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	var orders []models.Order
+	err := r.read(ctx, nil, func(db *gorm.DB) error {
+		orders = nil
+		return db.Preload("Delivery").
+			Preload("Payment").
+			Preload("Items").
+			Where(`order_uid IN (SELECT order_refer FROM deliveries WHERE `+strings.Join(conds, ` AND `)+`)`, args...).
+			Order("date_created DESC, order_uid").
+			Limit(limit).
+			Find(&orders).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, openOrders(r.ring, orders)
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+var statsMigrations = []string{
+	`CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders (date_created)`,
+}
+
+// statsGroupExprs holds the SQL expression of each of
+// storage.StatsGroupings.
+var statsGroupExprs = map[string]string{
+	models.GroupByNone:            `'all'`,
+	models.GroupByDay:             `to_char(date_trunc('day', o.date_created), 'YYYY-MM-DD')`,
+	models.GroupByWeek:            `to_char(date_trunc('week', o.date_created), 'YYYY-MM-DD')`,
+	models.GroupByMonth:           `to_char(date_trunc('month', o.date_created), 'YYYY-MM')`,
+	models.GroupByDeliveryService: `o.delivery_service`,
+	models.GroupByProvider:        `p.provider`,
+	models.GroupByBank:            `p.bank`,
+}
+
+func statsWhere(f models.StatsFilter) (string, []interface{}) {
+	where := `o.deleted_at IS NULL AND o.date_created >= ? AND o.date_created < ?`
+	args := []interface{}{f.From, f.To}
+	if f.Currency != "" {
+		where += ` AND p.currency = ?`
+		args = append(args, f.Currency)
+	}
+	return where, args
+}
+
+func statsGroup(f models.StatsFilter) (string, error) {
+	if !storage.ValidGrouping(f.GroupBy) {
+		return "", fmt.Errorf("unknown grouping %q", f.GroupBy)
+	}
+	g, ok := statsGroupExprs[f.GroupBy]
+	if !ok {
+		return "", fmt.Errorf("grouping %q is not implemented", f.GroupBy)
+	}
+	return g, nil
+}
+
+func (r *OrderPostgresRepo) Revenue(ctx context.Context, f models.StatsFilter) ([]models.RevenueRow, error) {
+	group, err := statsGroup(f)
+	if err != nil {
+		return nil, err
+	}
+	where, args := statsWhere(f)
+
+	out := []models.RevenueRow{}
+	err = r.read(ctx, nil, func(db *gorm.DB) error {
+		out = out[:0]
+		return db.Raw(`SELECT `+group+` AS "group", p.currency,
+			count(*) AS orders,
+			sum(p.amount) AS revenue,
+			sum(p.goods_total) AS goods_total,
+			sum(p.delivery_cost) AS delivery_cost
+		FROM orders o
+		JOIN payments p ON p.order_refer = o.order_uid
+		WHERE `+where+`
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, args...).
+			Scan(&out).Error
+	})
+	return out, err
+}
+
+func (r *OrderPostgresRepo) TopBrands(ctx context.Context, f models.StatsFilter) ([]models.BrandRow, error) {
+	where, args := statsWhere(f)
+	args = append(args, f.Limit)
+
+	out := []models.BrandRow{}
+	err := r.read(ctx, nil, func(db *gorm.DB) error {
+		out = out[:0]
+		return db.Raw(`SELECT i.brand, p.currency,
+			count(*) AS items,
+			count(DISTINCT o.order_uid) AS orders,
+			sum(i.total_price) AS revenue
+		FROM orders o
+		JOIN payments p ON p.order_refer = o.order_uid
+		JOIN items i ON i.order_refer = o.order_uid
+		WHERE `+where+`
+		GROUP BY i.brand, p.currency
+		ORDER BY revenue DESC, i.brand, p.currency
+		LIMIT ?`, args...).
+			Scan(&out).Error
+	})
+	return out, err
+}
+
+func (r *OrderPostgresRepo) BasketSize(ctx context.Context, f models.StatsFilter) ([]models.BasketRow, error) {
+	group, err := statsGroup(f)
+	if err != nil {
+		return nil, err
+	}
+	where, args := statsWhere(f)
+
+	out := []models.BasketRow{}
+	err = r.read(ctx, nil, func(db *gorm.DB) error {
+		out = out[:0]
+		return db.Raw(`SELECT `+group+` AS "group", p.currency,
+			count(*) AS orders,
+			avg(coalesce(ic.items, 0))::float8 AS avg_items,
+			avg(p.amount)::float8 AS avg_amount
+		FROM orders o
+		JOIN payments p ON p.order_refer = o.order_uid
+		LEFT JOIN (SELECT order_refer, count(*) AS items FROM items GROUP BY order_refer) ic
+			ON ic.order_refer = o.order_uid
+		WHERE `+where+`
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, args...).
+			Scan(&out).Error
+	})
+	return out, err
+}
@@ -1,34 +1,126 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"time"
+
 	"l0-demo/internal/models"
 	"l0-demo/internal/repository/cache"
 	"l0-demo/internal/repository/postgres"
+	"l0-demo/internal/repository/sqlite"
+	"l0-demo/internal/repository/storage"
 
 	"github.com/jinzhu/gorm"
 )
 
 type OrderPostgres interface {
-	Create(ord models.Order) error
-	CreateOrUpdate(ord models.Order) error
-	Get(uid string) (models.Order, error)
-	GetAll() ([]models.Order, error)
+	Create(ctx context.Context, ord models.Order) error
+	CreateOrUpdate(ctx context.Context, ord models.Order, opts ...storage.WriteOption) error
+	Get(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error)
+	GetAll(ctx context.Context, opts ...storage.ReadOption) ([]models.Order, error)
+	Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error
+	Delete(ctx context.Context, uid string) error
+	HardDelete(ctx context.Context, uid string) error
+}
+
+type OrderImport interface {
+	CreateOrUpdateBatch(ctx context.Context, orders []models.Order) ([]error, error)
+}
+
+type OrderRevisions interface {
+	Revisions(ctx context.Context, uid string) ([]models.OrderRevision, error)
+	GetAsOf(ctx context.Context, uid string, at time.Time) (models.Order, error)
+}
+
+type OrderSearch interface {
+	Search(ctx context.Context, q string, limit, offset int) (models.SearchResult, error)
+}
+
+type OrderLookup interface {
+	FindByContact(ctx context.Context, email, phone string, limit int) ([]models.Order, error)
+}
+
+type OrderStats interface {
+	Revenue(ctx context.Context, f models.StatsFilter) ([]models.RevenueRow, error)
+	TopBrands(ctx context.Context, f models.StatsFilter) ([]models.BrandRow, error)
+	BasketSize(ctx context.Context, f models.StatsFilter) ([]models.BasketRow, error)
+}
+
+type OrderOutbox interface {
+	RelayOutbox(ctx context.Context, limit int, publish func([]models.OrderEvent) error) (int, error)
+	PurgeOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+type OrderPartitions interface {
+	EnsurePartitions(ctx context.Context, monthsAhead int) error
+	ExpiredPartitions(ctx context.Context, before time.Time) ([]string, error)
+	DropPartition(ctx context.Context, name string, archive storage.Archiver) (int, error)
+}
+
+type OrderMessages interface {
+	SaveRawMessage(ctx context.Context, m models.RawMessage) error
+	RawMessages(ctx context.Context, uid string) ([]models.RawMessage, error)
+}
+
+type CustomerErasure interface {
+	EraseCustomer(ctx context.Context, customerID string) (models.ErasureReceipt, error)
+	ErasureReceipts(ctx context.Context, customerID string) ([]models.ErasureReceipt, error)
+	ErasedOrders(ctx context.Context, uids []string) (map[string]bool, error)
+}
+
+type ConsumerOffsets interface {
+	StoreOffset(ctx context.Context, off storage.Offset) error
+	Offsets(ctx context.Context, group, topic string) (map[int]int64, error)
 }
 
 type OrderCache interface {
-	PutOrder(uid string, order models.Order)
-	GetOrder(uid string) (models.Order, error)
-	GetAllOrders() ([]models.Order, error)
+	PutOrder(ctx context.Context, uid string, order models.Order)
+	GetOrder(ctx context.Context, uid string) (models.Order, error)
+	GetAllOrders(ctx context.Context) ([]models.Order, error)
+	DeleteOrder(ctx context.Context, uid string)
 }
 
 type Repository struct {
 	OrderPostgres
 	OrderCache
+	OrderImport
+	OrderRevisions
+	OrderSearch
+	OrderLookup
+	OrderStats
+	OrderOutbox
+	OrderPartitions
+	OrderMessages
+	CustomerErasure
+	ConsumerOffsets
+}
+
+func NewRepository(db *gorm.DB, opts ...postgres.Option) *Repository {
+	pg := postgres.NewOrderPostgres(db, opts...)
+	return &Repository{
+		OrderPostgres:   pg,
+		OrderCache:      cache.NewOrderCache(cache.NewCache()),
+		OrderImport:     pg,
+		OrderRevisions:  pg,
+		OrderSearch:     pg,
+		OrderLookup:     pg,
+		OrderStats:      pg,
+		OrderOutbox:     pg,
+		OrderPartitions: pg,
+		OrderMessages:   pg,
+		CustomerErasure: pg,
+		ConsumerOffsets: pg,
+	}
 }
 
-func NewRepository(db *gorm.DB) *Repository {
+// NewSqliteRepository stores orders and consumer offsets in SQLite and leaves
+// the features that need Postgres unset.
+func NewSqliteRepository(db *sql.DB) *Repository {
+	s := sqlite.NewOrderSqlite(db)
 	return &Repository{
-		OrderPostgres: postgres.NewOrderPostgres(db),
-		OrderCache:    cache.NewOrderCache(cache.NewCache()),
+		OrderPostgres:   s,
+		OrderCache:      cache.NewOrderCache(cache.NewCache()),
+		ConsumerOffsets: s,
 	}
 }
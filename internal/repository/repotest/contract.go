@@ -0,0 +1,621 @@
+// Package repotest holds the behaviour every order store has to share, so
+// that each implementation runs the same checks against its own database.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+)
+
+// Store mirrors repository.OrderPostgres, which cannot be imported from
+// here without a cycle through the implementations.
+type Store interface {
+	Create(ctx context.Context, ord models.Order) error
+	CreateOrUpdate(ctx context.Context, ord models.Order, opts ...storage.WriteOption) error
+	Get(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error)
+	GetAll(ctx context.Context, opts ...storage.ReadOption) ([]models.Order, error)
+	Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error
+	Delete(ctx context.Context, uid string) error
+	HardDelete(ctx context.Context, uid string) error
+}
+
+type Harness struct {
+	Store Store
+	// Reset empties the order and offset tables before every check.
+	Reset func(t *testing.T)
+	// Count returns how many rows of table (orders, deliveries, payments or
+	// items) belong to the order uid.
+	Count func(t *testing.T, table, uid string) int
+}
+
+// Run checks h.Store against the contract, one subtest per check.
+func Run(t *testing.T, h Harness) {
+	for _, c := range []struct {
+		name string
+		fn   func(t *testing.T, h Harness)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"CreateOrUpdate_InsertThenUpdate", testCreateOrUpdateInsertThenUpdate},
+		{"CreateOrUpdate_WithNilChildren", testCreateOrUpdateWithNilChildren},
+		{"CreateOrUpdate_StoresContentHash", testCreateOrUpdateStoresContentHash},
+		{"CreateOrUpdate_ReportsUnchanged", testCreateOrUpdateReportsUnchanged},
+		{"GetAll", testGetAll},
+		{"Each_StreamsInBatches", testEachStreamsInBatches},
+		{"Each_StopsOnCallbackError", testEachStopsOnCallbackError},
+		{"Each_Matching", testEachMatching},
+		{"CreateOrUpdate_RejectsOlderVersion", testCreateOrUpdateRejectsOlderVersion},
+		{"CreateOrUpdate_RejectsProcessedOffset", testCreateOrUpdateRejectsProcessedOffset},
+		{"Delete_SoftHidesAndHardRemoves", testDeleteSoftHidesAndHardRemoves},
+		{"Delete_SameVersionDoesNotResurrect", testDeleteSameVersionDoesNotResurrect},
+		{"NotFound", testNotFound},
+		{"CreateOrUpdate_ConcurrentSameUID", testCreateOrUpdateConcurrentSameUID},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			h.Reset(t)
+			c.fn(t, h)
+		})
+	}
+}
+
+func testCreateAndGet(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-create-001")
+	in := FullOrder(uid, 2)
+
+	if err := repo.Create(context.Background(), in); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+
+	AssertHeaderEq(t, in, got)
+	if got.Delivery == nil || got.Payment == nil {
+		t.Fatalf("expected non-nil Delivery and Payment, got: %#v", got)
+	}
+	if len(got.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(got.Items))
+	}
+}
+
+func testCreateOrUpdateInsertThenUpdate(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-upsert-001")
+
+	initial := FullOrder(uid, 3)
+	if err := repo.CreateOrUpdate(context.Background(), initial); err != nil {
+		t.Fatalf("CreateOrUpdate(insert) error: %v", err)
+	}
+
+	got1, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get(after insert) error: %v", err)
+	}
+	AssertHeaderEq(t, initial, got1)
+	if len(got1.Items) != 3 {
+		t.Fatalf("expected 3 items after insert, got %d", len(got1.Items))
+	}
+
+	updated := initial
+	updated.TrackNumber = "TRACK-UPDATED0"
+	if updated.Delivery != nil {
+		updated.Delivery.City = "Amsterdam"
+	}
+	if updated.Payment != nil {
+		updated.Payment.Amount = updated.Payment.Amount + 777
+	}
+	updated.Items = []models.Item{
+		NewItem(uid, "SKU-NEW-1", 111),
+		NewItem(uid, "SKU-NEW-2", 222),
+	}
+
+	if err := repo.CreateOrUpdate(context.Background(), updated); err != nil {
+		t.Fatalf("CreateOrUpdate(update) error: %v", err)
+	}
+
+	got2, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get(after update) error: %v", err)
+	}
+
+	if got2.TrackNumber != "TRACK-UPDATED0" {
+		t.Fatalf("expected updated track number, got %s", got2.TrackNumber)
+	}
+	if got2.Delivery == nil || got2.Delivery.City != "Amsterdam" {
+		t.Fatalf("delivery not updated: %#v", got2.Delivery)
+	}
+	if got2.Payment == nil || got2.Payment.Amount != updated.Payment.Amount {
+		t.Fatalf("payment not updated: %#v", got2.Payment)
+	}
+	if len(got2.Items) != 2 {
+		t.Fatalf("expected 2 items after replacement, got %d", len(got2.Items))
+	}
+	expectRIDs := map[string]bool{FixedLen("SKU-NEW-1", 21): true, FixedLen("SKU-NEW-2", 21): true}
+	for _, it := range got2.Items {
+		if !expectRIDs[it.Rid] {
+			t.Fatalf("unexpected item after replacement (Rid check failed): %#v", it)
+		}
+	}
+}
+
+func testCreateOrUpdateStoresContentHash(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-hash-001")
+
+	o := FullOrder(uid, 1)
+	o.Version = 1
+	o.ContentHash = "hash-1"
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(insert) error: %v", err)
+	}
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get(after insert) error: %v", err)
+	}
+	if got.ContentHash != "hash-1" {
+		t.Fatalf("expected content hash hash-1 after insert, got %q", got.ContentHash)
+	}
+
+	o.Version = 2
+	o.ContentHash = "hash-2"
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(update) error: %v", err)
+	}
+	var hashes []string
+	if err := repo.Each(context.Background(), 10, func(o models.Order) error {
+		hashes = append(hashes, o.ContentHash)
+		return nil
+	}); err != nil {
+		t.Fatalf("Each() error: %v", err)
+	}
+	if len(hashes) != 1 || hashes[0] != "hash-2" {
+		t.Fatalf("expected content hash hash-2 after update, got %q", hashes)
+	}
+}
+
+func testCreateOrUpdateReportsUnchanged(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-same-001")
+	off := storage.Offset{Group: "order-svc", Topic: "orders", Partition: 0, Offset: 3}
+
+	o := FullOrder(uid, 1)
+	o.Version = 2
+	o.ContentHash = "hash-1"
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(insert) error: %v", err)
+	}
+
+	if err := repo.CreateOrUpdate(context.Background(), o, storage.WithOffset(off)); !errors.Is(err, storage.ErrUnchanged) {
+		t.Fatalf("expected the same order to be reported unchanged, got %v", err)
+	}
+	if err := repo.CreateOrUpdate(context.Background(), o, storage.WithOffset(off)); !errors.Is(err, storage.ErrOffsetProcessed) {
+		t.Fatalf("expected the offset of the unchanged write to be stored, got %v", err)
+	}
+
+	older := o
+	older.Version = 1
+	if err := repo.CreateOrUpdate(context.Background(), older); !errors.Is(err, storage.ErrUnchanged) {
+		t.Fatalf("expected an older version of the same content to be reported unchanged, got %v", err)
+	}
+	older.ContentHash = "hash-0"
+	if err := repo.CreateOrUpdate(context.Background(), older); !errors.Is(err, storage.ErrStaleVersion) {
+		t.Fatalf("expected an older version of other content to be stale, got %v", err)
+	}
+
+	o.TrackNumber = "TRACK-SAME-002"
+	o.ContentHash = "hash-2"
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(new content) error: %v", err)
+	}
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.TrackNumber != "TRACK-SAME-002" || got.ContentHash != "hash-2" {
+		t.Fatalf("expected new content of the same version to be stored, got %q / %q", got.TrackNumber, got.ContentHash)
+	}
+}
+
+func testCreateOrUpdateWithNilChildren(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-nil-001")
+
+	o := HeaderOnlyOrder(uid)
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(header-only) error: %v", err)
+	}
+
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.Delivery != nil || got.Payment != nil || len(got.Items) != 0 {
+		t.Fatalf("expected no children, got: delivery=%#v payment=%#v items=%d",
+			got.Delivery, got.Payment, len(got.Items))
+	}
+
+	o2 := FullOrder(uid, 1)
+	if err := repo.CreateOrUpdate(context.Background(), o2); err != nil {
+		t.Fatalf("CreateOrUpdate(add-children) error: %v", err)
+	}
+
+	got2, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get(after add children) error: %v", err)
+	}
+	if got2.Delivery == nil || got2.Payment == nil || len(got2.Items) != 1 {
+		t.Fatalf("expected children added, got: delivery=%#v payment=%#v items=%d",
+			got2.Delivery, got2.Payment, len(got2.Items))
+	}
+}
+
+func testGetAll(t *testing.T, h Harness) {
+	repo := h.Store
+	for i := 1; i <= 3; i++ {
+		uid := UID(fmt.Sprintf("order-all-%03d", i))
+		if err := repo.Create(context.Background(), FullOrder(uid, i)); err != nil {
+			t.Fatalf("Create(%s) error: %v", uid, err)
+		}
+	}
+
+	all, err := repo.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("GetAll() error: %v", err)
+	}
+	count := 0
+	for _, o := range all {
+		if len(o.OrderUid) > 0 && (len(o.Items) > 0 || o.Delivery != nil || o.Payment != nil) {
+			count++
+		}
+	}
+	if count < 3 {
+		t.Fatalf("expected at least 3 orders, got %d/%d", count, len(all))
+	}
+}
+
+func testEachStreamsInBatches(t *testing.T, h Harness) {
+	repo := h.Store
+	var want []string
+	items := make(map[string]int)
+	for i := 1; i <= 7; i++ {
+		uid := UID(fmt.Sprintf("order-each-%03d", i))
+		if err := repo.Create(context.Background(), FullOrder(uid, i%3)); err != nil {
+			t.Fatalf("Create(%s) error: %v", uid, err)
+		}
+		want = append(want, uid)
+		items[uid] = i % 3
+	}
+	if err := repo.Delete(context.Background(), want[6]); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+
+	for _, c := range []struct {
+		name  string
+		batch int
+		opts  []storage.ReadOption
+		want  []string
+	}{
+		{"partial last batch", 3, nil, want[:6]},
+		{"exact batches", 2, nil, want[:6]},
+		{"default batch size", 0, nil, want[:6]},
+		{"include deleted", 3, []storage.ReadOption{storage.IncludeDeleted()}, want},
+	} {
+		var got []string
+		err := repo.Each(context.Background(), c.batch, func(o models.Order) error {
+			if len(o.Items) != items[o.OrderUid] {
+				t.Errorf("%s: order %s has %d items", c.name, o.OrderUid, len(o.Items))
+			}
+			if o.Delivery == nil || o.Payment == nil {
+				t.Errorf("%s: order %s lost its delivery or payment", c.name, o.OrderUid)
+			}
+			got = append(got, o.OrderUid)
+			return nil
+		}, c.opts...)
+		if err != nil {
+			t.Fatalf("%s: Each() error: %v", c.name, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func testEachStopsOnCallbackError(t *testing.T, h Harness) {
+	repo := h.Store
+	for i := 1; i <= 5; i++ {
+		uid := UID(fmt.Sprintf("order-stop-%03d", i))
+		if err := repo.Create(context.Background(), FullOrder(uid, 1)); err != nil {
+			t.Fatalf("Create(%s) error: %v", uid, err)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := repo.Each(context.Background(), 2, func(models.Order) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the callback error back unchanged, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected the walk to stop after 3 orders, got %d calls", calls)
+	}
+}
+
+func testEachMatching(t *testing.T, h Harness) {
+	repo := h.Store
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	var uids []string
+	for i, c := range []struct {
+		customer, service string
+		created           time.Time
+	}{
+		{"cus1", "meest", day.Add(-time.Hour)},
+		{"cus1", "meest", day},
+		{"cus1", "dpd00", day.Add(time.Hour)},
+		{"cus2", "meest", day.Add(24 * time.Hour)},
+		{"cus1", "meest", day.Add(2 * time.Hour)},
+	} {
+		o := FullOrder(UID(fmt.Sprintf("order-match-%03d", i)), 1)
+		o.CustomerId, o.DeliveryService, o.DateCreated = c.customer, c.service, c.created
+		if err := repo.Create(context.Background(), o); err != nil {
+			t.Fatalf("Create(%s) error: %v", o.OrderUid, err)
+		}
+		uids = append(uids, o.OrderUid)
+	}
+	if err := repo.Delete(context.Background(), uids[4]); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		filter storage.OrderFilter
+		opts   []storage.ReadOption
+		want   []string
+	}{
+		{"everything", storage.OrderFilter{}, nil, uids[:4]},
+		{"from inclusive", storage.OrderFilter{From: day}, nil, uids[1:4]},
+		{"to exclusive", storage.OrderFilter{To: day.Add(time.Hour)}, nil, uids[:2]},
+		{"other zone", storage.OrderFilter{From: day.In(time.FixedZone("MSK", 3*3600))}, nil, uids[1:4]},
+		{"customer", storage.OrderFilter{CustomerId: "cus2"}, nil, uids[3:4]},
+		{"all fields", storage.OrderFilter{From: day, To: day.Add(24 * time.Hour), CustomerId: "cus1", DeliveryService: "meest"}, nil, uids[1:2]},
+		{"include deleted", storage.OrderFilter{CustomerId: "cus1", DeliveryService: "meest"},
+			[]storage.ReadOption{storage.IncludeDeleted()}, []string{uids[0], uids[1], uids[4]}},
+		{"nothing", storage.OrderFilter{CustomerId: "none"}, nil, nil},
+	} {
+		var got []string
+		err := repo.Each(context.Background(), 2, func(o models.Order) error {
+			got = append(got, o.OrderUid)
+			return nil
+		}, append(c.opts, storage.Matching(c.filter))...)
+		if err != nil {
+			t.Fatalf("%s: Each() error: %v", c.name, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func testCreateOrUpdateRejectsOlderVersion(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-version-001")
+
+	newer := FullOrder(uid, 2)
+	newer.Version = 20
+	newer.TrackNumber = "TRACK-V20-0000"
+	if err := repo.CreateOrUpdate(context.Background(), newer); err != nil {
+		t.Fatalf("CreateOrUpdate(v20) error: %v", err)
+	}
+
+	older := FullOrder(uid, 1)
+	older.Version = 10
+	older.TrackNumber = "TRACK-V10-0000"
+	older.Delivery.City = "Stale City"
+	err := repo.CreateOrUpdate(context.Background(), older)
+	if !errors.Is(err, storage.ErrStaleVersion) {
+		t.Fatalf("expected ErrStaleVersion, got %v", err)
+	}
+
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.Version != 20 || got.TrackNumber != "TRACK-V20-0000" {
+		t.Fatalf("order regressed to older version: version=%d track=%s", got.Version, got.TrackNumber)
+	}
+	if got.Delivery == nil || got.Delivery.City == "Stale City" || len(got.Items) != 2 {
+		t.Fatalf("children regressed to older version: delivery=%#v items=%d", got.Delivery, len(got.Items))
+	}
+
+	same := newer
+	same.TrackNumber = "TRACK-V20-REDL"
+	if err := repo.CreateOrUpdate(context.Background(), same); err != nil {
+		t.Fatalf("CreateOrUpdate(same version) error: %v", err)
+	}
+}
+
+func testDeleteSoftHidesAndHardRemoves(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-delete-001")
+	if err := repo.CreateOrUpdate(context.Background(), FullOrder(uid, 2)); err != nil {
+		t.Fatalf("CreateOrUpdate() error: %v", err)
+	}
+
+	if err := repo.Delete(context.Background(), uid); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := repo.Get(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected soft-deleted order to be hidden, got %v", err)
+	}
+	all, err := repo.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("GetAll() error: %v", err)
+	}
+	for _, o := range all {
+		if o.OrderUid == uid {
+			t.Fatalf("soft-deleted order returned by GetAll")
+		}
+	}
+
+	got, err := repo.Get(context.Background(), uid, storage.IncludeDeleted())
+	if err != nil {
+		t.Fatalf("Get(IncludeDeleted) error: %v", err)
+	}
+	if got.DeletedAt == nil || len(got.Items) != 2 {
+		t.Fatalf("expected soft-deleted order with children, got deleted_at=%v items=%d", got.DeletedAt, len(got.Items))
+	}
+
+	if err := repo.Delete(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found on second soft delete, got %v", err)
+	}
+
+	if err := repo.HardDelete(context.Background(), uid); err != nil {
+		t.Fatalf("HardDelete() error: %v", err)
+	}
+	if _, err := repo.Get(context.Background(), uid, storage.IncludeDeleted()); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected hard-deleted order to be gone, got %v", err)
+	}
+	if n := h.Count(t, "items", uid); n != 0 {
+		t.Fatalf("expected items removed, got n=%d", n)
+	}
+	if err := repo.HardDelete(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found on second hard delete, got %v", err)
+	}
+}
+
+func testDeleteSameVersionDoesNotResurrect(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-delete-002")
+	o := FullOrder(uid, 1)
+	o.Version = 5
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate() error: %v", err)
+	}
+	if err := repo.Delete(context.Background(), uid); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+
+	if err := repo.CreateOrUpdate(context.Background(), o); !errors.Is(err, storage.ErrStaleVersion) {
+		t.Fatalf("expected redelivered version to be stale, got %v", err)
+	}
+
+	o.Version = 6
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate(newer) error: %v", err)
+	}
+	if _, err := repo.Get(context.Background(), uid); err != nil {
+		t.Fatalf("expected newer version to restore the order, got %v", err)
+	}
+}
+
+func testCreateOrUpdateConcurrentSameUID(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-race-001")
+
+	const workers = 16
+	const rounds = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				o := FullOrder(uid, 1+(w+i)%3)
+				o.Payment.Amount = 1000 + w*rounds + i
+				if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("concurrent CreateOrUpdate error: %v", err)
+	}
+
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.Delivery == nil || got.Payment == nil {
+		t.Fatalf("expected delivery and payment, got: %#v", got)
+	}
+	if n := len(got.Items); n < 1 || n > 3 {
+		t.Fatalf("expected items of a single write (1..3), got %d", n)
+	}
+
+	for _, table := range []string{"orders", "deliveries", "payments"} {
+		if n := h.Count(t, table, uid); n != 1 {
+			t.Fatalf("expected exactly 1 row in %s for %s, got %d", table, uid, n)
+		}
+	}
+}
+
+func testCreateOrUpdateRejectsProcessedOffset(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-offset-001")
+	off := storage.Offset{Group: "order-svc", Topic: "orders", Partition: 0, Offset: 7}
+
+	o := FullOrder(uid, 1)
+	o.Version = 1
+	if err := repo.CreateOrUpdate(context.Background(), o, storage.WithOffset(off)); err != nil {
+		t.Fatalf("CreateOrUpdate(offset 7) error: %v", err)
+	}
+
+	o.Version = 2
+	o.TrackNumber = "OFFSET-TRACK-2"
+	if err := repo.CreateOrUpdate(context.Background(), o, storage.WithOffset(off)); !errors.Is(err, storage.ErrOffsetProcessed) {
+		t.Fatalf("expected redelivered offset to be rejected, got %v", err)
+	}
+	got, err := repo.Get(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.Version != 1 {
+		t.Fatalf("expected rejected write to be rolled back, got version %d", got.Version)
+	}
+
+	off.Offset++
+	if err := repo.CreateOrUpdate(context.Background(), o, storage.WithOffset(off)); err != nil {
+		t.Fatalf("CreateOrUpdate(offset 8) error: %v", err)
+	}
+}
+
+func testNotFound(t *testing.T, h Harness) {
+	repo := h.Store
+	uid := UID("order-missing-001")
+
+	if _, err := repo.Get(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found from Get, got %v", err)
+	}
+	if err := repo.Delete(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found from Delete, got %v", err)
+	}
+	if err := repo.HardDelete(context.Background(), uid); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found from HardDelete, got %v", err)
+	}
+	all, err := repo.GetAll(context.Background())
+	if err != nil || len(all) != 0 {
+		t.Fatalf("expected no orders, got %d (err=%v)", len(all), err)
+	}
+}
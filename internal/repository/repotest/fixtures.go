@@ -0,0 +1,137 @@
+package repotest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"l0-demo/internal/models"
+)
+
+// UID pads or cuts a readable fixture name to a valid 19-char order uid.
+func UID(name string) string {
+	return FixedLen(name, 19)
+}
+
+func FixedLen(s string, n int) string {
+	if len(s) >= n {
+		return s[:n]
+	}
+	return s + strings.Repeat("0", n-len(s))
+}
+
+func trunc(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+func HeaderOnlyOrder(uid string) models.Order {
+	return models.Order{
+		OrderUid:          uid,
+		TrackNumber:       FixedLen("TR"+uid, 14),
+		Entry:             "WBIL",
+		Locale:            "ru",
+		InternalSignature: "",
+		CustomerId:        "test",
+		DeliveryService:   "meest",
+		ShardKey:          "9",
+		SmId:              99,
+		DateCreated:       time.Now().UTC(),
+		OofShard:          "1",
+	}
+}
+
+func FullOrder(uid string, items int) models.Order {
+	o := HeaderOnlyOrder(uid)
+
+	o.Delivery = &models.Delivery{
+		OrderRefer: uid,
+		Name:       "John Doe",
+		Phone:      "+100000000",
+		Zip:        "000000",
+		City:       "Moscow",
+		Address:    "Some street, 1",
+		Region:     "RU",
+		Email:      "john@example.com",
+	}
+	o.Payment = &models.Payment{
+		OrderRefer:   uid,
+		Transaction:  "txn-" + trunc(uid, 15),
+		Currency:     "RUB",
+		Provider:     "cash",
+		Amount:       1000,
+		PaymentDt:    int(time.Now().Unix()),
+		Bank:         "SBER",
+		DeliveryCost: 300,
+		GoodsTotal:   700,
+		CustomFee:    0,
+	}
+	for i := 1; i <= items; i++ {
+		o.Items = append(o.Items, NewItem(uid, fmt.Sprintf("SKU-%02d", i), 100*i))
+	}
+
+	return o
+}
+
+func NewItem(orderUID, sku string, price int) models.Item {
+	return models.Item{
+		OrderRefer:  orderUID,
+		ChrtId:      1000 + price,
+		TrackNumber: FixedLen("TN"+orderUID, 14),
+		Price:       price,
+		Rid:         FixedLen(sku, 21),
+		Name:        "Item " + sku,
+		Sale:        30,
+		Size:        "0",
+		TotalPrice:  price,
+		NmId:        1,
+		Brand:       "WB",
+		Status:      202,
+	}
+}
+
+func AssertHeaderEq(t *testing.T, want, got models.Order) {
+	t.Helper()
+	type header = struct {
+		OrderUid          string
+		TrackNumber       string
+		Entry             string
+		Locale            string
+		InternalSignature string
+		CustomerId        string
+		DeliveryService   string
+		ShardKey          string
+		SmId              int
+		OofShard          string
+	}
+	w := header{
+		OrderUid:          want.OrderUid,
+		TrackNumber:       want.TrackNumber,
+		Entry:             want.Entry,
+		Locale:            want.Locale,
+		InternalSignature: want.InternalSignature,
+		CustomerId:        want.CustomerId,
+		DeliveryService:   want.DeliveryService,
+		ShardKey:          want.ShardKey,
+		SmId:              want.SmId,
+		OofShard:          want.OofShard,
+	}
+	g := header{
+		OrderUid:          got.OrderUid,
+		TrackNumber:       got.TrackNumber,
+		Entry:             got.Entry,
+		Locale:            got.Locale,
+		InternalSignature: got.InternalSignature,
+		CustomerId:        got.CustomerId,
+		DeliveryService:   got.DeliveryService,
+		ShardKey:          got.ShardKey,
+		SmId:              got.SmId,
+		OofShard:          got.OofShard,
+	}
+	if w != g {
+		t.Fatalf("order header mismatch:\nwant: %#v\ngot:  %#v", w, g)
+	}
+}
@@ -0,0 +1,462 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+)
+
+const insertOrderSQL = `
+INSERT INTO orders (
+	order_uid, track_number, entry, locale, internal_signature, customer_id,
+	delivery_service, shard_key, sm_id, date_created, oof_shard, version, content_hash, updated_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const upsertOrderSQL = insertOrderSQL + `
+ON CONFLICT (order_uid) DO UPDATE SET
+	track_number       = excluded.track_number,
+	entry              = excluded.entry,
+	locale             = excluded.locale,
+	internal_signature = excluded.internal_signature,
+	customer_id        = excluded.customer_id,
+	delivery_service   = excluded.delivery_service,
+	shard_key          = excluded.shard_key,
+	sm_id              = excluded.sm_id,
+	date_created       = excluded.date_created,
+	oof_shard          = excluded.oof_shard,
+	version            = excluded.version,
+	content_hash       = excluded.content_hash,
+	updated_at         = excluded.updated_at,
+	deleted_at         = NULL
+WHERE orders.version < excluded.version
+	OR (orders.version = excluded.version AND orders.deleted_at IS NULL
+		AND (orders.content_hash <> excluded.content_hash OR excluded.content_hash = ''))`
+
+// orderStateSQL selects whether the stored order has the content hash and at
+// least the version of an order the upsert left alone.
+const orderStateSQL = `
+SELECT content_hash = ? AND ? <> '' AND version >= ? AND deleted_at IS NULL
+FROM orders WHERE order_uid = ?`
+
+const insertDeliverySQL = `
+INSERT INTO deliveries (order_refer, name, phone, zip, city, address, region, email)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+const upsertDeliverySQL = insertDeliverySQL + `
+ON CONFLICT (order_refer) DO UPDATE SET
+	name    = excluded.name,
+	phone   = excluded.phone,
+	zip     = excluded.zip,
+	city    = excluded.city,
+	address = excluded.address,
+	region  = excluded.region,
+	email   = excluded.email`
+
+const insertPaymentSQL = `
+INSERT INTO payments (
+	order_refer, "transaction", request_id, currency, provider, amount,
+	payment_dt, bank, delivery_cost, goods_total, custom_fee
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const upsertPaymentSQL = insertPaymentSQL + `
+ON CONFLICT (order_refer) DO UPDATE SET
+	"transaction" = excluded."transaction",
+	request_id    = excluded.request_id,
+	currency      = excluded.currency,
+	provider      = excluded.provider,
+	amount        = excluded.amount,
+	payment_dt    = excluded.payment_dt,
+	bank          = excluded.bank,
+	delivery_cost = excluded.delivery_cost,
+	goods_total   = excluded.goods_total,
+	custom_fee    = excluded.custom_fee`
+
+const itemColumns = `order_refer, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status`
+
+const claimOffsetSQL = `
+INSERT INTO consumer_offsets (group_id, topic, kafka_partition, next_offset, updated_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (group_id, topic, kafka_partition) DO UPDATE
+SET next_offset = excluded.next_offset, updated_at = excluded.updated_at
+WHERE consumer_offsets.next_offset <= ?`
+
+// selectOrderSQL loads orders with their children in one query, building
+// the delivery, payment and items as JSON like the pgx repository does.
+const selectOrderSQL = `
+SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature,
+	o.customer_id, o.delivery_service, o.shard_key, o.sm_id, o.date_created,
+	o.oof_shard, o.version, o.content_hash, o.updated_at, o.deleted_at,
+	(SELECT json_object('name', name, 'phone', phone, 'zip', zip, 'city', city,
+		'address', address, 'region', region, 'email', email)
+		FROM deliveries WHERE order_refer = o.order_uid),
+	(SELECT json_object('transaction', "transaction", 'request_id', request_id,
+		'currency', currency, 'provider', provider, 'amount', amount,
+		'payment_dt', payment_dt, 'bank', bank, 'delivery_cost', delivery_cost,
+		'goods_total', goods_total, 'custom_fee', custom_fee)
+		FROM payments WHERE order_refer = o.order_uid),
+	(SELECT json_group_array(json_object('chrt_id', chrt_id, 'track_number', track_number,
+		'price', price, 'rid', rid, 'name', name, 'sale', sale, 'size', size,
+		'total_price', total_price, 'nm_id', nm_id, 'brand', brand, 'status', status))
+		FROM items WHERE order_refer = o.order_uid)
+FROM orders o`
+
+// OrderSqliteRepo keeps orders in an embedded SQLite file, for running the
+// service and its tests without Postgres. It stores orders and consumer
+// offsets only; revisions, search, statistics, the outbox and partitions
+// are Postgres features.
+type OrderSqliteRepo struct {
+	db *sql.DB
+}
+
+func NewOrderSqlite(db *sql.DB) *OrderSqliteRepo {
+	return &OrderSqliteRepo{db: db}
+}
+
+func (r *OrderSqliteRepo) Create(ctx context.Context, o models.Order) error {
+	return r.write(ctx, o, false, nil)
+}
+
+func (r *OrderSqliteRepo) CreateOrUpdate(ctx context.Context, o models.Order, opts ...storage.WriteOption) error {
+	return r.write(ctx, o, true, storage.NewWriteOptions(opts...).Offset)
+}
+
+// write stores o and its children in one transaction. An upsert of an order
+// stored already with the same content and at least its version writes only
+// the offset and returns ErrUnchanged.
+func (r *OrderSqliteRepo) write(ctx context.Context, o models.Order, upsert bool, off *storage.Offset) error {
+	unchanged := false
+	err := r.transaction(ctx, func(tx *sql.Tx) error {
+		if off != nil {
+			if err := claimOffset(ctx, tx, *off); err != nil {
+				return err
+			}
+		}
+
+		query := insertOrderSQL
+		if upsert {
+			query = upsertOrderSQL
+		}
+		res, err := tx.ExecContext(ctx, query,
+			o.OrderUid, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+			o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated.UTC(), o.OofShard, o.Version,
+			o.ContentHash, time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			var same bool
+			if err := tx.QueryRowContext(ctx, orderStateSQL,
+				o.ContentHash, o.ContentHash, o.Version, o.OrderUid,
+			).Scan(&same); err != nil {
+				return err
+			}
+			if !same {
+				return storage.ErrStaleVersion
+			}
+			unchanged = true
+			return nil
+		}
+
+		if d := o.Delivery; d != nil {
+			query := insertDeliverySQL
+			if upsert {
+				query = upsertDeliverySQL
+			}
+			if _, err := tx.ExecContext(ctx, query,
+				o.OrderUid, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email,
+			); err != nil {
+				return err
+			}
+		}
+
+		if p := o.Payment; p != nil {
+			query := insertPaymentSQL
+			if upsert {
+				query = upsertPaymentSQL
+			}
+			if _, err := tx.ExecContext(ctx, query,
+				o.OrderUid, p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
+				p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+			); err != nil {
+				return err
+			}
+		}
+
+		return replaceItems(ctx, tx, o.OrderUid, o.Items)
+	})
+	if err == nil && unchanged {
+		return storage.ErrUnchanged
+	}
+	return err
+}
+
+// replaceItems swaps the items of an order inside tx. SQLite has no
+// data-modifying CTEs, but a reader never sees the transaction half done.
+func replaceItems(ctx context.Context, tx *sql.Tx, uid string, items []models.Item) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM items WHERE order_refer = ?`, uid); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(`INSERT INTO items (` + itemColumns + `) VALUES `)
+	args := make([]interface{}, 0, len(items)*12)
+	for i, it := range items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			uid, it.ChrtId, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmId, it.Brand, it.Status,
+		)
+	}
+	_, err := tx.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+func (r *OrderSqliteRepo) Get(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error) {
+	query := selectOrderSQL + ` WHERE o.order_uid = ?`
+	if !storage.NewReadOptions(opts...).IncludeDeleted {
+		query += ` AND o.deleted_at IS NULL`
+	}
+	o, err := scanOrder(r.db.QueryRowContext(ctx, query, uid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Order{}, gorm.ErrRecordNotFound
+	}
+	return o, err
+}
+
+func (r *OrderSqliteRepo) GetAll(ctx context.Context, opts ...storage.ReadOption) ([]models.Order, error) {
+	query := selectOrderSQL
+	if !storage.NewReadOptions(opts...).IncludeDeleted {
+		query += ` WHERE o.deleted_at IS NULL`
+	}
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Each pages through orders by order_uid, batchSize at a time. SQLite has
+// no server-side cursors, and holding one read open for the whole walk
+// would keep the WAL from being checkpointed, so every batch is its own
+// query starting after the last uid seen.
+func (r *OrderSqliteRepo) Each(ctx context.Context, batchSize int, fn func(models.Order) error, opts ...storage.ReadOption) error {
+	n := storage.BatchSize(batchSize)
+	o := storage.NewReadOptions(opts...)
+	query := selectOrderSQL + ` WHERE o.order_uid > ?`
+	if !o.IncludeDeleted {
+		query += ` AND o.deleted_at IS NULL`
+	}
+	cond, filterArgs := o.Filter.SQL(1, func(int) string { return "?" })
+	if cond != "" {
+		query += ` AND ` + cond
+	}
+	query += ` ORDER BY o.order_uid LIMIT ?`
+
+	// args[0] is the last uid seen.
+	args := append(append([]interface{}{""}, filterArgs...), n)
+	for {
+		batch, err := r.page(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for _, ord := range batch {
+			if err := fn(ord); err != nil {
+				return err
+			}
+		}
+		if len(batch) < n {
+			return nil
+		}
+		args[0] = batch[len(batch)-1].OrderUid
+	}
+}
+
+func (r *OrderSqliteRepo) page(ctx context.Context, query string, args ...interface{}) ([]models.Order, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []models.Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, o)
+	}
+	return batch, rows.Err()
+}
+
+func (r *OrderSqliteRepo) Delete(ctx context.Context, uid string) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE orders SET deleted_at = ? WHERE order_uid = ? AND deleted_at IS NULL`,
+		time.Now().UTC(), uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *OrderSqliteRepo) HardDelete(ctx context.Context, uid string) error {
+	return r.transaction(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"items", "deliveries", "payments"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE order_refer = ?`, uid); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM orders WHERE order_uid = ?`, uid)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// StoreOffset records a message that was handled without writing an order.
+// The stored position never moves back.
+func (r *OrderSqliteRepo) StoreOffset(ctx context.Context, off storage.Offset) error {
+	return r.transaction(ctx, func(tx *sql.Tx) error {
+		if err := claimOffset(ctx, tx, off); err != nil && !errors.Is(err, storage.ErrOffsetProcessed) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *OrderSqliteRepo) Offsets(ctx context.Context, group, topic string) (map[int]int64, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT kafka_partition, next_offset FROM consumer_offsets WHERE group_id = ? AND topic = ?`,
+		group, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]int64)
+	for rows.Next() {
+		var (
+			partition int
+			next      int64
+		)
+		if err := rows.Scan(&partition, &next); err != nil {
+			return nil, err
+		}
+		out[partition] = next
+	}
+	return out, rows.Err()
+}
+
+func claimOffset(ctx context.Context, tx *sql.Tx, off storage.Offset) error {
+	res, err := tx.ExecContext(ctx, claimOffsetSQL,
+		off.Group, off.Topic, off.Partition, off.Offset+1, time.Now().UTC(), off.Offset)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storage.ErrOffsetProcessed
+	}
+	return nil
+}
+
+func (r *OrderSqliteRepo) transaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner) (models.Order, error) {
+	var (
+		o                 models.Order
+		updatedAt         sql.NullTime
+		delivery, payment sql.NullString
+		items             string
+	)
+	if err := row.Scan(
+		&o.OrderUid, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature,
+		&o.CustomerId, &o.DeliveryService, &o.ShardKey, &o.SmId, &o.DateCreated,
+		&o.OofShard, &o.Version, &o.ContentHash, &updatedAt, &o.DeletedAt,
+		&delivery, &payment, &items,
+	); err != nil {
+		return models.Order{}, err
+	}
+	o.UpdatedAt = updatedAt.Time
+
+	if delivery.Valid {
+		o.Delivery = &models.Delivery{}
+		if err := json.Unmarshal([]byte(delivery.String), o.Delivery); err != nil {
+			return models.Order{}, err
+		}
+		o.Delivery.OrderRefer = o.OrderUid
+	}
+	if payment.Valid {
+		o.Payment = &models.Payment{}
+		if err := json.Unmarshal([]byte(payment.String), o.Payment); err != nil {
+			return models.Order{}, err
+		}
+		o.Payment.OrderRefer = o.OrderUid
+	}
+	if err := json.Unmarshal([]byte(items), &o.Items); err != nil {
+		return models.Order{}, err
+	}
+	if len(o.Items) == 0 {
+		o.Items = nil
+	}
+	for i := range o.Items {
+		o.Items[i].OrderRefer = o.OrderUid
+	}
+	return o, nil
+}
@@ -0,0 +1,134 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"l0-demo/internal/repository/repotest"
+	"l0-demo/internal/repository/sqlite"
+	"l0-demo/internal/repository/storage"
+)
+
+func TestOrderStoreContract(t *testing.T) {
+	db, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer db.Close()
+
+	repotest.Run(t, repotest.Harness{
+		Store: sqlite.NewOrderSqlite(db),
+		Reset: func(t *testing.T) {
+			for _, table := range []string{"items", "deliveries", "payments", "orders", "consumer_offsets"} {
+				if _, err := db.Exec(`DELETE FROM ` + table); err != nil {
+					t.Fatalf("reset %s: %v", table, err)
+				}
+			}
+		},
+		Count: func(t *testing.T, table, uid string) int {
+			col := "order_refer"
+			if table == "orders" {
+				col = "order_uid"
+			}
+			var n int
+			if err := db.QueryRow(`SELECT count(*) FROM `+table+` WHERE `+col+` = ?`, uid).Scan(&n); err != nil {
+				t.Fatalf("count %s: %v", table, err)
+			}
+			return n
+		},
+	})
+}
+
+func TestOpen_ReopensExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := sqlite.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	o := repotest.FullOrder(repotest.UID("sqlite-reopen-01"), 2)
+	if err := sqlite.NewOrderSqlite(db).CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate() error: %v", err)
+	}
+	db.Close()
+
+	db, err = sqlite.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	defer db.Close()
+	got, err := sqlite.NewOrderSqlite(db).Get(context.Background(), o.OrderUid)
+	if err != nil {
+		t.Fatalf("Get() after reopen error: %v", err)
+	}
+	if !got.DateCreated.Equal(o.DateCreated) || !reflect.DeepEqual(got.Items, o.Items) || *got.Payment != *o.Payment {
+		t.Fatalf("order changed across reopen:\nwant %+v\ngot  %+v", o, got)
+	}
+}
+
+func TestOpen_AddsMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	if _, err := old.Exec(`CREATE TABLE orders (
+		order_uid TEXT PRIMARY KEY, track_number TEXT NOT NULL, entry TEXT NOT NULL,
+		locale TEXT NOT NULL, internal_signature TEXT NOT NULL DEFAULT '',
+		customer_id TEXT NOT NULL, delivery_service TEXT NOT NULL,
+		shard_key TEXT NOT NULL DEFAULT '', sm_id INTEGER NOT NULL,
+		date_created DATETIME NOT NULL, oof_shard TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0, updated_at DATETIME, deleted_at DATETIME
+	)`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	old.Close()
+
+	db, err := sqlite.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer db.Close()
+	o := repotest.FullOrder(repotest.UID("sqlite-column-01"), 1)
+	o.ContentHash = "hash"
+	repo := sqlite.NewOrderSqlite(db)
+	if err := repo.CreateOrUpdate(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrUpdate() error: %v", err)
+	}
+	got, err := repo.Get(context.Background(), o.OrderUid)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.ContentHash != "hash" {
+		t.Fatalf("expected content hash to round-trip, got %q", got.ContentHash)
+	}
+}
+
+func TestOffsets_NeverMoveBack(t *testing.T) {
+	db, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer db.Close()
+	repo := sqlite.NewOrderSqlite(db)
+
+	off := storage.Offset{Group: "order-svc", Topic: "orders", Partition: 3, Offset: 41}
+	if err := repo.StoreOffset(context.Background(), off); err != nil {
+		t.Fatalf("StoreOffset() error: %v", err)
+	}
+	older := off
+	older.Offset = 10
+	if err := repo.StoreOffset(context.Background(), older); err != nil {
+		t.Fatalf("StoreOffset(older) error: %v", err)
+	}
+
+	offsets, err := repo.Offsets(context.Background(), "order-svc", "orders")
+	if err != nil {
+		t.Fatalf("Offsets() error: %v", err)
+	}
+	if want := map[int]int64{3: 42}; !reflect.DeepEqual(offsets, want) {
+		t.Fatalf("expected offsets %v, got %v", want, offsets)
+	}
+}
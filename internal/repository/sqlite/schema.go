@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// schema follows the tables the Postgres migrations create, so rows look the
+// same whichever backend stored them.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS orders (
+		order_uid          TEXT PRIMARY KEY,
+		track_number       TEXT NOT NULL,
+		entry              TEXT NOT NULL,
+		locale             TEXT NOT NULL,
+		internal_signature TEXT NOT NULL DEFAULT '',
+		customer_id        TEXT NOT NULL,
+		delivery_service   TEXT NOT NULL,
+		shard_key          TEXT NOT NULL DEFAULT '',
+		sm_id              INTEGER NOT NULL,
+		date_created       DATETIME NOT NULL,
+		oof_shard          TEXT NOT NULL,
+		version            INTEGER NOT NULL DEFAULT 0,
+		content_hash       TEXT NOT NULL DEFAULT '',
+		updated_at         DATETIME,
+		deleted_at         DATETIME
+	)`,
+	`CREATE TABLE IF NOT EXISTS deliveries (
+		order_refer TEXT NOT NULL UNIQUE REFERENCES orders (order_uid) ON DELETE CASCADE,
+		name        TEXT NOT NULL,
+		phone       TEXT NOT NULL,
+		zip         TEXT NOT NULL,
+		city        TEXT NOT NULL,
+		address     TEXT NOT NULL,
+		region      TEXT NOT NULL,
+		email       TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS payments (
+		order_refer   TEXT NOT NULL UNIQUE REFERENCES orders (order_uid) ON DELETE CASCADE,
+		"transaction" TEXT NOT NULL,
+		request_id    TEXT NOT NULL DEFAULT '',
+		currency      TEXT NOT NULL,
+		provider      TEXT NOT NULL,
+		amount        INTEGER NOT NULL,
+		payment_dt    INTEGER NOT NULL,
+		bank          TEXT NOT NULL,
+		delivery_cost INTEGER NOT NULL,
+		goods_total   INTEGER NOT NULL,
+		custom_fee    INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS items (
+		order_refer  TEXT NOT NULL REFERENCES orders (order_uid) ON DELETE CASCADE,
+		chrt_id      INTEGER NOT NULL,
+		track_number TEXT NOT NULL,
+		price        INTEGER NOT NULL,
+		rid          TEXT NOT NULL,
+		name         TEXT NOT NULL,
+		sale         INTEGER NOT NULL,
+		size         TEXT NOT NULL,
+		total_price  INTEGER NOT NULL,
+		nm_id        INTEGER NOT NULL,
+		brand        TEXT NOT NULL,
+		status       INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_items_order_refer ON items (order_refer)`,
+	`CREATE TABLE IF NOT EXISTS consumer_offsets (
+		group_id        TEXT NOT NULL,
+		topic           TEXT NOT NULL,
+		kafka_partition INTEGER NOT NULL,
+		next_offset     INTEGER NOT NULL,
+		updated_at      DATETIME NOT NULL,
+		PRIMARY KEY (group_id, topic, kafka_partition)
+	)`,
+}
+
+// columns were added to the tables after their first release. CREATE TABLE
+// IF NOT EXISTS leaves the tables of an older file as they are, so the
+// columns missing there are added one by one.
+var columns = []struct{ table, name, def string }{
+	{"orders", "content_hash", `TEXT NOT NULL DEFAULT ''`},
+}
+
+// Open opens or creates the database file at path and brings its schema up
+// to date. Writers wait for each other instead of failing while the file is
+// locked.
+func Open(ctx context.Context, path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Set("_busy_timeout", "5000")
+	params.Set("_foreign_keys", "on")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return db, nil
+}
+
+func Migrate(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	for _, c := range columns {
+		var n int
+		if err := db.QueryRowContext(ctx,
+			`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.name,
+		).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE `+c.table+` ADD COLUMN `+c.name+` `+c.def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package storage
+
+import "l0-demo/internal/models"
+
+// Archiver receives the orders of a partition before it is dropped. Close is
+// called before the drop commits, and an error from either method aborts it.
+type Archiver interface {
+	Archive(o models.Order) error
+	Close() error
+}
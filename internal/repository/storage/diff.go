@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Change is a single field difference between two JSON documents.
+type Change struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// DiffJSON compares two JSON documents and returns an object keyed by field
+// path (e.g. "delivery.city", "items[0].price") with the old and new values.
+// An empty prev is treated as an empty object.
+func DiffJSON(prev, next []byte) (map[string]Change, error) {
+	var a, b any
+	if len(prev) > 0 {
+		if err := json.Unmarshal(prev, &a); err != nil {
+			return nil, fmt.Errorf("diff: decode previous: %w", err)
+		}
+	}
+	if err := json.Unmarshal(next, &b); err != nil {
+		return nil, fmt.Errorf("diff: decode next: %w", err)
+	}
+
+	out := make(map[string]Change)
+	diffValue("", a, b, out)
+	return out, nil
+}
+
+func diffValue(path string, a, b any, out map[string]Change) {
+	switch bv := b.(type) {
+	case map[string]any:
+		av, ok := a.(map[string]any)
+		if !ok {
+			if path != "" {
+				out[path] = Change{Old: a, New: b}
+				return
+			}
+			av = map[string]any{}
+		}
+		for k, v := range bv {
+			diffValue(join(path, k), av[k], v, out)
+		}
+		for k, v := range av {
+			if _, ok := bv[k]; !ok {
+				out[join(path, k)] = Change{Old: v, New: nil}
+			}
+		}
+	case []any:
+		av, ok := a.([]any)
+		if !ok {
+			out[path] = Change{Old: a, New: b}
+			return
+		}
+		n := len(av)
+		if len(bv) > n {
+			n = len(bv)
+		}
+		for i := 0; i < n; i++ {
+			var x, y any
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			diffValue(fmt.Sprintf("%s[%d]", path, i), x, y, out)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			out[path] = Change{Old: a, New: b}
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/repository/storage"
+)
+
+func TestDiffJSON_FromEmpty(t *testing.T) {
+	diff, err := storage.DiffJSON(nil, []byte(`{"order_uid":"u1","delivery":{"city":"Moscow"}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]storage.Change{
+		"order_uid": {Old: nil, New: "u1"},
+		"delivery":  {Old: nil, New: map[string]any{"city": "Moscow"}},
+	}, diff)
+}
+
+func TestDiffJSON_NestedAndArrays(t *testing.T) {
+	prev := []byte(`{"track_number":"T1","delivery":{"city":"Moscow","zip":"1"},"payment":null,
+		"items":[{"rid":"a","price":1},{"rid":"b","price":2}]}`)
+	next := []byte(`{"track_number":"T1","delivery":{"city":"Amsterdam","zip":"1"},"payment":{"amount":5},
+		"items":[{"rid":"a","price":3}]}`)
+
+	diff, err := storage.DiffJSON(prev, next)
+	require.NoError(t, err)
+	require.Equal(t, map[string]storage.Change{
+		"delivery.city":  {Old: "Moscow", New: "Amsterdam"},
+		"payment":        {Old: nil, New: map[string]any{"amount": float64(5)}},
+		"items[0].price": {Old: float64(1), New: float64(3)},
+		"items[1]":       {Old: map[string]any{"rid": "b", "price": float64(2)}, New: nil},
+	}, diff)
+}
+
+func TestDiffJSON_Equal_Empty(t *testing.T) {
+	doc := []byte(`{"a":1,"b":[1,2],"c":{"d":"e"}}`)
+	diff, err := storage.DiffJSON(doc, doc)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+}
+
+func TestDiffJSON_InvalidInput(t *testing.T) {
+	_, err := storage.DiffJSON([]byte(`{`), []byte(`{}`))
+	require.Error(t, err)
+	_, err = storage.DiffJSON(nil, []byte(`nope`))
+	require.Error(t, err)
+}
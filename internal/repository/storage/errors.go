@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStaleVersion is returned by writes whose order version is older than
+// the one already stored.
+var ErrStaleVersion = errors.New("stale order version")
+
+// ErrUnchanged is returned by upserts of an order that is already stored
+// with the same content hash and at least its version. Nothing is written,
+// but an offset passed along is still stored.
+var ErrUnchanged = errors.New("order unchanged")
+
+// ErrOffsetProcessed is returned by writes carrying a message offset that was
+// already stored, i.e. the message has been applied before.
+var ErrOffsetProcessed = errors.New("offset already processed")
+
+// ErrConstraint is wrapped by every error the database returns for a write
+// that breaks one of its constraints. The kind-specific errors below wrap it,
+// so callers can match either a specific kind or any violation.
+var ErrConstraint = errors.New("constraint violation")
+
+var (
+	ErrNotNull    = fmt.Errorf("%w: not null", ErrConstraint)
+	ErrForeignKey = fmt.Errorf("%w: foreign key", ErrConstraint)
+	ErrUnique     = fmt.Errorf("%w: unique", ErrConstraint)
+	ErrCheck      = fmt.Errorf("%w: check", ErrConstraint)
+)
+
+type ConstraintError struct {
+	Kind       error
+	Table      string
+	Column     string
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	where := e.Table
+	if e.Column != "" {
+		where += "." + e.Column
+	}
+	if e.Constraint != "" {
+		where += " (" + e.Constraint + ")"
+	}
+	return fmt.Sprintf("%v on %s: %v", e.Kind, where, e.Err)
+}
+
+func (e *ConstraintError) Unwrap() []error { return []error{e.Kind, e.Err} }
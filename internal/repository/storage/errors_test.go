@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/repository/storage"
+)
+
+func TestConstraintError_MatchesKindAndCause(t *testing.T) {
+	cause := errors.New("pq: insert or update on table \"items\" violates foreign key constraint")
+	err := error(&storage.ConstraintError{Kind: storage.ErrForeignKey, Table: "items", Column: "order_refer", Constraint: "fk_items_order", Err: cause})
+
+	require.ErrorIs(t, err, storage.ErrForeignKey)
+	require.ErrorIs(t, err, storage.ErrConstraint)
+	require.ErrorIs(t, err, cause)
+	require.NotErrorIs(t, err, storage.ErrCheck)
+	require.Equal(t, "constraint violation: foreign key on items.order_refer (fk_items_order): "+cause.Error(), err.Error())
+
+	var ce *storage.ConstraintError
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, "fk_items_order", ce.Constraint)
+}
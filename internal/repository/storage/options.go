@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+type ReadOptions struct {
+	IncludeDeleted bool
+	Primary        bool
+	Filter         OrderFilter
+}
+
+type ReadOption func(*ReadOptions)
+
+// IncludeDeleted makes reads return soft-deleted orders as well.
+func IncludeDeleted() ReadOption { return func(o *ReadOptions) { o.IncludeDeleted = true } }
+
+// Primary makes reads skip the replica, e.g. to read an order right after
+// writing it.
+func Primary() ReadOption { return func(o *ReadOptions) { o.Primary = true } }
+
+// Matching makes Each walk only the orders that match f. Other reads ignore
+// it.
+func Matching(f OrderFilter) ReadOption { return func(o *ReadOptions) { o.Filter = f } }
+
+func NewReadOptions(opts ...ReadOption) ReadOptions {
+	var o ReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// OrderFilter selects orders by creation time, customer and delivery
+// service. Zero fields match every order; From is inclusive and To is
+// exclusive.
+type OrderFilter struct {
+	From            time.Time
+	To              time.Time
+	CustomerId      string
+	DeliveryService string
+}
+
+// SQL returns the conditions on the orders table aliased as o that select
+// the orders matching f, joined with AND, and their arguments; "" when f
+// matches everything. param renders the placeholder of the n-th argument,
+// where first is the number of the first one.
+func (f OrderFilter) SQL(first int, param func(n int) string) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond+param(first+len(args)))
+		args = append(args, arg)
+	}
+	if !f.From.IsZero() {
+		add(`o.date_created >= `, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add(`o.date_created < `, f.To.UTC())
+	}
+	if f.CustomerId != "" {
+		add(`o.customer_id = `, f.CustomerId)
+	}
+	if f.DeliveryService != "" {
+		add(`o.delivery_service = `, f.DeliveryService)
+	}
+	return strings.Join(conds, ` AND `), args
+}
+
+// Offset is the position of a consumed message for a consumer group.
+type Offset struct {
+	Group     string
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+type WriteOptions struct {
+	Offset *Offset
+}
+
+type WriteOption func(*WriteOptions)
+
+// WithOffset stores the offset of the message being applied in the same
+// transaction as the write. The write fails with ErrOffsetProcessed if the
+// stored position is already past it.
+func WithOffset(off Offset) WriteOption { return func(o *WriteOptions) { o.Offset = &off } }
+
+func NewWriteOptions(opts ...WriteOption) WriteOptions {
+	var o WriteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// DefaultBatchSize is the number of orders Each loads at a time when the
+// caller asks for a batch size of zero or less.
+const DefaultBatchSize = 500
+
+// BatchSize returns n, or DefaultBatchSize if n is not positive.
+func BatchSize(n int) int {
+	if n <= 0 {
+		return DefaultBatchSize
+	}
+	return n
+}
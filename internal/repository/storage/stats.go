@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"slices"
+
+	"l0-demo/internal/models"
+)
+
+// StatsGroupings lists the values of StatsFilter.GroupBy that every
+// statistics store has to support.
+var StatsGroupings = []string{
+	models.GroupByNone,
+	models.GroupByDay,
+	models.GroupByWeek,
+	models.GroupByMonth,
+	models.GroupByDeliveryService,
+	models.GroupByProvider,
+	models.GroupByBank,
+}
+
+// ValidGrouping reports whether g is one of StatsGroupings.
+func ValidGrouping(g string) bool {
+	return slices.Contains(StatsGroupings, g)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// EraseCustomer erases the personal data of every order of a customer in
+// the database and replaces the cached orders with the erased ones. Running
+// it again is safe: it only rewrites what is not erased yet, e.g. orders
+// that arrived in between, and otherwise returns the last receipt.
+func (s *Service) EraseCustomer(ctx context.Context, customerID string) (models.ErasureReceipt, error) {
+	if s.CustomerErasure == nil {
+		return models.ErasureReceipt{}, ErrUnsupported
+	}
+	customerID = strings.TrimSpace(customerID)
+	if customerID == "" {
+		return models.ErasureReceipt{}, &ValidationError{Errors: []FieldError{{Field: "customer_id", Rule: "required", Message: "is required"}}}
+	}
+
+	rec, err := s.CustomerErasure.EraseCustomer(ctx, customerID)
+	if err != nil {
+		return rec, err
+	}
+
+	uids := append([]string(nil), rec.OrderUids...)
+	cached, err := s.OrderCache.GetAllOrders(ctx)
+	if err != nil {
+		return rec, err
+	}
+	for _, o := range cached {
+		if o.CustomerId == customerID {
+			uids = append(uids, o.OrderUid)
+		}
+	}
+	for _, uid := range uids {
+		if err := s.refreshCachedOrder(ctx, uid); err != nil {
+			return rec, err
+		}
+	}
+
+	logrus.WithField("customer_id", customerID).WithField("orders", len(rec.OrderUids)).
+		WithField("receipt", rec.ID).Info("customer data erased")
+	return rec, nil
+}
+
+// refreshCachedOrder replaces a cached order with the stored one, or evicts
+// it when the order is no longer live.
+func (s *Service) refreshCachedOrder(ctx context.Context, uid string) error {
+	s.OrderCache.DeleteOrder(ctx, uid)
+	o, err := s.OrderPostgres.Get(ctx, uid)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.OrderCache.PutOrder(ctx, uid, o)
+	return nil
+}
+
+// scrubErased replaces the personal data of the orders that have been erased
+// with placeholders, so that a redelivered, replayed or imported copy cannot
+// store it again. Without erasure support there is nothing to scrub.
+func (s *Service) scrubErased(ctx context.Context, orders []models.Order) error {
+	if s.CustomerErasure == nil {
+		return nil
+	}
+	uids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		if o.Delivery != nil {
+			uids = append(uids, o.OrderUid)
+		}
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	erased, err := s.CustomerErasure.ErasedOrders(ctx, uids)
+	if err != nil {
+		return err
+	}
+	for i, o := range orders {
+		if o.Delivery == nil || !erased[o.OrderUid] {
+			continue
+		}
+		d := *o.Delivery
+		pii.EraseDelivery(&d)
+		orders[i].Delivery = &d
+		logrus.WithField("uid", o.OrderUid).Info("personal data of an erased order scrubbed")
+	}
+	return nil
+}
+
+func (s *Service) GetErasureReceipts(ctx context.Context, customerID string) ([]models.ErasureReceipt, error) {
+	if s.CustomerErasure == nil {
+		return nil, ErrUnsupported
+	}
+	receipts, err := s.CustomerErasure.ErasureReceipts(ctx, strings.TrimSpace(customerID))
+	if err != nil {
+		return nil, err
+	}
+	if len(receipts) == 0 {
+		return nil, ErrNotFound
+	}
+	return receipts, nil
+}
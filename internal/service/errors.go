@@ -2,9 +2,13 @@ package service
 
 import "errors"
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrUnsupported = errors.New("not supported by the configured storage")
+)
 
 var (
 	ErrDecode     = errors.New("decode")
 	ErrValidation = errors.New("validation")
+	ErrStale      = errors.New("stale")
 )
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"l0-demo/internal/models"
+)
+
+func (s *Service) RelayOrderEvents(ctx context.Context, limit int, publish func([]models.OrderEvent) error) (int, error) {
+	if s.OrderOutbox == nil {
+		return 0, ErrUnsupported
+	}
+	return s.OrderOutbox.RelayOutbox(ctx, limit, publish)
+}
+
+func (s *Service) PurgeOrderEvents(ctx context.Context, before time.Time) (int64, error) {
+	if s.OrderOutbox == nil {
+		return 0, ErrUnsupported
+	}
+	return s.OrderOutbox.PurgeOutbox(ctx, before)
+}
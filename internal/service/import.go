@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ImportOrders stores orders decoded by DecodeOrder and caches the stored
+// ones. The orders are written in one batch when the storage supports it and
+// one by one otherwise. An order that is stale or rejected by the storage
+// gets its error at its index in errs and does not fail the others, while
+// one stored already with the same content is skipped without one: the
+// cached ones are left out of the batch and the write leaves the others
+// alone, so importing a file again adds no revisions or events. err is set
+// when the storage itself failed, in which case a part of the orders may be
+// stored already, so a failed batch can simply be imported again. Orders
+// whose personal data has been erased are stored with placeholders instead.
+func (s *Service) ImportOrders(ctx context.Context, orders []models.Order) (errs []error, err error) {
+	orders = append([]models.Order(nil), orders...)
+	if err := s.scrubErased(ctx, orders); err != nil {
+		return nil, fmt.Errorf("repo: %w", err)
+	}
+
+	var (
+		batch []models.Order
+		index []int
+	)
+	for i, o := range orders {
+		if !s.isDuplicate(ctx, o) {
+			batch = append(batch, o)
+			index = append(index, i)
+		}
+	}
+	written, err := s.importBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	errs = make([]error, len(orders))
+	skipped := len(orders) - len(batch)
+	for j, i := range index {
+		switch err := written[j]; {
+		case errors.Is(err, storage.ErrUnchanged):
+			skipped++
+		case err != nil:
+			errs[i] = storeError(orders[i], err)
+		default:
+			s.OrderCache.PutOrder(ctx, orders[i].OrderUid, orders[i])
+		}
+	}
+	if skipped > 0 {
+		logrus.WithField("orders", skipped).Info("skip unchanged imported orders")
+	}
+	return errs, nil
+}
+
+// importBatch writes orders and returns the storage error of each.
+func (s *Service) importBatch(ctx context.Context, orders []models.Order) ([]error, error) {
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	if s.OrderImport != nil {
+		errs, err := s.OrderImport.CreateOrUpdateBatch(ctx, orders)
+		if err != nil {
+			return nil, fmt.Errorf("repo: %w", err)
+		}
+		return errs, nil
+	}
+	errs := make([]error, len(orders))
+	for i, o := range orders {
+		err := s.OrderPostgres.CreateOrUpdate(ctx, o)
+		if errors.Is(err, storage.ErrStaleVersion) || errors.Is(err, storage.ErrUnchanged) ||
+			errors.Is(err, storage.ErrConstraint) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("repo: %w", err)
+		}
+	}
+	return errs, nil
+}
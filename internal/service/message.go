@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
+)
+
+// VersionHeader carries an explicit order version set by the producer.
+const VersionHeader = "x-order-version"
+
+// MessageMeta describes where a payload passed to HandleMessage came from.
+type MessageMeta struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Headers   map[string][]byte
+	Time      time.Time
+
+	// Group is set when offsets are kept in the database; the offset is then
+	// stored together with the order under this consumer group.
+	Group string
+}
+
+type messageMetaKey struct{}
+
+func WithMessageMeta(ctx context.Context, m MessageMeta) context.Context {
+	return context.WithValue(ctx, messageMetaKey{}, m)
+}
+
+func MessageMetaFrom(ctx context.Context) (MessageMeta, bool) {
+	m, ok := ctx.Value(messageMetaKey{}).(MessageMeta)
+	return m, ok
+}
+
+// StoredOffset returns the position to store with the order, if any.
+func (m MessageMeta) StoredOffset() (storage.Offset, bool) {
+	if m.Group == "" {
+		return storage.Offset{}, false
+	}
+	return storage.Offset{Group: m.Group, Topic: m.Topic, Partition: m.Partition, Offset: m.Offset}, true
+}
+
+// VersionSource selects where the version of a consumed order comes from.
+// Producer versions are small counters and broker timestamps are
+// milliseconds, so the two are never mixed: one of them orders all writes.
+type VersionSource string
+
+const (
+	// VersionFromTimestamp versions an order by the broker timestamp of its
+	// message in milliseconds, or zero without one; a version in the payload
+	// or header is ignored.
+	VersionFromTimestamp VersionSource = "timestamp"
+	// VersionFromProducer takes the version field of the payload, or else the
+	// VersionHeader. A message carrying neither is rejected.
+	VersionFromProducer VersionSource = "producer"
+)
+
+// ParseVersionSource parses a VersionSource; empty means timestamp.
+func ParseVersionSource(s string) (VersionSource, error) {
+	switch src := VersionSource(strings.TrimSpace(s)); src {
+	case "":
+		return VersionFromTimestamp, nil
+	case VersionFromTimestamp, VersionFromProducer:
+		return src, nil
+	default:
+		return "", fmt.Errorf("unknown version source %q, want %s or %s", s, VersionFromTimestamp, VersionFromProducer)
+	}
+}
+
+// Version resolves the order version from src: the version header or the
+// broker timestamp in milliseconds. It reports false when the message has
+// none, without falling back to the other source.
+func (m MessageMeta) Version(src VersionSource) (int64, bool) {
+	if src == VersionFromProducer {
+		v, ok := m.Headers[VersionHeader]
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64)
+		return n, err == nil && n > 0
+	}
+	if m.Time.IsZero() {
+		return 0, false
+	}
+	return m.Time.UnixMilli(), true
+}
+
+// RecordMessage archives a consumed message. The order uid is taken from the
+// payload when the caller did not set it, so that even rejected messages can
+// be traced back to their order. The payload of an erased order is archived
+// with its personal data erased.
+func (s *Service) RecordMessage(ctx context.Context, msg models.RawMessage) error {
+	if s.OrderMessages == nil {
+		return ErrUnsupported
+	}
+	if msg.OrderUid == "" {
+		var head struct {
+			OrderUid string `json:"order_uid"`
+		}
+		if json.Unmarshal([]byte(msg.Payload), &head) == nil {
+			msg.OrderUid = strings.TrimSpace(head.OrderUid)
+		}
+	}
+	if msg.OrderUid != "" && s.CustomerErasure != nil {
+		erased, err := s.CustomerErasure.ErasedOrders(ctx, []string{msg.OrderUid})
+		if err != nil {
+			return err
+		}
+		if erased[msg.OrderUid] {
+			msg.Payload = string(pii.ErasePayload([]byte(msg.Payload)))
+		}
+	}
+	return s.OrderMessages.SaveRawMessage(ctx, msg)
+}
+
+func (s *Service) GetOrderMessages(ctx context.Context, uid string) ([]models.RawMessage, error) {
+	if s.OrderMessages == nil {
+		return nil, ErrUnsupported
+	}
+	msgs, err := s.OrderMessages.RawMessages(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, ErrNotFound
+	}
+	return msgs, nil
+}
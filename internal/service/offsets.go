@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+
+	"l0-demo/internal/repository/storage"
+)
+
+// CommitOffset stores the offset of a message that was not applied through
+// HandleMessage, such as a stale or dead-lettered one.
+func (s *Service) CommitOffset(ctx context.Context, off storage.Offset) error {
+	if s.ConsumerOffsets == nil {
+		return ErrUnsupported
+	}
+	return s.ConsumerOffsets.StoreOffset(ctx, off)
+}
+
+func (s *Service) StoredOffsets(ctx context.Context, group, topic string) (map[int]int64, error) {
+	if s.ConsumerOffsets == nil {
+		return nil, ErrUnsupported
+	}
+	return s.ConsumerOffsets.Offsets(ctx, group, topic)
+}
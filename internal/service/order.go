@@ -3,107 +3,207 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 )
 
-func humanizeValidationErrors(errs validator.ValidationErrors) string {
-	var b strings.Builder
-	for _, fe := range errs {
-		if fe.Param() != "" {
-			fmt.Fprintf(&b, "%s: %s=%s; ", fe.Namespace(), fe.Tag(), fe.Param())
-		} else {
-			fmt.Fprintf(&b, "%s: %s; ", fe.Namespace(), fe.Tag())
-		}
-	}
-	s := b.String()
-	if len(s) > 2 {
-		s = s[:len(s)-2]
-	}
-	return s
-}
-
-func (s *Service) GetCachedOrder(uid string) (models.Order, error) {
-	return s.OrderCache.GetOrder(uid)
+func (s *Service) GetCachedOrder(ctx context.Context, uid string) (models.Order, error) {
+	return s.OrderCache.GetOrder(ctx, uid)
 }
 
-func (s *Service) GetAllCachedOrders() ([]models.Order, error) {
-	return s.OrderCache.GetAllOrders()
+func (s *Service) GetAllCachedOrders(ctx context.Context) ([]models.Order, error) {
+	return s.OrderCache.GetAllOrders(ctx)
 }
 
-func (s *Service) GetAllDbOrders() ([]models.Order, error) {
-	return s.OrderPostgres.GetAll()
+func (s *Service) GetAllDbOrders(ctx context.Context) ([]models.Order, error) {
+	return s.OrderPostgres.GetAll(ctx)
 }
 
-func (s *Service) PutOrdersFromDbToCache() error {
-	orders, err := s.GetAllDbOrders()
-	if err != nil {
-		return err
-	}
-	for _, o := range orders {
-		if err := s.v.Struct(o); err != nil {
+// PutOrdersFromDbToCache warms the cache by streaming orders from the
+// database in batches, so start-up memory does not grow with the table.
+func (s *Service) PutOrdersFromDbToCache(ctx context.Context) error {
+	return s.OrderPostgres.Each(ctx, storage.DefaultBatchSize, func(o models.Order) error {
+		if err := s.validAny(o); err != nil {
 			logrus.WithError(err).WithField("uid", o.OrderUid).Warn("skip invalid order from DB")
-			continue
+			return nil
 		}
-		s.PutCachedOrder(o)
-	}
-	return nil
+		s.PutCachedOrder(ctx, o)
+		return nil
+	})
 }
 
-func (s *Service) PutCachedOrder(order models.Order) {
-	s.OrderCache.PutOrder(order.OrderUid, order)
+func (s *Service) PutCachedOrder(ctx context.Context, order models.Order) {
+	s.OrderCache.PutOrder(ctx, order.OrderUid, order)
 }
 
-func (s *Service) PutDbOrder(order models.Order) error {
+func (s *Service) PutDbOrder(ctx context.Context, order models.Order) error {
 	if err := s.v.Struct(order); err != nil {
-		if verrs, ok := err.(validator.ValidationErrors); ok {
-			return fmt.Errorf("validation failed: %s", humanizeValidationErrors(verrs))
-		}
-		return fmt.Errorf("validation error: %w", err)
+		return validationError(err)
+	}
+	if err := s.checkRules(order); err != nil {
+		return err
 	}
-	return s.OrderPostgres.Create(order)
+	return s.OrderPostgres.Create(ctx, order)
 }
 
-func (s *Service) GetDbOrder(uid string) (order models.Order, err error) {
-	ord, err := s.OrderPostgres.Get(uid)
+func (s *Service) GetDbOrder(ctx context.Context, uid string, opts ...storage.ReadOption) (order models.Order, err error) {
+	ord, err := s.OrderPostgres.Get(ctx, uid, opts...)
 	if gorm.IsRecordNotFoundError(err) {
 		return models.Order{}, ErrNotFound
 	}
 	return ord, err
 }
 
+func (s *Service) DeleteOrder(ctx context.Context, uid string, hard bool) error {
+	var err error
+	if hard {
+		err = s.OrderPostgres.HardDelete(ctx, uid)
+	} else {
+		err = s.OrderPostgres.Delete(ctx, uid)
+	}
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	s.OrderCache.DeleteOrder(ctx, uid)
+
+	if err != nil {
+		return ErrNotFound
+	}
+	logrus.WithField("uid", uid).WithField("hard", hard).Info("order deleted")
+	return nil
+}
+
 func (s *Service) HandleMessage(ctx context.Context, payload []byte) error {
-	var ord models.Order
+	ord, err := s.DecodeOrder(ctx, payload)
+	if err != nil {
+		return err
+	}
 
-	if err := json.Unmarshal(payload, &ord); err != nil {
-		return fmt.Errorf("%w: %v", ErrDecode, err)
+	meta, _ := MessageMetaFrom(ctx)
+	off, hasOffset := meta.StoredOffset()
+
+	if s.isDuplicate(ctx, ord) {
+		// Nothing is written, so the offset is not claimed along with the
+		// order and has to be stored on its own.
+		if hasOffset {
+			if err := s.CommitOffset(ctx, off); err != nil {
+				return fmt.Errorf("repo: %w", err)
+			}
+		}
+		skipDuplicate(ord)
+		return nil
 	}
 
-	if err := s.v.Struct(ord); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+	scrubbed := []models.Order{ord}
+	if err := s.scrubErased(ctx, scrubbed); err != nil {
+		return fmt.Errorf("repo: %w", err)
 	}
+	ord = scrubbed[0]
 
-	if ord.OrderUid == "" {
-		return fmt.Errorf("%w: empty order_uid", ErrValidation)
+	var opts []storage.WriteOption
+	if hasOffset {
+		opts = append(opts, storage.WithOffset(off))
 	}
 
+	if err := s.OrderPostgres.CreateOrUpdate(ctx, ord, opts...); err != nil {
+		if errors.Is(err, storage.ErrUnchanged) {
+			// The offset is stored by the write all the same.
+			skipDuplicate(ord)
+			return nil
+		}
+		if errors.Is(err, storage.ErrOffsetProcessed) {
+			return fmt.Errorf("%w: offset %d of %s/%d already applied", ErrStale, meta.Offset, meta.Topic, meta.Partition)
+		}
+		return storeError(ord, err)
+	}
+
+	s.OrderCache.PutOrder(ctx, ord.OrderUid, ord)
+	messageCounts.Add("stored", 1)
+
+	logrus.Infof("processed order %s", ord.OrderUid)
+
+	return nil
+}
+
+func skipDuplicate(ord models.Order) {
+	messageCounts.Add("duplicate", 1)
+	logrus.WithField("uid", ord.OrderUid).WithField("version", ord.Version).Info("skip duplicate order")
+}
+
+// DecodeOrder decodes an order payload and checks it with the struct
+// validation, under the validation profile of the message in ctx if it has
+// one, and with the business rules. The content hash is taken as decoded.
+// A missing date_created is then set to now, and for a message with meta in
+// ctx the version is resolved by the configured VersionSource.
+func (s *Service) DecodeOrder(ctx context.Context, payload []byte) (models.Order, error) {
+	var ord models.Order
+
+	if err := json.Unmarshal(payload, &ord); err != nil {
+		return ord, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	ord.ContentHash = contentHash(ord)
+
 	if ord.DateCreated.IsZero() {
 		ord.DateCreated = time.Now().UTC()
 	}
-	if err := s.OrderPostgres.CreateOrUpdate(ord); err != nil {
-		return fmt.Errorf("repo: %w", err)
+	if meta, ok := MessageMetaFrom(ctx); ok {
+		if err := s.resolveVersion(&ord, meta); err != nil {
+			return ord, err
+		}
 	}
 
-	s.OrderCache.PutOrder(ord.OrderUid, ord)
+	if err := s.validatorFor(ctx).Struct(ord); err != nil {
+		return ord, validationError(err)
+	}
 
-	logrus.Infof("processed order %s", ord.OrderUid)
+	if ord.OrderUid == "" {
+		return ord, &ValidationError{Errors: []FieldError{{Field: "order_uid", Rule: "required", Message: "is required"}}}
+	}
+	return ord, s.checkRules(ord)
+}
 
+// resolveVersion sets the version of a consumed order from the configured
+// source only. A producer version is taken from the payload or else the
+// header and is required; a broker timestamp replaces the payload version,
+// and a message without one has version zero.
+func (s *Service) resolveVersion(ord *models.Order, meta MessageMeta) error {
+	if s.versionSource != VersionFromProducer {
+		ord.Version, _ = meta.Version(VersionFromTimestamp)
+		return nil
+	}
+	if ord.Version > 0 {
+		return nil
+	}
+	v, ok := meta.Version(VersionFromProducer)
+	if !ok {
+		return &ValidationError{Errors: []FieldError{{
+			Field: "version", Rule: "required",
+			Message: "is required in the payload or the " + VersionHeader + " header",
+		}}}
+	}
+	ord.Version = v
 	return nil
 }
+
+// storeError maps an error of writing ord to the errors of the service.
+func storeError(ord models.Order, err error) error {
+	if errors.Is(err, storage.ErrStaleVersion) {
+		return fmt.Errorf("%w: order %s version %d", ErrStale, ord.OrderUid, ord.Version)
+	}
+	var ce *storage.ConstraintError
+	if errors.As(err, &ce) {
+		return constraintError(ce)
+	}
+	if errors.Is(err, storage.ErrConstraint) {
+		return fmt.Errorf("%w: rejected by storage: %w", ErrValidation, err)
+	}
+	return fmt.Errorf("repo: %w", err)
+}
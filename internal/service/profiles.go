@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"l0-demo/internal/models"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// profilesFile is the layout of a validation profiles file, in YAML or JSON:
+//
+//	header: x-order-source
+//	profiles:
+//	  ozon:
+//	    topics: [orders.ozon]
+//	    order:
+//	      entry: required,len=5
+//	      locale: oneof=ru en kz
+//	    items:
+//	      track_number: required,min=10,max=20
+//
+// The rules of a profile replace the validate tags of the named fields, by
+// JSON name, of the order, its delivery, payment and items; the other fields
+// keep their tags.
+type profilesFile struct {
+	Header   string                 `yaml:"header"`
+	Profiles map[string]profileSpec `yaml:"profiles"`
+}
+
+type profileSpec struct {
+	Topics   []string          `yaml:"topics"`
+	Order    map[string]string `yaml:"order"`
+	Delivery map[string]string `yaml:"delivery"`
+	Payment  map[string]string `yaml:"payment"`
+	Items    map[string]string `yaml:"items"`
+}
+
+type profile struct {
+	name string
+	v    *validator.Validate
+}
+
+type profileSet struct {
+	header    string
+	byName    map[string]*profile
+	byTopic   map[string]*profile
+	overrides map[string][]string
+	modTime   time.Time
+	size      int64
+}
+
+// Profiles are validation profiles for orders of different producers,
+// loaded from a file. A consumed message is checked with the profile named
+// by its header, or else with the profile of its topic; messages matching no
+// profile are checked with the tags of the models.
+type Profiles struct {
+	path string
+	set  atomic.Pointer[profileSet]
+	// loaded are the overrides of the first load, whose checks the
+	// database was told to leave out.
+	loaded map[string][]string
+}
+
+// LoadProfiles loads the profiles of the file at path. Every rule is checked
+// on load, so a typo fails here rather than on the first message.
+func LoadProfiles(path string) (*Profiles, error) {
+	p := &Profiles{path: path}
+	if _, err := p.Reload(); err != nil {
+		return nil, err
+	}
+	p.loaded = p.Overrides()
+	return p, nil
+}
+
+// Reload loads the file again if it changed since the last load and
+// reports whether it did. On error the profiles loaded before stay in use.
+func (p *Profiles) Reload() (bool, error) {
+	st, err := os.Stat(p.path)
+	if err != nil {
+		return false, err
+	}
+	if cur := p.set.Load(); cur != nil && cur.modTime.Equal(st.ModTime()) && cur.size == st.Size() {
+		return false, nil
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return false, err
+	}
+	set, err := parseProfiles(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", p.path, err)
+	}
+	set.modTime, set.size = st.ModTime(), st.Size()
+	p.set.Store(set)
+	return true, nil
+}
+
+// Watch reloads the profiles every interval until ctx is done.
+func (p *Profiles) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		reloaded, err := p.Reload()
+		if err != nil {
+			logrus.WithError(err).Warn("validation profiles not reloaded, keeping the loaded ones")
+			continue
+		}
+		if reloaded {
+			logrus.WithField("profiles", p.Names()).Info("validation profiles reloaded")
+			if added := newOverrides(p.loaded, p.Overrides()); len(added) > 0 {
+				logrus.WithField("columns", added).
+					Warn("reloaded profiles override columns the database may still check until the next start")
+			}
+		}
+	}
+}
+
+// Names returns the names of the loaded profiles.
+func (p *Profiles) Names() []string {
+	set := p.set.Load()
+	names := make([]string, 0, len(set.byName))
+	for name := range set.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has reports whether a profile of that name is loaded.
+func (p *Profiles) Has(name string) bool {
+	_, ok := p.set.Load().byName[name]
+	return ok
+}
+
+// Overrides returns, by table, the columns whose rules a loaded profile
+// replaces. The JSON name of every such field is its column name. A
+// database that checks these columns would reject orders the profiles
+// accept, so their checks are left out of the schema.
+func (p *Profiles) Overrides() map[string][]string {
+	return p.set.Load().overrides
+}
+
+// newOverrides returns the columns of cur missing from prev.
+func newOverrides(prev, cur map[string][]string) []string {
+	var out []string
+	for table, columns := range cur {
+		for _, col := range columns {
+			if !slices.Contains(prev[table], col) {
+				out = append(out, table+"."+col)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// match returns the profile of a message, or nil.
+func (p *Profiles) match(meta MessageMeta) *profile {
+	set := p.set.Load()
+	if set.header != "" {
+		if name, ok := meta.Headers[set.header]; ok {
+			if pr, ok := set.byName[strings.TrimSpace(string(name))]; ok {
+				return pr
+			}
+		}
+	}
+	return set.byTopic[meta.Topic]
+}
+
+func (p *Profiles) validators() []*validator.Validate {
+	set := p.set.Load()
+	out := make([]*validator.Validate, 0, len(set.byName))
+	for _, pr := range set.byName {
+		out = append(out, pr.v)
+	}
+	return out
+}
+
+func parseProfiles(data []byte) (*profileSet, error) {
+	var f profilesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	set := &profileSet{
+		header:    strings.TrimSpace(f.Header),
+		byName:    map[string]*profile{},
+		byTopic:   map[string]*profile{},
+		overrides: map[string][]string{},
+	}
+	for name, spec := range f.Profiles {
+		v, err := profileValidator(spec)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		for _, sec := range spec.sections() {
+			for col := range sec.rules {
+				if !slices.Contains(set.overrides[sec.table], col) {
+					set.overrides[sec.table] = append(set.overrides[sec.table], col)
+				}
+			}
+		}
+		pr := &profile{name: name, v: v}
+		set.byName[name] = pr
+		for _, topic := range spec.Topics {
+			if other, ok := set.byTopic[topic]; ok {
+				return nil, fmt.Errorf("topic %s is in profiles %s and %s", topic, other.name, name)
+			}
+			set.byTopic[topic] = pr
+		}
+	}
+	for _, columns := range set.overrides {
+		sort.Strings(columns)
+	}
+	return set, nil
+}
+
+type profileSection struct {
+	name  string
+	table string
+	rules map[string]string
+	model any
+}
+
+// sections pairs the rules of spec with the model and table they apply to.
+func (spec profileSpec) sections() []profileSection {
+	return []profileSection{
+		{"order", "orders", spec.Order, models.Order{}},
+		{"delivery", "deliveries", spec.Delivery, models.Delivery{}},
+		{"payment", "payments", spec.Payment, models.Payment{}},
+		{"items", "items", spec.Items, models.Item{}},
+	}
+}
+
+func profileValidator(spec profileSpec) (*validator.Validate, error) {
+	v := newValidator()
+	for _, t := range spec.sections() {
+		if len(t.rules) == 0 {
+			continue
+		}
+		rules, err := fieldRules(t.model, t.rules)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		v.RegisterStructValidationMapRules(rules, t.model)
+	}
+	return v, checkRuleTags(v)
+}
+
+// fieldRules keys rules by the Go field names of model instead of the JSON
+// ones. The delivery, payment and items of an order keep their tags: the
+// service relies on an order having them once it passed validation.
+func fieldRules(model any, rules map[string]string) (map[string]string, error) {
+	typ := reflect.TypeOf(model)
+	out := make(map[string]string, len(rules))
+	for name, rule := range rules {
+		found := false
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if jsonName(f) != name {
+				continue
+			}
+			if isPart(f.Type) {
+				return nil, fmt.Errorf("%q is a part of the order and keeps its rule", name)
+			}
+			out[f.Name], found = rule, true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("no field %q", name)
+		}
+	}
+	return out, nil
+}
+
+// isPart reports whether a field of type t holds a part of an order, such as
+// its delivery or items, rather than a value.
+func isPart(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		return true
+	case reflect.Struct:
+		return t != reflect.TypeOf(time.Time{})
+	}
+	return false
+}
+
+// checkRuleTags runs v over every model once, since the validator only
+// parses the rules of a struct, and panics on bad ones, when it first
+// validates one.
+func checkRuleTags(v *validator.Validate) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	v.Struct(models.Order{Delivery: &models.Delivery{}, Payment: &models.Payment{}, Items: []models.Item{{}}})
+	return nil
+}
+
+// WithProfiles checks consumed orders with the profile of their message.
+func WithProfiles(p *Profiles) Option {
+	return func(s *Service) { s.profiles = p }
+}
+
+type profileKey struct{}
+
+// WithProfile names the profile to check the orders decoded with ctx, for
+// orders that do not come with a message, such as imported ones.
+func WithProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, profileKey{}, name)
+}
+
+// validatorFor returns the validator of the profile named in ctx, or else of
+// the message in ctx.
+func (s *Service) validatorFor(ctx context.Context) *validator.Validate {
+	if s.profiles == nil {
+		return s.v
+	}
+	if name, ok := ctx.Value(profileKey{}).(string); ok {
+		if pr, ok := s.profiles.set.Load().byName[name]; ok {
+			return pr.v
+		}
+	}
+	if meta, ok := MessageMetaFrom(ctx); ok {
+		if pr := s.profiles.match(meta); pr != nil {
+			return pr.v
+		}
+	}
+	return s.v
+}
+
+// validAny reports whether o passes the tags of the models or any profile:
+// a stored order has passed the one of its producer.
+func (s *Service) validAny(o models.Order) error {
+	err := s.v.Struct(o)
+	if err == nil || s.profiles == nil {
+		return err
+	}
+	for _, v := range s.profiles.validators() {
+		if v.Struct(o) == nil {
+			return nil
+		}
+	}
+	return err
+}
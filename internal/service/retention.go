@@ -0,0 +1,163 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"l0-demo/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+type RetentionConfig struct {
+	Interval    time.Duration
+	MaxAge      time.Duration
+	MonthsAhead int
+	ArchiveDir  string
+}
+
+func (s *Service) PrepareOrderPartitions(ctx context.Context, monthsAhead int) error {
+	if s.OrderPartitions == nil {
+		return ErrUnsupported
+	}
+	return s.OrderPartitions.EnsurePartitions(ctx, monthsAhead)
+}
+
+// ApplyRetention drops every order partition that lies entirely before the
+// given moment, archiving it to archiveDir first when one is set, and evicts
+// orders created before that moment from the cache.
+func (s *Service) ApplyRetention(ctx context.Context, before time.Time, archiveDir string) (models.RetentionReport, error) {
+	report := models.RetentionReport{Partitions: []models.DroppedPartition{}}
+	if s.OrderPartitions == nil {
+		return report, ErrUnsupported
+	}
+
+	names, err := s.OrderPartitions.ExpiredPartitions(ctx, before)
+	if err != nil {
+		return report, err
+	}
+	for _, name := range names {
+		dropped, err := s.dropPartition(ctx, name, archiveDir)
+		if err != nil {
+			return report, fmt.Errorf("drop partition %s: %w", name, err)
+		}
+		report.Partitions = append(report.Partitions, dropped)
+	}
+
+	cached, err := s.OrderCache.GetAllOrders(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, o := range cached {
+		if o.DateCreated.Before(before) {
+			s.OrderCache.DeleteOrder(ctx, o.OrderUid)
+			report.EvictedOrders++
+		}
+	}
+	return report, nil
+}
+
+func (s *Service) dropPartition(ctx context.Context, name, archiveDir string) (models.DroppedPartition, error) {
+	if archiveDir == "" {
+		n, err := s.OrderPartitions.DropPartition(ctx, name, nil)
+		return models.DroppedPartition{Name: name, Orders: n}, err
+	}
+
+	a, err := newNDJSONArchive(archiveDir, name)
+	if err != nil {
+		return models.DroppedPartition{}, err
+	}
+	defer a.discard()
+
+	n, err := s.OrderPartitions.DropPartition(ctx, name, a)
+	if err != nil {
+		return models.DroppedPartition{}, err
+	}
+	return models.DroppedPartition{Name: name, Orders: n, Archive: a.path}, nil
+}
+
+// ndjsonArchive writes orders as gzip-compressed NDJSON to a temporary file
+// and moves it to <dir>/<name>.ndjson.gz on Close, once it is synced to disk.
+type ndjsonArchive struct {
+	path string
+	tmp  *os.File
+	zw   *gzip.Writer
+	enc  *json.Encoder
+}
+
+func newNDJSONArchive(dir, name string) (*ndjsonArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(tmp)
+	return &ndjsonArchive{
+		path: filepath.Join(dir, name+".ndjson.gz"),
+		tmp:  tmp,
+		zw:   zw,
+		enc:  json.NewEncoder(zw),
+	}, nil
+}
+
+func (a *ndjsonArchive) Archive(o models.Order) error {
+	return a.enc.Encode(o)
+}
+
+func (a *ndjsonArchive) Close() error {
+	if err := a.zw.Close(); err != nil {
+		return err
+	}
+	if err := a.tmp.Sync(); err != nil {
+		return err
+	}
+	if err := a.tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(a.tmp.Name(), a.path)
+}
+
+// discard removes the temporary file if the archive was never completed.
+func (a *ndjsonArchive) discard() {
+	a.tmp.Close()
+	os.Remove(a.tmp.Name())
+}
+
+// RunRetention prepares partitions ahead and applies retention every
+// cfg.Interval until ctx is done. A zero MaxAge only prepares partitions.
+func (s *Service) RunRetention(ctx context.Context, cfg RetentionConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.PrepareOrderPartitions(ctx, cfg.MonthsAhead); err != nil {
+			logrus.WithError(err).Error("prepare order partitions")
+		}
+		if cfg.MaxAge > 0 {
+			report, err := s.ApplyRetention(ctx, time.Now().Add(-cfg.MaxAge), cfg.ArchiveDir)
+			for _, p := range report.Partitions {
+				logrus.WithField("partition", p.Name).WithField("orders", p.Orders).
+					WithField("archive", p.Archive).Info("order partition dropped")
+			}
+			if err != nil {
+				logrus.WithError(err).Error("apply retention")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
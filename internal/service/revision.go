@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"l0-demo/internal/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+func (s *Service) GetOrderRevisions(ctx context.Context, uid string) ([]models.OrderRevision, error) {
+	if s.OrderRevisions == nil {
+		return nil, ErrUnsupported
+	}
+	revs, err := s.OrderRevisions.Revisions(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, ErrNotFound
+	}
+	return revs, nil
+}
+
+func (s *Service) GetOrderAsOf(ctx context.Context, uid string, at time.Time) (models.Order, error) {
+	if s.OrderRevisions == nil {
+		return models.Order{}, ErrUnsupported
+	}
+	ord, err := s.OrderRevisions.GetAsOf(ctx, uid, at)
+	if gorm.IsRecordNotFoundError(err) {
+		return models.Order{}, ErrNotFound
+	}
+	return ord, err
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"l0-demo/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RuleAction tells what a violated business rule does to an order.
+type RuleAction string
+
+const (
+	// RuleWarn logs the violation and accepts the order. It is the action
+	// of every rule that is not configured.
+	RuleWarn   RuleAction = "warn"
+	RuleReject RuleAction = "reject"
+	RuleOff    RuleAction = "off"
+)
+
+// Rule checks the consistency of an order beyond the shape its struct tags
+// describe. Check returns the field and message of every violation and
+// nothing when the order keeps the rule; it runs on orders that passed
+// struct validation, but does not rely on it: a missing part of the order is
+// left for validation to report.
+type Rule struct {
+	Name  string
+	Check func(o models.Order) []FieldError
+}
+
+var businessRules = []Rule{
+	{"goods_total", checkGoodsTotal},
+	{"payment_amount", checkPaymentAmount},
+	{"item_track_number", checkItemTrackNumbers},
+	{"item_total_price", checkItemTotalPrices},
+}
+
+func checkGoodsTotal(o models.Order) []FieldError {
+	if o.Payment == nil {
+		return nil
+	}
+	sum := 0
+	for _, it := range o.Items {
+		sum += it.TotalPrice
+	}
+	if o.Payment.GoodsTotal != sum {
+		return []FieldError{{
+			Field:   "payment.goods_total",
+			Message: fmt.Sprintf("%d != sum of items.total_price %d", o.Payment.GoodsTotal, sum),
+		}}
+	}
+	return nil
+}
+
+func checkPaymentAmount(o models.Order) []FieldError {
+	p := o.Payment
+	if p == nil {
+		return nil
+	}
+	if want := p.GoodsTotal + p.DeliveryCost + p.CustomFee; p.Amount != want {
+		return []FieldError{{
+			Field:   "payment.amount",
+			Message: fmt.Sprintf("%d != goods_total + delivery_cost + custom_fee %d", p.Amount, want),
+		}}
+	}
+	return nil
+}
+
+func checkItemTrackNumbers(o models.Order) []FieldError {
+	var out []FieldError
+	for i, it := range o.Items {
+		if it.TrackNumber != o.TrackNumber {
+			out = append(out, FieldError{
+				Field:   fmt.Sprintf("items[%d].track_number", i),
+				Message: fmt.Sprintf("%q != order track_number %q", it.TrackNumber, o.TrackNumber),
+			})
+		}
+	}
+	return out
+}
+
+// checkItemTotalPrices accepts the discounted price rounded either way, as
+// producers differ in how they round kopecks.
+func checkItemTotalPrices(o models.Order) []FieldError {
+	var out []FieldError
+	for i, it := range o.Items {
+		discounted := it.Price * (100 - it.Sale)
+		lo, hi := discounted/100, (discounted+99)/100
+		if it.TotalPrice < lo || it.TotalPrice > hi {
+			out = append(out, FieldError{
+				Field:   fmt.Sprintf("items[%d].total_price", i),
+				Message: fmt.Sprintf("%d != price %d less sale %d%%", it.TotalPrice, it.Price, it.Sale),
+			})
+		}
+	}
+	return out
+}
+
+// ParseRuleActions parses a comma separated list of rule=action pairs, such
+// as "goods_total=reject,item_track_number=off".
+func ParseRuleActions(s string) (map[string]RuleAction, error) {
+	actions := map[string]RuleAction{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, action, ok := strings.Cut(pair, "=")
+		name, action = strings.TrimSpace(name), strings.TrimSpace(action)
+		if !ok || !knownRule(name) {
+			return nil, fmt.Errorf("unknown rule %q, want one of %s", name, strings.Join(RuleNames(), ", "))
+		}
+		switch a := RuleAction(action); a {
+		case RuleWarn, RuleReject, RuleOff:
+			actions[name] = a
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q, want warn, reject or off", name, action)
+		}
+	}
+	return actions, nil
+}
+
+// RuleNames returns the names of the business rules.
+func RuleNames() []string {
+	names := make([]string, 0, len(businessRules))
+	for _, r := range businessRules {
+		names = append(names, r.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func knownRule(name string) bool {
+	for _, r := range businessRules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRules runs the business rules on a validated order. Violations of
+// rules set to warn are logged, those of rules set to reject are returned
+// as a *ValidationError.
+func (s *Service) checkRules(o models.Order) error {
+	var rejected []FieldError
+	for _, r := range businessRules {
+		action, ok := s.ruleActions[r.Name]
+		if !ok {
+			action = RuleWarn
+		}
+		if action == RuleOff {
+			continue
+		}
+		for _, fe := range r.Check(o) {
+			fe.Rule = r.Name
+			if action == RuleReject {
+				rejected = append(rejected, fe)
+				continue
+			}
+			logrus.WithField("uid", o.OrderUid).WithField("rule", r.Name).WithField("field", fe.Field).Warn(fe.Message)
+		}
+	}
+	if len(rejected) > 0 {
+		return &ValidationError{Errors: rejected}
+	}
+	return nil
+}
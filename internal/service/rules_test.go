@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"l0-demo/internal/models"
+)
+
+func TestBusinessRules_IncompleteOrders(t *testing.T) {
+	cases := map[string]models.Order{
+		"no payment": {TrackNumber: "WBILMTESTTRACK", Items: []models.Item{{TrackNumber: "WBILMTESTTRACK"}}},
+		"no items":   {TrackNumber: "WBILMTESTTRACK", Payment: &models.Payment{}},
+		"empty":      {},
+	}
+	for name, o := range cases {
+		for _, r := range businessRules {
+			require.NotPanics(t, func() { r.Check(o) }, "%s: %s", name, r.Name)
+		}
+	}
+	for _, r := range []Rule{{"goods_total", checkGoodsTotal}, {"payment_amount", checkPaymentAmount}} {
+		require.Empty(t, r.Check(cases["no payment"]), "%s leaves a missing payment to validation", r.Name)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/pii"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+func (s *Service) SearchOrders(ctx context.Context, q string, limit, offset int) (models.SearchResult, error) {
+	if s.OrderSearch == nil {
+		return models.SearchResult{}, ErrUnsupported
+	}
+
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return models.SearchResult{}, fmt.Errorf("%w: empty search query", ErrValidation)
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return s.OrderSearch.Search(ctx, q, limit, offset)
+}
+
+// LookupOrders returns the orders delivered to the given email and/or
+// phone, newest first.
+func (s *Service) LookupOrders(ctx context.Context, email, phone string, limit int) ([]models.Order, error) {
+	if s.OrderLookup == nil {
+		return nil, ErrUnsupported
+	}
+
+	email, phone = strings.TrimSpace(email), strings.TrimSpace(phone)
+	if email == "" && phone == "" {
+		return nil, fmt.Errorf("%w: email or phone is required", ErrValidation)
+	}
+	if phone != "" && pii.NormalizePhone(phone) == "" {
+		return nil, fmt.Errorf("%w: phone has no digits", ErrValidation)
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	orders, err := s.OrderLookup.FindByContact(ctx, email, phone, limit)
+	if err != nil {
+		return nil, err
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
+	return orders, nil
+}
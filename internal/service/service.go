@@ -2,9 +2,11 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"l0-demo/internal/models"
 	"l0-demo/internal/repository"
+	"l0-demo/internal/repository/storage"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -12,28 +14,93 @@ import (
 //go:generate mockgen -source=service.go -destination=mocks/mock.go
 
 type Order interface {
-	GetCachedOrder(uid string) (models.Order, error)
-	GetAllCachedOrders() ([]models.Order, error)
-	GetAllDbOrders() ([]models.Order, error)
-	GetDbOrder(uid string) (models.Order, error)
-	PutOrdersFromDbToCache() error
-	PutCachedOrder(order models.Order)
-	PutDbOrder(order models.Order) error
+	GetCachedOrder(ctx context.Context, uid string) (models.Order, error)
+	GetAllCachedOrders(ctx context.Context) ([]models.Order, error)
+	GetAllDbOrders(ctx context.Context) ([]models.Order, error)
+	GetDbOrder(ctx context.Context, uid string, opts ...storage.ReadOption) (models.Order, error)
+	PutOrdersFromDbToCache(ctx context.Context) error
+	PutCachedOrder(ctx context.Context, order models.Order)
+	PutDbOrder(ctx context.Context, order models.Order) error
+	DeleteOrder(ctx context.Context, uid string, hard bool) error
+	GetOrderRevisions(ctx context.Context, uid string) ([]models.OrderRevision, error)
+	GetOrderAsOf(ctx context.Context, uid string, at time.Time) (models.Order, error)
+	SearchOrders(ctx context.Context, q string, limit, offset int) (models.SearchResult, error)
+	LookupOrders(ctx context.Context, email, phone string, limit int) ([]models.Order, error)
+	RevenueStats(ctx context.Context, f models.StatsFilter) ([]models.RevenueRow, error)
+	TopBrandStats(ctx context.Context, f models.StatsFilter) ([]models.BrandRow, error)
+	BasketStats(ctx context.Context, f models.StatsFilter) ([]models.BasketRow, error)
+	GetOrderMessages(ctx context.Context, uid string) ([]models.RawMessage, error)
+	EraseCustomer(ctx context.Context, customerID string) (models.ErasureReceipt, error)
+	GetErasureReceipts(ctx context.Context, customerID string) ([]models.ErasureReceipt, error)
 
 	HandleMessage(ctx context.Context, payload []byte) error
+	RecordMessage(ctx context.Context, msg models.RawMessage) error
+	CommitOffset(ctx context.Context, off storage.Offset) error
+	StoredOffsets(ctx context.Context, group, topic string) (map[int]int64, error)
+}
+
+type OrderEvents interface {
+	RelayOrderEvents(ctx context.Context, limit int, publish func([]models.OrderEvent) error) (int, error)
+	PurgeOrderEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Retention interface {
+	PrepareOrderPartitions(ctx context.Context, monthsAhead int) error
+	ApplyRetention(ctx context.Context, before time.Time, archiveDir string) (models.RetentionReport, error)
 }
 
 type Service struct {
 	repository.OrderCache
 	repository.OrderPostgres
-	v *validator.Validate
+	repository.OrderImport
+	repository.OrderRevisions
+	repository.OrderSearch
+	repository.OrderLookup
+	repository.OrderStats
+	repository.OrderOutbox
+	repository.OrderPartitions
+	repository.OrderMessages
+	repository.CustomerErasure
+	repository.ConsumerOffsets
+	v             *validator.Validate
+	ruleActions   map[string]RuleAction
+	profiles      *Profiles
+	versionSource VersionSource
+}
+
+type Option func(*Service)
+
+// WithRuleActions sets what violations of the business rules do, by rule
+// name; see ParseRuleActions.
+func WithRuleActions(actions map[string]RuleAction) Option {
+	return func(s *Service) { s.ruleActions = actions }
 }
 
-func NewService(repository *repository.Repository) *Service {
-	validator := validator.New()
-	return &Service{
-		OrderCache:    repository.OrderCache,
-		OrderPostgres: repository.OrderPostgres,
-		v:             validator,
+// WithVersionSource sets where the versions of consumed orders come from;
+// the broker timestamp by default.
+func WithVersionSource(src VersionSource) Option {
+	return func(s *Service) { s.versionSource = src }
+}
+
+func NewService(repository *repository.Repository, opts ...Option) *Service {
+	s := &Service{
+		OrderCache:      repository.OrderCache,
+		OrderPostgres:   repository.OrderPostgres,
+		OrderImport:     repository.OrderImport,
+		OrderRevisions:  repository.OrderRevisions,
+		OrderSearch:     repository.OrderSearch,
+		OrderLookup:     repository.OrderLookup,
+		OrderStats:      repository.OrderStats,
+		OrderOutbox:     repository.OrderOutbox,
+		OrderPartitions: repository.OrderPartitions,
+		OrderMessages:   repository.OrderMessages,
+		CustomerErasure: repository.CustomerErasure,
+		ConsumerOffsets: repository.ConsumerOffsets,
+		v:               newValidator(),
+		versionSource:   VersionFromTimestamp,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
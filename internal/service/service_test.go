@@ -1,9 +1,14 @@
 package service_test
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +20,8 @@ import (
 
 	"l0-demo/internal/models"
 	"l0-demo/internal/repository"
+	"l0-demo/internal/repository/pii"
+	"l0-demo/internal/repository/storage"
 	svc "l0-demo/internal/service"
 )
 
@@ -26,12 +33,47 @@ type pgStub struct {
 	getAllErr         error
 	createErr         error
 	createOrUpdateErr error
+	deleteErr         error
+	deleted           string
+	hardDeleted       string
+	writeOpts         storage.WriteOptions
 }
 
-func (p *pgStub) Create(ord models.Order) error       { p.created = ord; return p.createErr }
-func (p *pgStub) CreateOrUpdate(o models.Order) error { p.created = o; return p.createOrUpdateErr }
-func (p *pgStub) Get(string) (models.Order, error)    { return p.getResp, p.getErr }
-func (p *pgStub) GetAll() ([]models.Order, error)     { return p.getAllResp, p.getAllErr }
+func each(orders []models.Order, err error, fn func(models.Order) error) error {
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pgStub) Create(_ context.Context, ord models.Order) error {
+	p.created = ord
+	return p.createErr
+}
+func (p *pgStub) CreateOrUpdate(_ context.Context, o models.Order, opts ...storage.WriteOption) error {
+	p.created = o
+	p.writeOpts = storage.NewWriteOptions(opts...)
+	return p.createOrUpdateErr
+}
+func (p *pgStub) Get(context.Context, string, ...storage.ReadOption) (models.Order, error) {
+	return p.getResp, p.getErr
+}
+func (p *pgStub) GetAll(context.Context, ...storage.ReadOption) ([]models.Order, error) {
+	return p.getAllResp, p.getAllErr
+}
+func (p *pgStub) Each(_ context.Context, _ int, fn func(models.Order) error, _ ...storage.ReadOption) error {
+	return each(p.getAllResp, p.getAllErr, fn)
+}
+func (p *pgStub) Delete(_ context.Context, uid string) error { p.deleted = uid; return p.deleteErr }
+func (p *pgStub) HardDelete(_ context.Context, uid string) error {
+	p.hardDeleted = uid
+	return p.deleteErr
+}
 
 type cacheStub struct {
 	m        map[string]models.Order
@@ -40,26 +82,48 @@ type cacheStub struct {
 
 type fakeOrderRepo struct{ called bool }
 
-func (f *fakeOrderRepo) Create(o models.Order) error {
+func (f *fakeOrderRepo) Create(_ context.Context, o models.Order) error {
 	f.called = true
 	return nil
 }
-func (f *fakeOrderRepo) CreateOrUpdate(o models.Order) error {
+func (f *fakeOrderRepo) CreateOrUpdate(_ context.Context, o models.Order, _ ...storage.WriteOption) error {
 	f.called = true
 	return nil
 }
-func (f *fakeOrderRepo) GetAllDbOrders() ([]models.Order, error)     { return []models.Order{}, nil }
-func (f *fakeOrderRepo) GetDbOrder(uid string) (models.Order, error) { return models.Order{}, nil }
-func (f *fakeOrderRepo) Get(uid string) (models.Order, error)        { return models.Order{}, nil }
-func (f *fakeOrderRepo) GetAll() ([]models.Order, error)             { return []models.Order{}, nil }
+func (f *fakeOrderRepo) GetAllDbOrders(_ context.Context) ([]models.Order, error) {
+	return []models.Order{}, nil
+}
+func (f *fakeOrderRepo) GetDbOrder(_ context.Context, uid string) (models.Order, error) {
+	return models.Order{}, nil
+}
+func (f *fakeOrderRepo) Get(_ context.Context, uid string, _ ...storage.ReadOption) (models.Order, error) {
+	return models.Order{}, nil
+}
+func (f *fakeOrderRepo) GetAll(context.Context, ...storage.ReadOption) ([]models.Order, error) {
+	return []models.Order{}, nil
+}
+func (f *fakeOrderRepo) Each(context.Context, int, func(models.Order) error, ...storage.ReadOption) error {
+	return nil
+}
+func (f *fakeOrderRepo) Delete(_ context.Context, uid string) error     { return nil }
+func (f *fakeOrderRepo) HardDelete(_ context.Context, uid string) error { return nil }
 
 type fakeCache struct{}
 
-func (f *fakeCache) PutOrder(uid string, o models.Order)             {}
-func (f *fakeCache) GetAllOrders() ([]models.Order, error)           { return []models.Order{}, nil }
-func (f *fakeCache) GetAllCachedOrders() ([]models.Order, error)     { return []models.Order{}, nil }
-func (f *fakeCache) GetCachedOrder(uid string) (models.Order, error) { return models.Order{}, nil }
-func (f *fakeCache) GetOrder(uid string) (models.Order, error)       { return models.Order{}, nil }
+func (f *fakeCache) PutOrder(_ context.Context, uid string, o models.Order) {}
+func (f *fakeCache) DeleteOrder(_ context.Context, uid string)              {}
+func (f *fakeCache) GetAllOrders(_ context.Context) ([]models.Order, error) {
+	return []models.Order{}, nil
+}
+func (f *fakeCache) GetAllCachedOrders(_ context.Context) ([]models.Order, error) {
+	return []models.Order{}, nil
+}
+func (f *fakeCache) GetCachedOrder(_ context.Context, uid string) (models.Order, error) {
+	return models.Order{}, nil
+}
+func (f *fakeCache) GetOrder(_ context.Context, uid string) (models.Order, error) {
+	return models.Order{}, nil
+}
 
 var _ repository.OrderPostgres = (*fakeOrderRepo)(nil)
 var _ repository.OrderCache = (*fakeCache)(nil)
@@ -71,8 +135,8 @@ type countingCache struct {
 	puts int
 }
 
-func (c *countingCache) PutOrder(uid string, o models.Order) {
-	c.cacheStub.PutOrder(uid, o)
+func (c *countingCache) PutOrder(ctx context.Context, uid string, o models.Order) {
+	c.cacheStub.PutOrder(ctx, uid, o)
 	c.puts++
 }
 
@@ -81,7 +145,13 @@ type pgWithData struct {
 	orders []models.Order
 }
 
-func (p *pgWithData) GetAll() ([]models.Order, error) { return p.orders, nil }
+func (p *pgWithData) GetAll(context.Context, ...storage.ReadOption) ([]models.Order, error) {
+	return p.orders, nil
+}
+
+func (p *pgWithData) Each(_ context.Context, _ int, fn func(models.Order) error, _ ...storage.ReadOption) error {
+	return each(p.orders, nil, fn)
+}
 
 func TestService_PutOrdersFromDbToCache_SkipsInvalid_LogsWarn(t *testing.T) {
 	hook := logtest.NewGlobal()
@@ -94,7 +164,7 @@ func TestService_PutOrdersFromDbToCache_SkipsInvalid_LogsWarn(t *testing.T) {
 	cc := &countingCache{}
 	s := svc.NewService(&repository.Repository{OrderPostgres: repo, OrderCache: cc})
 
-	require.NoError(t, s.PutOrdersFromDbToCache())
+	require.NoError(t, s.PutOrdersFromDbToCache(context.Background()))
 
 	require.Equal(t, 1, cc.puts)
 
@@ -110,7 +180,7 @@ func TestService_PutOrdersFromDbToCache_SkipsInvalid_LogsWarn(t *testing.T) {
 	require.True(t, found, "expected warn log for invalid order")
 }
 
-func (c *cacheStub) PutOrder(id string, o models.Order) {
+func (c *cacheStub) PutOrder(_ context.Context, id string, o models.Order) {
 	if c.m == nil {
 		c.m = map[string]models.Order{}
 	}
@@ -118,8 +188,15 @@ func (c *cacheStub) PutOrder(id string, o models.Order) {
 	c.putCount++
 }
 
-func (c *cacheStub) GetOrder(uid string) (models.Order, error) { return c.m[uid], nil }
-func (c *cacheStub) GetAllOrders() ([]models.Order, error) {
+func (c *cacheStub) GetOrder(_ context.Context, uid string) (models.Order, error) {
+	o, ok := c.m[uid]
+	if !ok {
+		return o, fmt.Errorf("order %s not found", uid)
+	}
+	return o, nil
+}
+func (c *cacheStub) DeleteOrder(_ context.Context, uid string) { delete(c.m, uid) }
+func (c *cacheStub) GetAllOrders(_ context.Context) ([]models.Order, error) {
 	var a []models.Order
 	for _, v := range c.m {
 		a = append(a, v)
@@ -187,7 +264,7 @@ func TestService_PutDbOrder_DelegatesToRepo(t *testing.T) {
 
 	ord := makeValidOrder(strings.Repeat("a", 19))
 
-	if err := s.PutDbOrder(ord); err != nil {
+	if err := s.PutDbOrder(context.Background(), ord); err != nil {
 		t.Fatalf("unexpected validation error: %v", err)
 	}
 	if !fr.called {
@@ -199,7 +276,7 @@ func TestService_GetDbOrder_OK(t *testing.T) {
 	p := &pgStub{getResp: models.Order{OrderUid: strings.Repeat("u", 19)}}
 	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}})
 
-	out, err := s.GetDbOrder(strings.Repeat("u", 19))
+	out, err := s.GetDbOrder(context.Background(), strings.Repeat("u", 19))
 	require.NoError(t, err)
 	require.Equal(t, strings.Repeat("u", 19), out.OrderUid)
 }
@@ -208,7 +285,7 @@ func TestService_GetDbOrder_NotFound_Maps(t *testing.T) {
 	p := &pgStub{getErr: gorm.ErrRecordNotFound}
 	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}})
 
-	_, err := s.GetDbOrder("nope")
+	_, err := s.GetDbOrder(context.Background(), "nope")
 	require.ErrorIs(t, err, svc.ErrNotFound)
 }
 
@@ -246,13 +323,13 @@ func TestService_CacheMethods(t *testing.T) {
 	s := svc.NewService(&repository.Repository{OrderCache: c, OrderPostgres: &pgStub{}})
 
 	order := models.Order{OrderUid: "u1"}
-	s.PutCachedOrder(order)
+	s.PutCachedOrder(context.Background(), order)
 
-	got, err := s.GetCachedOrder("u1")
+	got, err := s.GetCachedOrder(context.Background(), "u1")
 	require.NoError(t, err)
 	require.Equal(t, order, got)
 
-	all, err := s.GetAllCachedOrders()
+	all, err := s.GetAllCachedOrders(context.Background())
 	require.NoError(t, err)
 	require.Len(t, all, 1)
 	require.Equal(t, order, all[0])
@@ -263,11 +340,11 @@ func TestService_DbMethods(t *testing.T) {
 	c := &cacheStub{}
 	s := svc.NewService(&repository.Repository{OrderCache: c, OrderPostgres: p})
 
-	orders, err := s.GetAllDbOrders()
+	orders, err := s.GetAllDbOrders(context.Background())
 	require.NoError(t, err)
 	require.Len(t, orders, 0)
 
-	err = s.PutOrdersFromDbToCache()
+	err = s.PutOrdersFromDbToCache(context.Background())
 	require.NoError(t, err)
 }
 
@@ -276,7 +353,7 @@ func TestService_PutOrdersFromDbToCache_PropagatesError(t *testing.T) {
 	c := &cacheStub{}
 	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
 
-	err := s.PutOrdersFromDbToCache()
+	err := s.PutOrdersFromDbToCache(context.Background())
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "db fail")
 	require.Equal(t, 0, c.putCount)
@@ -298,6 +375,230 @@ func TestService_HandleMessage_CreateOrUpdate_Error(t *testing.T) {
 	require.False(t, ok, "order must not be cached on repo error")
 }
 
+func TestService_HandleMessage_StaleVersion_Skipped(t *testing.T) {
+	p := &pgStub{createOrUpdateErr: fmt.Errorf("upsert: %w", storage.ErrStaleVersion)}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
+
+	msg := makeValidOrder(strings.Repeat("s", 19))
+	b, _ := json.Marshal(msg)
+
+	err := s.HandleMessage(context.Background(), b)
+	require.ErrorIs(t, err, svc.ErrStale)
+
+	_, ok := c.m[msg.OrderUid]
+	require.False(t, ok, "stale order must not be cached")
+}
+
+func TestService_HandleMessage_ConstraintViolation_NotRetryable(t *testing.T) {
+	p := &pgStub{createOrUpdateErr: &storage.ConstraintError{
+		Kind:       storage.ErrCheck,
+		Table:      "items",
+		Column:     "rid",
+		Constraint: "chk_items_rid_len",
+		Err:        fmt.Errorf("pq: new row violates check constraint"),
+	}}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
+
+	msg := makeValidOrder(strings.Repeat("c", 19))
+	b, _ := json.Marshal(msg)
+
+	err := s.HandleMessage(context.Background(), b)
+	require.ErrorIs(t, err, svc.ErrValidation)
+	require.ErrorIs(t, err, storage.ErrCheck)
+	require.Contains(t, err.Error(), "chk_items_rid_len")
+	var ve *svc.ValidationError
+	require.ErrorAs(t, err, &ve)
+	require.Equal(t, []svc.FieldError{{Field: "items.rid", Rule: "constraint", Param: "chk_items_rid_len", Message: "violates chk_items_rid_len"}}, ve.Errors)
+	require.NotContains(t, c.m, msg.OrderUid)
+}
+
+func TestService_HandleMessage_StoresOffsetWithOrder(t *testing.T) {
+	p := &pgStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}}, svc.WithVersionSource(svc.VersionFromProducer))
+
+	msg := makeValidOrder(strings.Repeat("d", 19))
+	payload := func(version int64) []byte {
+		msg.Version = version
+		b, _ := json.Marshal(msg)
+		return b
+	}
+
+	meta := svc.MessageMeta{Topic: "orders", Partition: 3, Offset: 42}
+	require.NoError(t, s.HandleMessage(svc.WithMessageMeta(context.Background(), meta), payload(1)))
+	require.Nil(t, p.writeOpts.Offset, "offset must not be stored without a group")
+
+	meta.Group = "order-svc"
+	require.NoError(t, s.HandleMessage(svc.WithMessageMeta(context.Background(), meta), payload(2)))
+	require.Equal(t, &storage.Offset{Group: "order-svc", Topic: "orders", Partition: 3, Offset: 42}, p.writeOpts.Offset)
+
+	p.createOrUpdateErr = fmt.Errorf("tx: %w", storage.ErrOffsetProcessed)
+	err := s.HandleMessage(svc.WithMessageMeta(context.Background(), meta), payload(3))
+	require.ErrorIs(t, err, svc.ErrStale)
+}
+
+func TestService_HandleMessage_VersionResolution(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	header := map[string][]byte{svc.VersionHeader: []byte("9")}
+
+	cases := []struct {
+		name    string
+		source  svc.VersionSource
+		version int64
+		meta    *svc.MessageMeta
+		want    int64
+		wantErr bool
+	}{
+		{name: "producer: payload field wins", source: svc.VersionFromProducer, version: 7, meta: &svc.MessageMeta{Headers: header, Time: ts}, want: 7},
+		{name: "producer: header", source: svc.VersionFromProducer, meta: &svc.MessageMeta{Headers: header, Time: ts}, want: 9},
+		{name: "producer: bad header is rejected", source: svc.VersionFromProducer, meta: &svc.MessageMeta{Headers: map[string][]byte{svc.VersionHeader: []byte("x")}, Time: ts}, wantErr: true},
+		{name: "producer: no timestamp fallback", source: svc.VersionFromProducer, meta: &svc.MessageMeta{Time: ts}, wantErr: true},
+		{name: "timestamp", source: svc.VersionFromTimestamp, meta: &svc.MessageMeta{Time: ts}, want: ts.UnixMilli()},
+		{name: "timestamp: payload and header ignored", source: svc.VersionFromTimestamp, version: 7, meta: &svc.MessageMeta{Headers: header, Time: ts}, want: ts.UnixMilli()},
+		{name: "timestamp: none known", source: svc.VersionFromTimestamp, version: 7, meta: &svc.MessageMeta{Headers: header}, want: 0},
+		{name: "no meta keeps the payload", source: svc.VersionFromTimestamp, version: 7, want: 7},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &pgStub{}
+			s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}}, svc.WithVersionSource(tc.source))
+
+			msg := makeValidOrder(strings.Repeat("v", 19))
+			msg.Version = tc.version
+			b, _ := json.Marshal(msg)
+
+			ctx := context.Background()
+			if tc.meta != nil {
+				ctx = svc.WithMessageMeta(ctx, *tc.meta)
+			}
+			err := s.HandleMessage(ctx, b)
+			if tc.wantErr {
+				require.ErrorIs(t, err, svc.ErrValidation)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, p.created.Version)
+		})
+	}
+}
+
+func TestParseVersionSource(t *testing.T) {
+	src, err := svc.ParseVersionSource("")
+	require.NoError(t, err)
+	require.Equal(t, svc.VersionFromTimestamp, src)
+	src, err = svc.ParseVersionSource(" producer ")
+	require.NoError(t, err)
+	require.Equal(t, svc.VersionFromProducer, src)
+	_, err = svc.ParseVersionSource("header")
+	require.Error(t, err)
+}
+
+type offsetsStub struct{ stored []storage.Offset }
+
+func (o *offsetsStub) StoreOffset(_ context.Context, off storage.Offset) error {
+	o.stored = append(o.stored, off)
+	return nil
+}
+func (o *offsetsStub) Offsets(context.Context, string, string) (map[int]int64, error) {
+	return nil, nil
+}
+
+func TestService_HandleMessage_SkipsDuplicates(t *testing.T) {
+	p := &pgStub{}
+	c := &cacheStub{}
+	offs := &offsetsStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c, ConsumerOffsets: offs},
+		svc.WithVersionSource(svc.VersionFromProducer))
+
+	duplicates := func() int64 {
+		v := expvar.Get("order_messages").(*expvar.Map).Get("duplicate")
+		if v == nil {
+			return 0
+		}
+		return v.(*expvar.Int).Value()
+	}
+	handle := func(o models.Order, offset int64) error {
+		t.Helper()
+		b, _ := json.Marshal(o)
+		meta := svc.MessageMeta{Group: "order-svc", Topic: "orders", Offset: offset}
+		p.created = models.Order{}
+		return s.HandleMessage(svc.WithMessageMeta(context.Background(), meta), b)
+	}
+
+	msg := makeValidOrder(strings.Repeat("h", 19))
+	msg.DateCreated = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	msg.Version = 5
+	require.NoError(t, handle(msg, 10))
+	require.NotEmpty(t, p.created.ContentHash)
+	require.Equal(t, 1, c.putCount)
+	require.Empty(t, offs.stored, "the offset is claimed with the order")
+
+	before := duplicates()
+	redelivered := msg
+	redelivered.DateCreated = msg.DateCreated.In(time.FixedZone("MSK", 3*60*60))
+	require.NoError(t, handle(redelivered, 11))
+	require.Empty(t, p.created.OrderUid, "a duplicate must not be written")
+	require.Equal(t, 1, c.putCount, "a duplicate must not be cached")
+	require.Equal(t, []storage.Offset{{Group: "order-svc", Topic: "orders", Offset: 11}}, offs.stored)
+	require.Equal(t, before+1, duplicates())
+
+	older := msg
+	older.Version = 4
+	require.NoError(t, handle(older, 12))
+	require.Empty(t, p.created.OrderUid)
+
+	newer := msg
+	newer.Version = 6
+	require.NoError(t, handle(newer, 13))
+	require.Equal(t, int64(6), p.created.Version, "a newer version is written even when unchanged")
+
+	changed := newer
+	changed.Items = append([]models.Item(nil), newer.Items...)
+	changed.Items[0].Name = "other"
+	require.NoError(t, handle(changed, 14))
+	require.Equal(t, "other", p.created.Items[0].Name)
+	require.Equal(t, 3, c.putCount)
+	require.Equal(t, before+2, duplicates())
+
+	// An order the cache does not hold is compared by the write.
+	c.m = map[string]models.Order{}
+	p.createOrUpdateErr = storage.ErrUnchanged
+	require.NoError(t, handle(changed, 15))
+	require.Equal(t, &storage.Offset{Group: "order-svc", Topic: "orders", Offset: 15}, p.writeOpts.Offset,
+		"the write stores the offset of an unchanged order")
+	require.Len(t, offs.stored, 2, "the offset is not stored again")
+	require.Empty(t, c.m, "an unchanged order is not cached")
+	require.Equal(t, before+3, duplicates())
+
+	p.createOrUpdateErr = nil
+	changed.Version = 7
+	require.NoError(t, handle(changed, 16))
+	require.Equal(t, int64(7), p.created.Version)
+	require.Equal(t, before+3, duplicates())
+}
+
+func TestService_HandleMessage_ValidationError(t *testing.T) {
+	p := &pgStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}})
+
+	msg := makeValidOrder(strings.Repeat("v", 19))
+	msg.Items[0].Rid = "short"
+	msg.Payment.Currency = ""
+	b, _ := json.Marshal(msg)
+
+	err := s.HandleMessage(context.Background(), b)
+	require.ErrorIs(t, err, svc.ErrValidation, "validator errors are validation errors")
+	var ve *svc.ValidationError
+	require.ErrorAs(t, err, &ve)
+	require.ElementsMatch(t, []svc.FieldError{
+		{Field: "payment.currency", Rule: "required", Message: "is required"},
+		{Field: "items[0].rid", Rule: "len", Param: "21", Message: "must be 21 characters long"},
+	}, ve.Errors)
+	require.Empty(t, p.created.OrderUid, "invalid orders are not stored")
+}
+
 func TestPutDbOrder_ValidationFails(t *testing.T) {
 	r := &repository.Repository{
 		OrderPostgres: &fakeOrderRepo{},
@@ -312,7 +613,7 @@ func TestPutDbOrder_ValidationFails(t *testing.T) {
 		Payment:  nil,
 	}
 
-	if err := s.PutDbOrder(bad); err == nil {
+	if err := s.PutDbOrder(context.Background(), bad); err == nil {
 		t.Fatal("expected validation error, got nil")
 	}
 }
@@ -353,10 +654,775 @@ func TestPutDbOrder_ValidCallsRepo(t *testing.T) {
 		}},
 	}
 
-	if err := s.PutDbOrder(good); err != nil {
+	if err := s.PutDbOrder(context.Background(), good); err != nil {
 		t.Fatalf("unexpected validation error: %v", err)
 	}
 	if !fr.called {
 		t.Fatal("expected repo.Create to be called for valid order")
 	}
 }
+
+type revisionsStub struct {
+	revs    []models.OrderRevision
+	revsErr error
+	asOf    models.Order
+	asOfErr error
+}
+
+func (r *revisionsStub) Revisions(context.Context, string) ([]models.OrderRevision, error) {
+	return r.revs, r.revsErr
+}
+func (r *revisionsStub) GetAsOf(context.Context, string, time.Time) (models.Order, error) {
+	return r.asOf, r.asOfErr
+}
+
+func TestService_Revisions_Unsupported(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+
+	_, err := s.GetOrderRevisions(context.Background(), "u1")
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+	_, err = s.GetOrderAsOf(context.Background(), "u1", time.Now())
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+}
+
+func TestService_Revisions_NotFound_Maps(t *testing.T) {
+	rs := &revisionsStub{asOfErr: gorm.ErrRecordNotFound}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderRevisions: rs})
+
+	_, err := s.GetOrderRevisions(context.Background(), "u1")
+	require.ErrorIs(t, err, svc.ErrNotFound)
+	_, err = s.GetOrderAsOf(context.Background(), "u1", time.Now())
+	require.ErrorIs(t, err, svc.ErrNotFound)
+}
+
+func TestService_Revisions_OK(t *testing.T) {
+	rs := &revisionsStub{
+		revs: []models.OrderRevision{{ID: 1, OrderUid: "u1"}, {ID: 2, OrderUid: "u1"}},
+		asOf: models.Order{OrderUid: "u1", Version: 5},
+	}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderRevisions: rs})
+
+	revs, err := s.GetOrderRevisions(context.Background(), "u1")
+	require.NoError(t, err)
+	require.Len(t, revs, 2)
+
+	o, err := s.GetOrderAsOf(context.Background(), "u1", time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), o.Version)
+}
+
+func TestService_DeleteOrder_SoftAndHard_EvictsCache(t *testing.T) {
+	p := &pgStub{}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
+
+	c.PutOrder(context.Background(), "u1", models.Order{OrderUid: "u1"})
+	require.NoError(t, s.DeleteOrder(context.Background(), "u1", false))
+	require.Equal(t, "u1", p.deleted)
+	require.Empty(t, p.hardDeleted)
+	_, ok := c.m["u1"]
+	require.False(t, ok)
+
+	c.PutOrder(context.Background(), "u2", models.Order{OrderUid: "u2"})
+	require.NoError(t, s.DeleteOrder(context.Background(), "u2", true))
+	require.Equal(t, "u2", p.hardDeleted)
+	_, ok = c.m["u2"]
+	require.False(t, ok)
+}
+
+func TestService_DeleteOrder_NotFound_StillEvicts(t *testing.T) {
+	p := &pgStub{deleteErr: gorm.ErrRecordNotFound}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
+
+	c.PutOrder(context.Background(), "u1", models.Order{OrderUid: "u1"})
+	require.ErrorIs(t, s.DeleteOrder(context.Background(), "u1", false), svc.ErrNotFound)
+	_, ok := c.m["u1"]
+	require.False(t, ok)
+}
+
+func TestService_DeleteOrder_RepoError_KeepsCache(t *testing.T) {
+	p := &pgStub{deleteErr: fmt.Errorf("db down")}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c})
+
+	c.PutOrder(context.Background(), "u1", models.Order{OrderUid: "u1"})
+	err := s.DeleteOrder(context.Background(), "u1", true)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, svc.ErrNotFound)
+	_, ok := c.m["u1"]
+	require.True(t, ok)
+}
+
+type searchStub struct {
+	q             string
+	limit, offset int
+}
+
+func (s *searchStub) Search(_ context.Context, q string, limit, offset int) (models.SearchResult, error) {
+	s.q, s.limit, s.offset = q, limit, offset
+	return models.SearchResult{Limit: limit, Offset: offset}, nil
+}
+
+func TestService_SearchOrders_NormalizesPaging(t *testing.T) {
+	ss := &searchStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderSearch: ss})
+
+	_, err := s.SearchOrders(context.Background(), "  sabo ", 0, -3)
+	require.NoError(t, err)
+	require.Equal(t, "sabo", ss.q)
+	require.Equal(t, svc.DefaultSearchLimit, ss.limit)
+	require.Equal(t, 0, ss.offset)
+
+	_, err = s.SearchOrders(context.Background(), "sabo", 1000, 40)
+	require.NoError(t, err)
+	require.Equal(t, svc.MaxSearchLimit, ss.limit)
+	require.Equal(t, 40, ss.offset)
+}
+
+func TestService_SearchOrders_Errors(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err := s.SearchOrders(context.Background(), "sabo", 10, 0)
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderSearch: &searchStub{}})
+	_, err = s.SearchOrders(context.Background(), "   ", 10, 0)
+	require.ErrorIs(t, err, svc.ErrValidation)
+}
+
+type lookupStub struct {
+	email, phone string
+	limit        int
+}
+
+func (l *lookupStub) FindByContact(_ context.Context, email, phone string, limit int) ([]models.Order, error) {
+	l.email, l.phone, l.limit = email, phone, limit
+	return nil, nil
+}
+
+func TestService_LookupOrders(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err := s.LookupOrders(context.Background(), "a@b.c", "", 10)
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	ls := &lookupStub{}
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderLookup: ls})
+	orders, err := s.LookupOrders(context.Background(), " a@b.c ", "", 0)
+	require.NoError(t, err)
+	require.NotNil(t, orders, "no match is an empty list, not null")
+	require.Equal(t, "a@b.c", ls.email)
+	require.Equal(t, svc.DefaultSearchLimit, ls.limit)
+
+	_, err = s.LookupOrders(context.Background(), "", "+7 900", 1000)
+	require.NoError(t, err)
+	require.Equal(t, "+7 900", ls.phone)
+	require.Equal(t, svc.MaxSearchLimit, ls.limit)
+
+	_, err = s.LookupOrders(context.Background(), " ", "", 10)
+	require.ErrorIs(t, err, svc.ErrValidation)
+	_, err = s.LookupOrders(context.Background(), "", "n/a", 10)
+	require.ErrorIs(t, err, svc.ErrValidation)
+}
+
+type statsStub struct {
+	filter models.StatsFilter
+}
+
+func (s *statsStub) Revenue(_ context.Context, f models.StatsFilter) ([]models.RevenueRow, error) {
+	s.filter = f
+	return []models.RevenueRow{}, nil
+}
+
+func (s *statsStub) TopBrands(_ context.Context, f models.StatsFilter) ([]models.BrandRow, error) {
+	s.filter = f
+	return []models.BrandRow{}, nil
+}
+
+func (s *statsStub) BasketSize(_ context.Context, f models.StatsFilter) ([]models.BasketRow, error) {
+	s.filter = f
+	return []models.BasketRow{}, nil
+}
+
+func TestService_Stats_Defaults(t *testing.T) {
+	st := &statsStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderStats: st})
+
+	_, err := s.RevenueStats(context.Background(), models.StatsFilter{Currency: " usd "})
+	require.NoError(t, err)
+	require.Equal(t, models.GroupByDay, st.filter.GroupBy)
+	require.Equal(t, "USD", st.filter.Currency)
+	require.Equal(t, svc.DefaultStatsRange, st.filter.To.Sub(st.filter.From))
+
+	_, err = s.BasketStats(context.Background(), models.StatsFilter{})
+	require.NoError(t, err)
+	require.Equal(t, models.GroupByNone, st.filter.GroupBy)
+
+	_, err = s.TopBrandStats(context.Background(), models.StatsFilter{Limit: 1000})
+	require.NoError(t, err)
+	require.Equal(t, svc.MaxTopBrandsLimit, st.filter.Limit)
+}
+
+func TestService_Stats_Errors(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err := s.RevenueStats(context.Background(), models.StatsFilter{})
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderStats: &statsStub{}})
+	now := time.Now()
+
+	_, err = s.RevenueStats(context.Background(), models.StatsFilter{GroupBy: "hour"})
+	require.ErrorIs(t, err, svc.ErrValidation)
+
+	_, err = s.BasketStats(context.Background(), models.StatsFilter{From: now, To: now.Add(-time.Hour)})
+	require.ErrorIs(t, err, svc.ErrValidation)
+
+	_, err = s.TopBrandStats(context.Background(), models.StatsFilter{From: now.AddDate(-2, 0, 0), To: now})
+	require.ErrorIs(t, err, svc.ErrValidation)
+}
+
+type outboxStub struct {
+	limit  int
+	before time.Time
+}
+
+func (s *outboxStub) RelayOutbox(_ context.Context, limit int, publish func([]models.OrderEvent) error) (int, error) {
+	s.limit = limit
+	return 1, publish([]models.OrderEvent{{OrderUid: "b563feb7b2b84b6test"}})
+}
+
+func (s *outboxStub) PurgeOutbox(_ context.Context, before time.Time) (int64, error) {
+	s.before = before
+	return 3, nil
+}
+
+func TestService_OrderEvents(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err := s.RelayOrderEvents(context.Background(), 10, func([]models.OrderEvent) error { return nil })
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+	_, err = s.PurgeOrderEvents(context.Background(), time.Now())
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	ob := &outboxStub{}
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderOutbox: ob})
+
+	var got []models.OrderEvent
+	n, err := s.RelayOrderEvents(context.Background(), 10, func(events []models.OrderEvent) error {
+		got = events
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, 10, ob.limit)
+	require.Len(t, got, 1)
+
+	before := time.Now()
+	purged, err := s.PurgeOrderEvents(context.Background(), before)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, purged)
+	require.Equal(t, before, ob.before)
+}
+
+type messagesStub struct {
+	saved []models.RawMessage
+}
+
+func (s *messagesStub) SaveRawMessage(_ context.Context, m models.RawMessage) error {
+	s.saved = append(s.saved, m)
+	return nil
+}
+
+func (s *messagesStub) RawMessages(_ context.Context, uid string) ([]models.RawMessage, error) {
+	var out []models.RawMessage
+	for _, m := range s.saved {
+		if m.OrderUid == uid {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func TestService_RawMessages(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	require.ErrorIs(t, s.RecordMessage(context.Background(), models.RawMessage{}), svc.ErrUnsupported)
+	_, err := s.GetOrderMessages(context.Background(), "b563feb7b2b84b6test")
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	ms := &messagesStub{}
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderMessages: ms})
+
+	require.NoError(t, s.RecordMessage(context.Background(), models.RawMessage{
+		Offset:  1,
+		Payload: `{"order_uid":"b563feb7b2b84b6test","track_number":""}`,
+		Outcome: models.MessageInvalid,
+	}))
+	require.NoError(t, s.RecordMessage(context.Background(), models.RawMessage{Offset: 2, Payload: `not json`, Outcome: models.MessageInvalid}))
+
+	msgs, err := s.GetOrderMessages(context.Background(), "b563feb7b2b84b6test")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.EqualValues(t, 1, msgs[0].Offset)
+	require.Equal(t, "", ms.saved[1].OrderUid)
+
+	_, err = s.GetOrderMessages(context.Background(), "missing")
+	require.ErrorIs(t, err, svc.ErrNotFound)
+}
+
+type partitionsStub struct {
+	expired []string
+	orders  map[string][]models.Order
+	dropped []string
+	ahead   int
+}
+
+func (p *partitionsStub) EnsurePartitions(_ context.Context, monthsAhead int) error {
+	p.ahead = monthsAhead
+	return nil
+}
+
+func (p *partitionsStub) ExpiredPartitions(_ context.Context, before time.Time) ([]string, error) {
+	return p.expired, nil
+}
+
+func (p *partitionsStub) DropPartition(_ context.Context, name string, archive storage.Archiver) (int, error) {
+	orders := p.orders[name]
+	if archive != nil {
+		for _, o := range orders {
+			if err := archive.Archive(o); err != nil {
+				return 0, err
+			}
+		}
+		if err := archive.Close(); err != nil {
+			return 0, err
+		}
+	}
+	p.dropped = append(p.dropped, name)
+	return len(orders), nil
+}
+
+func TestService_ApplyRetention_ArchivesDropsAndEvicts(t *testing.T) {
+	old := models.Order{OrderUid: "old0000000000000001", DateCreated: time.Date(2021, 11, 26, 0, 0, 0, 0, time.UTC)}
+	fresh := models.Order{OrderUid: "new0000000000000001", DateCreated: time.Now()}
+
+	parts := &partitionsStub{
+		expired: []string{"orders_p2021_11"},
+		orders:  map[string][]models.Order{"orders_p2021_11": {old}},
+	}
+	cache := &cacheStub{}
+	cache.PutOrder(context.Background(), old.OrderUid, old)
+	cache.PutOrder(context.Background(), fresh.OrderUid, fresh)
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: cache, OrderPartitions: parts})
+
+	dir := t.TempDir()
+	report, err := s.ApplyRetention(context.Background(), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"orders_p2021_11"}, parts.dropped)
+	require.Equal(t, 1, report.EvictedOrders)
+	require.Contains(t, cache.m, fresh.OrderUid)
+	require.NotContains(t, cache.m, old.OrderUid)
+
+	require.Len(t, report.Partitions, 1)
+	require.Equal(t, 1, report.Partitions[0].Orders)
+	require.Equal(t, filepath.Join(dir, "orders_p2021_11.ndjson.gz"), report.Partitions[0].Archive)
+
+	f, err := os.Open(report.Partitions[0].Archive)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 1)
+	var got models.Order
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	require.Equal(t, old.OrderUid, got.OrderUid)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary archive files must not be left behind")
+}
+
+func TestService_ApplyRetention_WithoutArchiveAndUnsupported(t *testing.T) {
+	parts := &partitionsStub{expired: []string{"orders_p2021_10", "orders_p2021_11"}}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}, OrderPartitions: parts})
+
+	report, err := s.ApplyRetention(context.Background(), time.Now(), "")
+	require.NoError(t, err)
+	require.Equal(t, parts.expired, parts.dropped)
+	require.Len(t, report.Partitions, 2)
+	require.Empty(t, report.Partitions[0].Archive)
+
+	require.NoError(t, s.PrepareOrderPartitions(context.Background(), 3))
+	require.Equal(t, 3, parts.ahead)
+
+	s = svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err = s.ApplyRetention(context.Background(), time.Now(), "")
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+	require.ErrorIs(t, s.PrepareOrderPartitions(context.Background(), 3), svc.ErrUnsupported)
+}
+
+type erasureStub struct {
+	customerID string
+	receipt    models.ErasureReceipt
+	err        error
+	erased     map[string]bool
+}
+
+func (e *erasureStub) EraseCustomer(_ context.Context, customerID string) (models.ErasureReceipt, error) {
+	e.customerID = customerID
+	return e.receipt, e.err
+}
+
+func (e *erasureStub) ErasureReceipts(_ context.Context, customerID string) ([]models.ErasureReceipt, error) {
+	if customerID != e.receipt.CustomerId {
+		return nil, nil
+	}
+	return []models.ErasureReceipt{e.receipt}, nil
+}
+
+func (e *erasureStub) ErasedOrders(_ context.Context, uids []string) (map[string]bool, error) {
+	out := map[string]bool{}
+	for _, uid := range uids {
+		if e.erased[uid] {
+			out[uid] = true
+		}
+	}
+	return out, e.err
+}
+
+func TestService_ErasedOrdersStayErased(t *testing.T) {
+	erased, other := makeValidOrder(strings.Repeat("e", 19)), makeValidOrder(strings.Repeat("o", 19))
+	es := &erasureStub{erased: map[string]bool{erased.OrderUid: true}}
+	p, c, imp, ms := &pgStub{}, &cacheStub{}, &importStub{}, &messagesStub{}
+	s := svc.NewService(&repository.Repository{
+		OrderPostgres: p, OrderCache: c, OrderImport: imp, OrderMessages: ms, CustomerErasure: es,
+	})
+
+	// A redelivered message of an erased order.
+	payload, _ := json.Marshal(erased)
+	require.NoError(t, s.HandleMessage(context.Background(), payload))
+	require.True(t, pii.DeliveryErased(p.created.Delivery), "got %+v", *p.created.Delivery)
+	require.Equal(t, erased.Delivery.City, p.created.Delivery.City, "only personal data is erased")
+
+	require.NoError(t, s.RecordMessage(context.Background(), models.RawMessage{Payload: string(payload)}))
+	require.Equal(t, erased.OrderUid, ms.saved[0].OrderUid)
+	require.NotContains(t, ms.saved[0].Payload, erased.Delivery.Name, "the archived payload is erased")
+
+	payload, _ = json.Marshal(other)
+	require.NoError(t, s.HandleMessage(context.Background(), payload))
+	require.Equal(t, other.Delivery.Name, p.created.Delivery.Name)
+
+	imp.errs = make([]error, 2)
+	orders := []models.Order{erased, other}
+	_, err := s.ImportOrders(context.Background(), orders)
+	require.NoError(t, err)
+	require.True(t, pii.DeliveryErased(imp.orders[0].Delivery))
+	require.Equal(t, other.Delivery.Name, imp.orders[1].Delivery.Name)
+	require.Equal(t, erased.Delivery.Name, orders[0].Delivery.Name, "the orders of the caller are kept")
+
+	es.err = fmt.Errorf("db down")
+	c.m = nil
+	payload, _ = json.Marshal(erased)
+	require.ErrorContains(t, s.HandleMessage(context.Background(), payload), "db down")
+	_, err = s.ImportOrders(context.Background(), orders)
+	require.ErrorContains(t, err, "db down")
+}
+
+func TestService_EraseCustomer(t *testing.T) {
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: &cacheStub{}})
+	_, err := s.EraseCustomer(context.Background(), "cust")
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+	_, err = s.GetErasureReceipts(context.Background(), "cust")
+	require.ErrorIs(t, err, svc.ErrUnsupported)
+
+	order := func(uid, customer, name string) models.Order {
+		return models.Order{OrderUid: uid, CustomerId: customer, Delivery: &models.Delivery{Name: name}}
+	}
+	erased := order("live-order-00000001", "cust", "erased")
+	pg := &pgStub{getResp: erased}
+	cache := &cacheStub{m: map[string]models.Order{
+		"live-order-00000001": order("live-order-00000001", "cust", "Test Testov"),
+		"other-order-0000001": order("other-order-0000001", "othr", "Other Customer"),
+	}}
+	es := &erasureStub{receipt: models.ErasureReceipt{ID: 3, CustomerId: "cust", OrderUids: []string{"live-order-00000001"}}}
+	s = svc.NewService(&repository.Repository{OrderPostgres: pg, OrderCache: cache, CustomerErasure: es})
+
+	rec, err := s.EraseCustomer(context.Background(), " cust ")
+	require.NoError(t, err)
+	require.Equal(t, "cust", es.customerID)
+	require.Equal(t, int64(3), rec.ID)
+	require.Equal(t, erased, cache.m["live-order-00000001"], "cached orders are replaced with the erased ones")
+	require.Equal(t, "Other Customer", cache.m["other-order-0000001"].Delivery.Name, "other customers are untouched")
+
+	pg.getErr = gorm.ErrRecordNotFound
+	cache.m["cached-only-0000001"] = order("cached-only-0000001", "cust", "Test Testov")
+	_, err = s.EraseCustomer(context.Background(), "cust")
+	require.NoError(t, err)
+	require.NotContains(t, cache.m, "live-order-00000001", "orders that are no longer live are evicted")
+	require.NotContains(t, cache.m, "cached-only-0000001", "cached orders of the customer are refreshed too")
+	require.Contains(t, cache.m, "other-order-0000001")
+
+	_, err = s.EraseCustomer(context.Background(), "  ")
+	require.ErrorIs(t, err, svc.ErrValidation)
+
+	es.err = fmt.Errorf("db down")
+	_, err = s.EraseCustomer(context.Background(), "cust")
+	require.EqualError(t, err, "db down")
+
+	receipts, err := s.GetErasureReceipts(context.Background(), "cust")
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	_, err = s.GetErasureReceipts(context.Background(), "none")
+	require.ErrorIs(t, err, svc.ErrNotFound)
+}
+
+type importStub struct {
+	orders []models.Order
+	errs   []error
+	err    error
+}
+
+func (i *importStub) CreateOrUpdateBatch(_ context.Context, orders []models.Order) ([]error, error) {
+	i.orders = orders
+	return i.errs, i.err
+}
+
+func TestService_ImportOrders(t *testing.T) {
+	a, b := makeValidOrder(strings.Repeat("a", 19)), makeValidOrder(strings.Repeat("b", 19))
+
+	imp := &importStub{errs: []error{nil, fmt.Errorf("upsert: %w", storage.ErrStaleVersion)}}
+	c := &cacheStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: c, OrderImport: imp})
+	errs, err := s.ImportOrders(context.Background(), []models.Order{a, b})
+	require.NoError(t, err)
+	require.Len(t, imp.orders, 2)
+	require.NoError(t, errs[0])
+	require.ErrorIs(t, errs[1], svc.ErrStale)
+	require.Contains(t, c.m, a.OrderUid)
+	require.NotContains(t, c.m, b.OrderUid, "rejected orders are not cached")
+
+	imp.err = fmt.Errorf("db down")
+	_, err = s.ImportOrders(context.Background(), []models.Order{a})
+	require.ErrorContains(t, err, "db down")
+
+	// Without batch support the orders are stored one by one.
+	p := &pgStub{createOrUpdateErr: &storage.ConstraintError{Kind: storage.ErrCheck, Err: fmt.Errorf("check")}}
+	s = svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}})
+	errs, err = s.ImportOrders(context.Background(), []models.Order{a, b})
+	require.NoError(t, err)
+	require.Equal(t, b.OrderUid, p.created.OrderUid)
+	require.ErrorIs(t, errs[0], svc.ErrValidation)
+	require.ErrorIs(t, errs[1], storage.ErrCheck)
+
+	p.createOrUpdateErr = fmt.Errorf("db down")
+	_, err = s.ImportOrders(context.Background(), []models.Order{a})
+	require.ErrorContains(t, err, "db down")
+}
+
+func TestService_ImportOrders_SkipsDuplicates(t *testing.T) {
+	c := &cacheStub{}
+	imp := &importStub{errs: []error{storage.ErrUnchanged}}
+	s := svc.NewService(&repository.Repository{OrderPostgres: &pgStub{}, OrderCache: c, OrderImport: imp})
+
+	decode := func(o models.Order) models.Order {
+		t.Helper()
+		b, _ := json.Marshal(o)
+		o, err := s.DecodeOrder(context.Background(), b)
+		require.NoError(t, err)
+		return o
+	}
+	cached, stored := decode(makeValidOrder(strings.Repeat("c", 19))), decode(makeValidOrder(strings.Repeat("s", 19)))
+	c.PutOrder(context.Background(), cached.OrderUid, cached)
+
+	errs, err := s.ImportOrders(context.Background(), []models.Order{cached, stored})
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, nil}, errs, "unchanged orders are not failures")
+	require.Equal(t, []models.Order{stored}, imp.orders, "cached duplicates are left out of the batch")
+	require.NotContains(t, c.m, stored.OrderUid, "orders the write left alone are not cached")
+
+	imp.orders = nil
+	_, err = s.ImportOrders(context.Background(), []models.Order{cached})
+	require.NoError(t, err)
+	require.Nil(t, imp.orders, "a batch of duplicates is not written at all")
+
+	// Without batch support the write reports them the same way.
+	p := &pgStub{createOrUpdateErr: storage.ErrUnchanged}
+	s = svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}})
+	errs, err = s.ImportOrders(context.Background(), []models.Order{stored})
+	require.NoError(t, err)
+	require.Equal(t, []error{nil}, errs)
+}
+
+func TestParseRuleActions(t *testing.T) {
+	actions, err := svc.ParseRuleActions(" goods_total=reject, item_track_number = off,,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]svc.RuleAction{"goods_total": svc.RuleReject, "item_track_number": svc.RuleOff}, actions)
+
+	actions, err = svc.ParseRuleActions("")
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	for _, bad := range []string{"goods_total", "goods_total=drop", "no_such_rule=warn"} {
+		_, err := svc.ParseRuleActions(bad)
+		require.Error(t, err, bad)
+	}
+	require.Equal(t, []string{"goods_total", "item_total_price", "item_track_number", "payment_amount"}, svc.RuleNames())
+}
+
+func TestService_BusinessRules(t *testing.T) {
+	consistent := func() models.Order {
+		o := makeValidOrder(strings.Repeat("r", 19))
+		o.Items[0].Price, o.Items[0].Sale, o.Items[0].TotalPrice = 453, 30, 317
+		o.Payment.GoodsTotal, o.Payment.DeliveryCost, o.Payment.CustomFee, o.Payment.Amount = 317, 1500, 0, 1817
+		return o
+	}
+	cases := map[string]func(o *models.Order){
+		"goods_total":       func(o *models.Order) { o.Payment.GoodsTotal, o.Payment.Amount = 300, 1800 },
+		"payment_amount":    func(o *models.Order) { o.Payment.Amount = 1818 },
+		"item_track_number": func(o *models.Order) { o.Items[0].TrackNumber = strings.Repeat("2", 14) },
+		"item_total_price":  func(o *models.Order) { o.Items[0].TotalPrice, o.Payment.GoodsTotal, o.Payment.Amount = 319, 319, 1819 },
+	}
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	for rule, breakIt := range cases {
+		t.Run(rule, func(t *testing.T) {
+			o := consistent()
+			b, _ := json.Marshal(o)
+			reject := svc.NewService(&repository.Repository{}, svc.WithRuleActions(map[string]svc.RuleAction{rule: svc.RuleReject}))
+			_, err := reject.DecodeOrder(context.Background(), b)
+			require.NoError(t, err, "a consistent order keeps every rule")
+
+			breakIt(&o)
+			b, _ = json.Marshal(o)
+			_, err = reject.DecodeOrder(context.Background(), b)
+			require.ErrorIs(t, err, svc.ErrValidation)
+			var ve *svc.ValidationError
+			require.ErrorAs(t, err, &ve)
+			require.Len(t, ve.Errors, 1)
+			require.Equal(t, rule, ve.Errors[0].Rule)
+			require.ErrorIs(t, reject.PutDbOrder(context.Background(), o), svc.ErrValidation)
+
+			hook.Reset()
+			warn := svc.NewService(&repository.Repository{})
+			_, err = warn.DecodeOrder(context.Background(), b)
+			require.NoError(t, err, "rules warn by default")
+			require.NotNil(t, hook.LastEntry())
+			require.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+			require.Equal(t, rule, hook.LastEntry().Data["rule"])
+
+			hook.Reset()
+			off := svc.NewService(&repository.Repository{}, svc.WithRuleActions(map[string]svc.RuleAction{rule: svc.RuleOff}))
+			_, err = off.DecodeOrder(context.Background(), b)
+			require.NoError(t, err)
+			require.Nil(t, hook.LastEntry())
+		})
+	}
+
+	o := consistent()
+	o.Items[0].TotalPrice = 318
+	b, _ := json.Marshal(o)
+	_, err := svc.NewService(&repository.Repository{}, svc.WithRuleActions(map[string]svc.RuleAction{"item_total_price": svc.RuleReject})).
+		DecodeOrder(context.Background(), b)
+	require.NoError(t, err, "the discounted price may be rounded up")
+}
+
+func writeProfiles(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestService_ValidationProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	writeProfiles(t, path, `
+header: x-order-source
+profiles:
+  ozon:
+    topics: [orders.ozon]
+    order:
+      entry: required,len=5
+      locale: oneof=ru en kz
+    items:
+      track_number: required,min=10,max=20
+  wb: {}
+`, time.Now().Add(-time.Hour))
+	profiles, err := svc.LoadProfiles(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ozon", "wb"}, profiles.Names())
+	require.True(t, profiles.Has("ozon"))
+	require.False(t, profiles.Has("orders.ozon"))
+	require.Equal(t, map[string][]string{"orders": {"entry", "locale"}, "items": {"track_number"}}, profiles.Overrides())
+
+	p := &pgStub{}
+	s := svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: &cacheStub{}}, svc.WithProfiles(profiles))
+
+	ozon := makeValidOrder(strings.Repeat("z", 19))
+	ozon.Entry, ozon.Locale = "OZONE", "kz"
+	ozon.Items[0].TrackNumber = "OZ-0001"
+	b, _ := json.Marshal(ozon)
+	ozon.Items[0].TrackNumber = "OZ-0000000001"
+	long, _ := json.Marshal(ozon)
+
+	byHeader := svc.MessageMeta{Topic: "orders", Headers: map[string][]byte{"x-order-source": []byte("ozon")}}
+	byTopic := svc.MessageMeta{Topic: "orders.ozon"}
+	other := svc.MessageMeta{Topic: "orders", Headers: map[string][]byte{"x-order-source": []byte("wb")}}
+
+	_, err = s.DecodeOrder(svc.WithMessageMeta(context.Background(), byHeader), long)
+	require.NoError(t, err, "the profile of the header applies")
+	_, err = s.DecodeOrder(svc.WithMessageMeta(context.Background(), byTopic), long)
+	require.NoError(t, err, "the profile of the topic applies")
+	_, err = s.DecodeOrder(svc.WithProfile(context.Background(), "ozon"), long)
+	require.NoError(t, err, "a named profile applies without a message")
+	_, err = s.DecodeOrder(svc.WithMessageMeta(context.Background(), byHeader), b)
+	var ve *svc.ValidationError
+	require.ErrorAs(t, err, &ve)
+	require.Equal(t, []svc.FieldError{{Field: "items[0].track_number", Rule: "min", Param: "10", Message: "must be at least 10 characters long"}}, ve.Errors)
+
+	for name, ctx := range map[string]context.Context{
+		"other profile": svc.WithMessageMeta(context.Background(), other),
+		"no message":    context.Background(),
+	} {
+		_, err = s.DecodeOrder(ctx, long)
+		require.ErrorIs(t, err, svc.ErrValidation, name)
+		require.ErrorContains(t, err, "entry: must be 4 characters long", name)
+	}
+
+	p.getAllResp = []models.Order{ozon}
+	c := &cacheStub{}
+	s = svc.NewService(&repository.Repository{OrderPostgres: p, OrderCache: c}, svc.WithProfiles(profiles))
+	require.NoError(t, s.PutOrdersFromDbToCache(context.Background()))
+	require.Contains(t, c.m, ozon.OrderUid, "stored orders valid under a profile are cached")
+
+	reloaded, err := profiles.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded, "an unchanged file is not reloaded")
+
+	writeProfiles(t, path, `{"profiles": {"ozon": {"order": {"entry": "required,lenn=5"}}}}`, time.Now())
+	_, err = profiles.Reload()
+	require.ErrorContains(t, err, "profile ozon")
+	_, err = s.DecodeOrder(svc.WithMessageMeta(context.Background(), byHeader), long)
+	require.NoError(t, err, "a broken file keeps the loaded profiles")
+
+	writeProfiles(t, path, `{"header": "x-order-source", "profiles": {"ozon": {"order": {"entry": "required,len=6"}}}}`, time.Now().Add(time.Second))
+	reloaded, err = profiles.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	_, err = s.DecodeOrder(svc.WithMessageMeta(context.Background(), byHeader), long)
+	require.ErrorContains(t, err, "entry: must be 6 characters long", "changes apply without a restart")
+
+	for name, data := range map[string]string{
+		"unknown field": `profiles: {ozon: {order: {entri: required}}}`,
+		"shared topic":  `profiles: {a: {topics: [orders]}, b: {topics: [orders]}}`,
+		"not yaml":      `profiles: [`,
+	} {
+		writeProfiles(t, path, data, time.Now())
+		_, err := svc.LoadProfiles(path)
+		require.Error(t, err, name)
+	}
+	for _, part := range []string{"delivery", "payment", "items"} {
+		writeProfiles(t, path, `profiles: {ozon: {order: {`+part+`: omitempty}}}`, time.Now())
+		_, err = svc.LoadProfiles(path)
+		require.ErrorContains(t, err, `"`+part+`" is a part of the order`)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"l0-demo/internal/models"
+	"l0-demo/internal/repository/storage"
+)
+
+const (
+	DefaultStatsRange     = 30 * 24 * time.Hour
+	MaxStatsRange         = 366 * 24 * time.Hour
+	DefaultTopBrandsLimit = 10
+	MaxTopBrandsLimit     = 100
+)
+
+func (s *Service) RevenueStats(ctx context.Context, f models.StatsFilter) ([]models.RevenueRow, error) {
+	if s.OrderStats == nil {
+		return nil, ErrUnsupported
+	}
+	f, err := normalizeStatsFilter(f, models.GroupByDay)
+	if err != nil {
+		return nil, err
+	}
+	return s.OrderStats.Revenue(ctx, f)
+}
+
+func (s *Service) TopBrandStats(ctx context.Context, f models.StatsFilter) ([]models.BrandRow, error) {
+	if s.OrderStats == nil {
+		return nil, ErrUnsupported
+	}
+	f, err := normalizeStatsFilter(f, models.GroupByNone)
+	if err != nil {
+		return nil, err
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultTopBrandsLimit
+	}
+	if f.Limit > MaxTopBrandsLimit {
+		f.Limit = MaxTopBrandsLimit
+	}
+	return s.OrderStats.TopBrands(ctx, f)
+}
+
+func (s *Service) BasketStats(ctx context.Context, f models.StatsFilter) ([]models.BasketRow, error) {
+	if s.OrderStats == nil {
+		return nil, ErrUnsupported
+	}
+	f, err := normalizeStatsFilter(f, models.GroupByNone)
+	if err != nil {
+		return nil, err
+	}
+	return s.OrderStats.BasketSize(ctx, f)
+}
+
+func normalizeStatsFilter(f models.StatsFilter, groupBy string) (models.StatsFilter, error) {
+	if f.To.IsZero() {
+		f.To = time.Now().UTC()
+	}
+	if f.From.IsZero() {
+		f.From = f.To.Add(-DefaultStatsRange)
+	}
+	if !f.From.Before(f.To) {
+		return f, fmt.Errorf("%w: from must be before to", ErrValidation)
+	}
+	if f.To.Sub(f.From) > MaxStatsRange {
+		return f, fmt.Errorf("%w: date range is longer than %d days", ErrValidation, int(MaxStatsRange.Hours()/24))
+	}
+
+	f.GroupBy = strings.ToLower(strings.TrimSpace(f.GroupBy))
+	if f.GroupBy == "" {
+		f.GroupBy = groupBy
+	}
+	if !storage.ValidGrouping(f.GroupBy) {
+		return f, fmt.Errorf("%w: unknown group_by %q", ErrValidation, f.GroupBy)
+	}
+	f.Currency = strings.ToUpper(strings.TrimSpace(f.Currency))
+	return f, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"l0-demo/internal/repository/storage"
+)
+
+// FieldError is one failed check of an order: the JSON path of the field,
+// the rule it broke, the parameter of the rule, if any, and a message for
+// people. Rule is a validate tag, such as len, or a business rule name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every check an order failed. It wraps
+// ErrValidation and, for an order the storage rejected, the storage error.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+
+	err error
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("validation failed: ")
+	for i, fe := range e.Errors {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s: %s", fe.Field, fe.Message)
+	}
+	return b.String()
+}
+
+func (e *ValidationError) Unwrap() []error {
+	if e.err == nil {
+		return []error{ErrValidation}
+	}
+	return []error{ErrValidation, e.err}
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonName)
+	return v
+}
+
+// jsonName names fields in errors by their JSON keys.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// validationError converts an error of the validator into a
+// *ValidationError.
+func validationError(err error) error {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return fmt.Errorf("%w: %v", ErrValidation, err)
+	}
+	out := &ValidationError{Errors: make([]FieldError, 0, len(verrs))}
+	for _, fe := range verrs {
+		// The namespace starts with the name of the validated struct.
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		out.Errors = append(out.Errors, FieldError{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe),
+		})
+	}
+	return out
+}
+
+// storageFields maps the tables of an order's children to their JSON path.
+var storageFields = map[string]string{
+	"deliveries": "delivery",
+	"payments":   "payment",
+	"items":      "items",
+}
+
+// constraintError converts a write rejected by a storage constraint into a
+// *ValidationError naming the field as closely as the storage reported it.
+// The rule is "constraint" and the param the name of the constraint.
+func constraintError(ce *storage.ConstraintError) *ValidationError {
+	field := storageFields[ce.Table]
+	if ce.Column != "" && ce.Column != "order_refer" {
+		if field != "" {
+			field += "."
+		}
+		field += ce.Column
+	}
+	if field == "" {
+		field = ce.Table
+	}
+
+	msg := "violates " + ce.Constraint
+	switch {
+	case errors.Is(ce, storage.ErrNotNull):
+		msg = "is required"
+	case errors.Is(ce, storage.ErrUnique):
+		msg = "already exists"
+	case errors.Is(ce, storage.ErrForeignKey):
+		msg = "references a missing order"
+	}
+	return &ValidationError{
+		Errors: []FieldError{{Field: field, Rule: "constraint", Param: ce.Constraint, Message: msg}},
+		err:    ce,
+	}
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Map:
+		unit = " elements"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "len":
+		return fmt.Sprintf("must be %s%s long", fe.Param(), unit)
+	case "min":
+		return fmt.Sprintf("must be at least %s%s long", fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("must be at most %s%s long", fe.Param(), unit)
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	}
+	if fe.Param() != "" {
+		return fmt.Sprintf("fails %s=%s", fe.Tag(), fe.Param())
+	}
+	return "fails " + fe.Tag()
+}